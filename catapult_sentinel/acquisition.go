@@ -0,0 +1,110 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+)
+
+// FolderStat is the aggregate size and newest modification time of the content
+// of a directory acquisition, cached against the directory's own mtime.
+type FolderStat struct {
+	Path         string `json:"path"`
+	DirModified  int64  `json:"dir_modified"`
+	Size         int64  `json:"size"`
+	LastModified int64  `json:"last_modified"`
+}
+
+func GetFolderStat(db *sql.DB, path string) (FolderStat, error) {
+	var stat FolderStat
+	err := db.QueryRow("SELECT path, dir_modified, size, last_modified FROM folder_stats WHERE path = ?", path).Scan(&stat.Path, &stat.DirModified, &stat.Size, &stat.LastModified)
+	if err != nil {
+		return FolderStat{}, err
+	}
+	return stat, nil
+}
+
+func PutFolderStat(db *sql.DB, stat FolderStat) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO folder_stats (path, dir_modified, size, last_modified) VALUES (?, ?, ?, ?)", stat.Path, stat.DirModified, stat.Size, stat.LastModified)
+	return err
+}
+
+// walkFolderStat walks a directory acquisition once and returns the total size
+// of its files and the newest modification time found inside it. The walk is
+// abandoned as soon as ctx is done.
+func walkFolderStat(ctx context.Context, walker *Walker, path string, info os.FileInfo) (FolderStat, error) {
+	stat := FolderStat{Path: path, DirModified: info.ModTime().Unix(), LastModified: info.ModTime().Unix()}
+	entries, err := walker.Walk(ctx, path, nil, nil)
+	if err != nil {
+		return stat, err
+	}
+	for _, entry := range entries {
+		if entry.Err != nil {
+			return stat, entry.Err
+		}
+		if !entry.Info.IsDir() {
+			stat.Size += entry.Info.Size()
+		}
+		if modified := entry.Info.ModTime().Unix(); modified > stat.LastModified {
+			stat.LastModified = modified
+		}
+	}
+	return stat, nil
+}
+
+// folderStat returns the aggregate size and newest mtime of a directory
+// acquisition. The cached value is reused while the directory mtime is
+// unchanged, but only once the acquisition has been found stable: files
+// growing inside a folder do not touch the folder's own mtime, so a folder
+// still being acquired is always walked. A walk that fails partway falls back
+// to the cached value, so that a transient error does not look like a size
+// change; without one the error is returned.
+func (s *Scanner) folderStat(ctx context.Context, location FolderWatchingLocation, path string, info os.FileInfo) (int64, int64, error) {
+	cached, cacheErr := GetFolderStat(s.DB, path)
+	if cacheErr == nil && cached.DirModified == info.ModTime().Unix() && s.isStable(path) {
+		return cached.Size, cached.LastModified, nil
+	}
+	stat, err := walkFolderStat(ctx, s.walker(location), path, info)
+	if err != nil {
+		if cacheErr == nil {
+			log.Println(err)
+			return cached.Size, cached.LastModified, nil
+		}
+		return 0, 0, err
+	}
+	if err := PutFolderStat(s.DB, stat); err != nil {
+		log.Println(err)
+	}
+	return stat.Size, stat.LastModified, nil
+}
+
+func (s *Scanner) isStable(path string) bool {
+	if s.Stability == nil {
+		return true
+	}
+	stability, err := GetStability(s.DB, path)
+	return err == nil && stability.Ready
+}
+
+// acquisitionStat returns the size and modification time recorded for path.
+// Directory acquisitions use the aggregate size and newest mtime of their
+// content.
+func (s *Scanner) acquisitionStat(ctx context.Context, location FolderWatchingLocation, path string, info os.FileInfo) (int64, int64, error) {
+	if info.IsDir() {
+		return s.folderStat(ctx, location, path, info)
+	}
+	return info.Size(), info.ModTime().Unix(), nil
+}
+
+// addCompanions adds the size of the companion files of an acquisition and
+// takes the newest modification time among them.
+func addCompanions(size int64, lastModified int64, companions map[string]os.FileInfo) (int64, int64) {
+	for _, info := range companions {
+		size += info.Size()
+		if modified := info.ModTime().Unix(); modified > lastModified {
+			lastModified = modified
+		}
+	}
+	return size, lastModified
+}
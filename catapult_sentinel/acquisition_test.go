@@ -0,0 +1,154 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsDirectoryAcquisition(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"sample_01.d", true},
+		{"SAMPLE_01.D", true},
+		{"sample_01.raw", true},
+		{"sample_01.wiff", false},
+		{"d", false},
+		{"sample.data", false},
+	}
+	for _, tt := range tests {
+		if got := IsDirectoryAcquisition(tt.name); got != tt.want {
+			t.Errorf("IsDirectoryAcquisition(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanner_DirectoryAcquisition(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	acquisition := filepath.Join(root, "exp1", "sample_01.d")
+	writeTestFile(t, filepath.Join(acquisition, "analysis.tdf"), 10)
+	writeTestFile(t, filepath.Join(acquisition, "analysis.tdf_bin"), 30)
+	writeTestFile(t, filepath.Join(acquisition, "chromatography-data.sqlite"), 5)
+
+	scanner := NewScanner(db)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if len(task.NewFile) != 1 || task.NewFile[0].FilePath != acquisition || task.NewFile[0].Size != 45 {
+		t.Fatalf("Scan() = %+v, want only %s with size 45", task.NewFile, acquisition)
+	}
+	local, err := GetFile(db, acquisition)
+	if err != nil || !local.IsFolder {
+		t.Fatalf("GetFile() = %+v, %v, want folder row", local, err)
+	}
+
+	newest := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(acquisition, "analysis.tdf_bin"), newest, newest); err != nil {
+		t.Fatalf("Chtimes() error: %v", err)
+	}
+	writeTestFile(t, filepath.Join(acquisition, "analysis.tdf"), 20)
+	task, err = scanner.Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if len(task.ChangedFile) != 1 || task.ChangedFile[0].Size != 55 {
+		t.Fatalf("Scan() changed = %+v, want size 55", task.ChangedFile)
+	}
+	stat, err := GetFolderStat(db, acquisition)
+	if err != nil || stat.LastModified != newest.Unix() {
+		t.Errorf("GetFolderStat() = %+v, %v, want last modified %d", stat, err, newest.Unix())
+	}
+}
+
+func TestScanner_FolderStatCache(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	acquisition := filepath.Join(root, "sample_01.d")
+	writeTestFile(t, filepath.Join(acquisition, "analysis.tdf"), 10)
+
+	scanner := NewScanner(db)
+	info, err := os.Stat(acquisition)
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if size, _, _ := scanner.acquisitionStat(context.Background(), FolderWatchingLocation{FolderPath: root, Extensions: "*"}, acquisition, info); size != 10 {
+		t.Fatalf("acquisitionStat() size = %d, want 10", size)
+	}
+
+	// growing content does not touch the folder mtime, so an acquisition that
+	// is not stable yet is walked again
+	writeTestFile(t, filepath.Join(acquisition, "analysis.tdf"), 25)
+	if size, _, _ := scanner.acquisitionStat(context.Background(), FolderWatchingLocation{FolderPath: root, Extensions: "*"}, acquisition, info); size != 25 {
+		t.Fatalf("acquisitionStat() unstable size = %d, want 25", size)
+	}
+
+	_, err = db.Exec("INSERT INTO stability (path, size, last_modified, unchanged_since, ready) VALUES (?, 25, 0, 0, 1)", acquisition)
+	if err != nil {
+		t.Fatalf("insert stability error: %v", err)
+	}
+	writeTestFile(t, filepath.Join(acquisition, "analysis.tdf"), 40)
+	if size, _, _ := scanner.acquisitionStat(context.Background(), FolderWatchingLocation{FolderPath: root, Extensions: "*"}, acquisition, info); size != 25 {
+		t.Errorf("acquisitionStat() stable size = %d, want cached 25", size)
+	}
+
+	// a walk that fails partway keeps the cached value
+	db.Exec("DELETE FROM stability WHERE path = ?", acquisition)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if size, _, err := scanner.acquisitionStat(ctx, FolderWatchingLocation{FolderPath: root, Extensions: "*"}, acquisition, info); err != nil || size != 25 {
+		t.Errorf("acquisitionStat() after a failed walk = %d, %v, want cached 25", size, err)
+	}
+	other := filepath.Join(root, "sample_02.d")
+	os.Mkdir(other, 0o755)
+	info, _ = os.Stat(other)
+	if _, _, err := scanner.acquisitionStat(ctx, FolderWatchingLocation{FolderPath: root, Extensions: "*"}, other, info); err == nil {
+		t.Errorf("acquisitionStat() after a failed walk without cache = nil error")
+	}
+}
+
+func TestScanner_DropsAcquisitionContent(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	acquisition := filepath.Join(root, "sample_01.d")
+	inner := filepath.Join(acquisition, "analysis.tdf")
+	writeTestFile(t, inner, 10)
+	if err := InsertFile(db, LocalFile{Path: inner, Size: 10}); err != nil {
+		t.Fatalf("InsertFile() error: %v", err)
+	}
+
+	task, err := NewScanner(db).Scan(context.Background(), FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1})
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if len(task.DeletedFile) != 0 {
+		t.Errorf("Scan() deleted = %+v, want none", task.DeletedFile)
+	}
+	if exists, _ := CheckFileExists(db, inner); exists {
+		t.Errorf("inner file %s still recorded", inner)
+	}
+}
+
+func TestWalkFolderStatCancelled(t *testing.T) {
+	acquisition := filepath.Join(t.TempDir(), "sample_01.d")
+	writeTestFile(t, filepath.Join(acquisition, "analysis.tdf_bin"), 10)
+	info, err := os.Stat(acquisition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := walkFolderStat(ctx, defaultWalker, acquisition, info); !errors.Is(err, context.Canceled) {
+		t.Errorf("walkFolderStat() error = %v, want context.Canceled", err)
+	}
+}
@@ -2,17 +2,26 @@ package catapult_sentinel
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"log"
+	"maps"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
+	"time"
 )
 
 type CatapultBackend struct {
-	Url    string
-	Client *http.Client
-	Token  string
+	Url     string
+	Client  *http.Client
+	Token   string
+	Retry   *RetryPolicy
+	Breaker *CircuitBreaker
+	// Paths translates the paths sent to and received from the backend. Nil
+	// sends local paths unchanged.
+	Paths *PathMapper
 }
 
 type File struct {
@@ -23,14 +32,43 @@ type File struct {
 	Processing             bool   `json:"processing"`
 	ReadyForProcessing     bool   `json:"ready_for_processing"`
 	Id                     int    `json:"id"`
+	Missing                bool   `json:"missing"`
+	Checksum               string `json:"checksum"`
+	// Metadata holds the fields parsed from the file name by the filename
+	// template of its location.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// equal reports whether f and g hold the same values.
+func (f File) equal(g File) bool {
+	if !maps.Equal(f.Metadata, g.Metadata) {
+		return false
+	}
+	f.Metadata, g.Metadata = nil, nil
+	return reflect.DeepEqual(f, g)
 }
 
 type FolderWatchingLocation struct {
-	FolderPath    string `json:"folder_path"`
-	Extensions    string `json:"extensions"`
-	IgnoreTerm    string `json:"ignore_term"`
-	NetworkFolder bool   `json:"network_folder"`
-	Id            int    `json:"id"`
+	FolderPath string `json:"folder_path"`
+	// Extensions lists the suffixes and globs of the files tracked, see
+	// ParseExtensionRules. When empty only run configs are tracked, "*" tracks
+	// every file.
+	Extensions string `json:"extensions"`
+	IgnoreTerm string `json:"ignore_term"`
+	// IgnorePatterns holds ignore rules, one per line, in the syntax of
+	// .sentinelignore files.
+	IgnorePatterns string `json:"ignore_patterns"`
+	NetworkFolder  bool   `json:"network_folder"`
+	Id             int    `json:"id"`
+	// ExperimentStrategy selects how files are grouped into experiments, one
+	// of the ExperimentStrategy constants. ExperimentDepth and
+	// ExperimentPattern configure the depth, regex and mapping strategies.
+	ExperimentStrategy string `json:"experiment_strategy"`
+	ExperimentDepth    int    `json:"experiment_depth"`
+	ExperimentPattern  string `json:"experiment_pattern"`
+	// FilenameTemplate, when set, is parsed by ParseFilenameTemplate to fill
+	// the metadata of the files of the location.
+	FilenameTemplate string `json:"filename_template"`
 }
 
 type Experiment struct {
@@ -50,6 +88,13 @@ type CatapultRunConfig struct {
 	FastaRequired           bool                   `json:"fasta_required"`
 	SpectralLibraryReady    bool                   `json:"spectral_library_ready"`
 	SpectralLibraryRequired bool                   `json:"spectral_library_required"`
+	// Spec is the validated form of Content, nil for configurations read
+	// before cat.yml files had a schema.
+	Spec *RunConfigSpec `json:"spec,omitempty"`
+	// ContentHash is the hash of the cat.yml file as "algorithm:hex", and
+	// Revision its number in the local revision history.
+	ContentHash string `json:"content_hash,omitempty"`
+	Revision    int    `json:"revision,omitempty"`
 }
 
 type CatapultRunConfigQuery struct {
@@ -59,8 +104,19 @@ type CatapultRunConfigQuery struct {
 	Count    int                 `json:"count"`
 }
 
+// DefaultRequestTimeout bounds a single HTTP attempt made by a backend created
+// with NewCatapultBackend. Callers set tighter per call deadlines through the
+// context passed to the ...Context methods.
+const DefaultRequestTimeout = 60 * time.Second
+
 func NewCatapultBackend(url string, token string) *CatapultBackend {
-	return &CatapultBackend{Url: url, Client: &http.Client{}, Token: token}
+	return &CatapultBackend{
+		Url:     url,
+		Client:  &http.Client{Timeout: DefaultRequestTimeout},
+		Token:   token,
+		Retry:   DefaultRetryPolicy(),
+		Breaker: NewCircuitBreaker(5, time.Minute),
+	}
 }
 
 func (c *CatapultBackend) GetUrl() string {
@@ -68,6 +124,10 @@ func (c *CatapultBackend) GetUrl() string {
 }
 
 func (c *CatapultBackend) GetFile(filePath string) (File, error) {
+	return c.GetFileContext(context.Background(), filePath)
+}
+
+func (c *CatapultBackend) GetFileContext(ctx context.Context, filePath string) (File, error) {
 	baseUrl, err := url.Parse(c.Url + "api/files/get_exact_path/")
 	if err != nil {
 		return File{}, err
@@ -80,7 +140,7 @@ func (c *CatapultBackend) GetFile(filePath string) (File, error) {
 		FilePath string `json:"file_path"`
 		Create   bool   `json:"create"`
 	}{
-		FilePath: filePath,
+		FilePath: c.Paths.ToRemote(filePath),
 		Create:   true,
 	}
 
@@ -89,21 +149,22 @@ func (c *CatapultBackend) GetFile(filePath string) (File, error) {
 		return File{}, err
 	}
 
-	req, err := http.NewRequest("POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return File{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return File{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return File{}, err
+		return File{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -112,10 +173,15 @@ func (c *CatapultBackend) GetFile(filePath string) (File, error) {
 	if err != nil {
 		return File{}, err
 	}
+	c.Paths.local(&file)
 	return file, nil
 }
 
 func (c *CatapultBackend) GetFiles(filePaths []string) ([]File, error) {
+	return c.GetFilesContext(context.Background(), filePaths)
+}
+
+func (c *CatapultBackend) GetFilesContext(ctx context.Context, filePaths []string) ([]File, error) {
 	baseUrl, err := url.Parse(c.Url + "api/files/get_exact_paths/")
 	if len(filePaths) == 0 {
 		return []File{}, nil
@@ -123,13 +189,15 @@ func (c *CatapultBackend) GetFiles(filePaths []string) ([]File, error) {
 	if err != nil {
 		return []File{}, err
 	}
+	remotePaths := append([]string(nil), filePaths...)
+	c.Paths.remote(remotePaths)
 	params := url.Values{}
 	baseUrl.RawQuery = params.Encode()
 	body := struct {
 		FilePaths []string `json:"file_paths"`
 		Create    bool     `json:"create"`
 	}{
-		FilePaths: filePaths,
+		FilePaths: remotePaths,
 		Create:    true,
 	}
 	bodyJson, err := json.Marshal(body)
@@ -137,22 +205,23 @@ func (c *CatapultBackend) GetFiles(filePaths []string) ([]File, error) {
 		return []File{}, err
 	}
 
-	req, err := http.NewRequest("POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return []File{}, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return []File{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Panicf("Error: %s", resp.Status)
+		return []File{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -161,11 +230,16 @@ func (c *CatapultBackend) GetFiles(filePaths []string) ([]File, error) {
 	if err != nil {
 		return []File{}, err
 	}
+	c.Paths.local(files)
 	return files, nil
 
 }
 
 func (c *CatapultBackend) GetFileById(fileId int) (File, error) {
+	return c.GetFileByIdContext(context.Background(), fileId)
+}
+
+func (c *CatapultBackend) GetFileByIdContext(ctx context.Context, fileId int) (File, error) {
 	baseUrl, err := url.Parse(c.Url + "api/files/" + strconv.Itoa(fileId) + "/")
 	if err != nil {
 		return File{}, err
@@ -174,20 +248,20 @@ func (c *CatapultBackend) GetFileById(fileId int) (File, error) {
 	params := url.Values{}
 	baseUrl.RawQuery = params.Encode()
 
-	req, err := http.NewRequest("GET", baseUrl.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseUrl.String(), nil)
 	if err != nil {
 		return File{}, err
 	}
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return File{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return File{}, err
+		return File{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -196,35 +270,42 @@ func (c *CatapultBackend) GetFileById(fileId int) (File, error) {
 	if err != nil {
 		return File{}, err
 	}
+	c.Paths.local(&file)
 	return file, nil
 }
 
 func (c *CatapultBackend) CreateFile(file File) (File, error) {
+	return c.CreateFileContext(context.Background(), file)
+}
+
+func (c *CatapultBackend) CreateFileContext(ctx context.Context, file File) (File, error) {
 	baseUrl, err := url.Parse(c.Url + "api/files/")
 	if err != nil {
 		return File{}, err
 	}
 
+	c.Paths.remote(&file)
 	bodyJson, err := json.Marshal(file)
 	if err != nil {
 		return File{}, err
 	}
 
-	req, err := http.NewRequest("POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return File{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return File{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return File{}, err
+		return File{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -233,10 +314,15 @@ func (c *CatapultBackend) CreateFile(file File) (File, error) {
 	if err != nil {
 		return File{}, err
 	}
+	c.Paths.local(&newFile)
 	return newFile, nil
 }
 
 func (c *CatapultBackend) UpdateFile(file File) (File, error) {
+	return c.UpdateFileContext(context.Background(), file)
+}
+
+func (c *CatapultBackend) UpdateFileContext(ctx context.Context, file File) (File, error) {
 	baseUrl, err := url.Parse(c.Url + "api/files/" + strconv.Itoa(file.Id) + "/")
 	if err != nil {
 		return File{}, err
@@ -245,25 +331,26 @@ func (c *CatapultBackend) UpdateFile(file File) (File, error) {
 	params := url.Values{}
 	baseUrl.RawQuery = params.Encode()
 
+	c.Paths.remote(&file)
 	bodyJson, err := json.Marshal(file)
 	if err != nil {
 		return File{}, err
 	}
 
-	req, err := http.NewRequest("PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return File{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return File{}, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return File{}, err
+		return File{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -274,10 +361,15 @@ func (c *CatapultBackend) UpdateFile(file File) (File, error) {
 		return File{}, err
 	}
 
+	c.Paths.local(&updatedFile)
 	return updatedFile, nil
 }
 
 func (c *CatapultBackend) UpdateFiles(files []File) ([]File, error) {
+	return c.UpdateFilesContext(context.Background(), files)
+}
+
+func (c *CatapultBackend) UpdateFilesContext(ctx context.Context, files []File) ([]File, error) {
 	baseUrl, err := url.Parse(c.Url + "api/files/update_multiple/")
 	if err != nil {
 		return []File{}, err
@@ -287,6 +379,8 @@ func (c *CatapultBackend) UpdateFiles(files []File) ([]File, error) {
 		return []File{}, nil
 	}
 
+	files = append([]File(nil), files...)
+	c.Paths.remote(files)
 	body := struct {
 		Files []File `json:"files"`
 	}{
@@ -298,21 +392,21 @@ func (c *CatapultBackend) UpdateFiles(files []File) ([]File, error) {
 		return []File{}, err
 	}
 
-	req, err := http.NewRequest("PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return []File{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return []File{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return []File{}, err
+		return []File{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -321,29 +415,36 @@ func (c *CatapultBackend) UpdateFiles(files []File) ([]File, error) {
 	if err != nil {
 		return []File{}, err
 	}
+	c.Paths.local(updatedFiles)
 	return updatedFiles, nil
 }
 
 func (c *CatapultBackend) CreateExperiment(experiment Experiment) (Experiment, error) {
+	return c.CreateExperimentContext(context.Background(), experiment)
+}
+
+func (c *CatapultBackend) CreateExperimentContext(ctx context.Context, experiment Experiment) (Experiment, error) {
 	baseUrl, err := url.Parse(c.Url + "api/experiments/")
 	if err != nil {
 		return Experiment{}, err
 	}
 
+	c.Paths.remote(&experiment)
 	bodyJson, err := json.Marshal(experiment)
 	if err != nil {
 		return Experiment{}, err
 	}
 
-	req, err := http.NewRequest("POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return Experiment{}, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return Experiment{}, err
 	}
@@ -351,7 +452,7 @@ func (c *CatapultBackend) CreateExperiment(experiment Experiment) (Experiment, e
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return Experiment{}, err
+		return Experiment{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -360,10 +461,15 @@ func (c *CatapultBackend) CreateExperiment(experiment Experiment) (Experiment, e
 	if err != nil {
 		return Experiment{}, err
 	}
+	c.Paths.local(&newExperiment)
 	return newExperiment, nil
 }
 
 func (c *CatapultBackend) GetExperimentByName(experimentName string) (Experiment, error) {
+	return c.GetExperimentByNameContext(context.Background(), experimentName)
+}
+
+func (c *CatapultBackend) GetExperimentByNameContext(ctx context.Context, experimentName string) (Experiment, error) {
 	baseUrl, err := url.Parse(c.Url + "api/experiments/get_exact_name/")
 	if err != nil {
 		return Experiment{}, err
@@ -373,7 +479,7 @@ func (c *CatapultBackend) GetExperimentByName(experimentName string) (Experiment
 		ExperimentName string `json:"experiment_name"`
 		Create         bool   `json:"create"`
 	}{
-		ExperimentName: experimentName,
+		ExperimentName: c.Paths.ExperimentToRemote(experimentName),
 		Create:         true,
 	}
 
@@ -382,15 +488,16 @@ func (c *CatapultBackend) GetExperimentByName(experimentName string) (Experiment
 		return Experiment{}, err
 	}
 
-	req, err := http.NewRequest("POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return Experiment{}, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return Experiment{}, err
 	}
@@ -398,7 +505,7 @@ func (c *CatapultBackend) GetExperimentByName(experimentName string) (Experiment
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return Experiment{}, err
+		return Experiment{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -407,20 +514,29 @@ func (c *CatapultBackend) GetExperimentByName(experimentName string) (Experiment
 	if err != nil {
 		return Experiment{}, err
 	}
+	c.Paths.local(&experiment)
 	return experiment, nil
 }
 
 func (c *CatapultBackend) GetExperimentsByNames(experimentNames []string) ([]Experiment, error) {
+	return c.GetExperimentsByNamesContext(context.Background(), experimentNames)
+}
+
+func (c *CatapultBackend) GetExperimentsByNamesContext(ctx context.Context, experimentNames []string) ([]Experiment, error) {
 	baseUrl, err := url.Parse(c.Url + "api/experiments/get_exact_names/")
 	if err != nil {
 		return []Experiment{}, err
 	}
 
+	remoteNames := make([]string, len(experimentNames))
+	for i, name := range experimentNames {
+		remoteNames[i] = c.Paths.ExperimentToRemote(name)
+	}
 	body := struct {
 		ExperimentNames []string `json:"experiment_names"`
 		Create          bool     `json:"create"`
 	}{
-		ExperimentNames: experimentNames,
+		ExperimentNames: remoteNames,
 		Create:          true,
 	}
 
@@ -429,15 +545,16 @@ func (c *CatapultBackend) GetExperimentsByNames(experimentNames []string) ([]Exp
 		return []Experiment{}, err
 	}
 
-	req, err := http.NewRequest("POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return []Experiment{}, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return []Experiment{}, err
 	}
@@ -445,7 +562,7 @@ func (c *CatapultBackend) GetExperimentsByNames(experimentNames []string) ([]Exp
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return []Experiment{}, err
+		return []Experiment{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -454,11 +571,16 @@ func (c *CatapultBackend) GetExperimentsByNames(experimentNames []string) ([]Exp
 	if err != nil {
 		return []Experiment{}, err
 	}
+	c.Paths.local(experiments)
 	return experiments, nil
 }
 
 func (c *CatapultBackend) GetExperimentById(experimentId int) (Experiment, error) {
-	baseUrl, err := url.Parse(c.Url + "api/experiments/" + string(rune(experimentId)) + "/")
+	return c.GetExperimentByIdContext(context.Background(), experimentId)
+}
+
+func (c *CatapultBackend) GetExperimentByIdContext(ctx context.Context, experimentId int) (Experiment, error) {
+	baseUrl, err := url.Parse(c.Url + "api/experiments/" + strconv.Itoa(experimentId) + "/")
 	if err != nil {
 		return Experiment{}, err
 	}
@@ -466,14 +588,14 @@ func (c *CatapultBackend) GetExperimentById(experimentId int) (Experiment, error
 	params := url.Values{}
 	baseUrl.RawQuery = params.Encode()
 
-	req, err := http.NewRequest("GET", baseUrl.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseUrl.String(), nil)
 	if err != nil {
 		return Experiment{}, err
 	}
 
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return Experiment{}, err
 	}
@@ -481,7 +603,7 @@ func (c *CatapultBackend) GetExperimentById(experimentId int) (Experiment, error
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return Experiment{}, err
+		return Experiment{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -490,10 +612,15 @@ func (c *CatapultBackend) GetExperimentById(experimentId int) (Experiment, error
 	if err != nil {
 		return Experiment{}, err
 	}
+	c.Paths.local(&experiment)
 	return experiment, nil
 }
 
 func (c *CatapultBackend) UpdateExperiment(experiment Experiment) (Experiment, error) {
+	return c.UpdateExperimentContext(context.Background(), experiment)
+}
+
+func (c *CatapultBackend) UpdateExperimentContext(ctx context.Context, experiment Experiment) (Experiment, error) {
 	baseUrl, err := url.Parse(c.Url + "api/experiments/" + strconv.Itoa(experiment.Id) + "/")
 	if err != nil {
 		return Experiment{}, err
@@ -502,12 +629,13 @@ func (c *CatapultBackend) UpdateExperiment(experiment Experiment) (Experiment, e
 	params := url.Values{}
 	baseUrl.RawQuery = params.Encode()
 
+	c.Paths.remote(&experiment)
 	bodyJson, err := json.Marshal(experiment)
 	if err != nil {
 		return Experiment{}, err
 	}
 
-	req, err := http.NewRequest("PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return Experiment{}, err
 	}
@@ -515,7 +643,7 @@ func (c *CatapultBackend) UpdateExperiment(experiment Experiment) (Experiment, e
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return Experiment{}, err
 	}
@@ -523,7 +651,7 @@ func (c *CatapultBackend) UpdateExperiment(experiment Experiment) (Experiment, e
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return Experiment{}, err
+		return Experiment{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -532,10 +660,15 @@ func (c *CatapultBackend) UpdateExperiment(experiment Experiment) (Experiment, e
 	if err != nil {
 		return Experiment{}, err
 	}
+	c.Paths.local(&updatedExperiment)
 	return updatedExperiment, nil
 }
 
 func (c *CatapultBackend) UpdateExperiments(experiments []Experiment) ([]Experiment, error) {
+	return c.UpdateExperimentsContext(context.Background(), experiments)
+}
+
+func (c *CatapultBackend) UpdateExperimentsContext(ctx context.Context, experiments []Experiment) ([]Experiment, error) {
 	baseUrl, err := url.Parse(c.Url + "api/experiments/update_multiple/")
 	if err != nil {
 		return []Experiment{}, err
@@ -545,6 +678,8 @@ func (c *CatapultBackend) UpdateExperiments(experiments []Experiment) ([]Experim
 		return []Experiment{}, nil
 	}
 
+	experiments = append([]Experiment(nil), experiments...)
+	c.Paths.remote(experiments)
 	body := struct {
 		Experiments []Experiment `json:"experiments"`
 	}{
@@ -556,7 +691,7 @@ func (c *CatapultBackend) UpdateExperiments(experiments []Experiment) ([]Experim
 		return []Experiment{}, err
 	}
 
-	req, err := http.NewRequest("PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return []Experiment{}, err
 	}
@@ -564,7 +699,7 @@ func (c *CatapultBackend) UpdateExperiments(experiments []Experiment) ([]Experim
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return []Experiment{}, err
 	}
@@ -572,7 +707,7 @@ func (c *CatapultBackend) UpdateExperiments(experiments []Experiment) ([]Experim
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return []Experiment{}, err
+		return []Experiment{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -582,35 +717,42 @@ func (c *CatapultBackend) UpdateExperiments(experiments []Experiment) ([]Experim
 		return []Experiment{}, err
 	}
 
+	c.Paths.local(updatedExperiments)
 	return updatedExperiments, nil
 }
 
 func (c *CatapultBackend) CreateCatapultRunConfig(config CatapultRunConfig) (CatapultRunConfig, error) {
+	return c.CreateCatapultRunConfigContext(context.Background(), config)
+}
+
+func (c *CatapultBackend) CreateCatapultRunConfigContext(ctx context.Context, config CatapultRunConfig) (CatapultRunConfig, error) {
 	baseUrl, err := url.Parse(c.Url + "api/catapultrunconfig/")
 	if err != nil {
 		return CatapultRunConfig{}, err
 	}
 
+	c.Paths.remote(&config)
 	bodyJson, err := json.Marshal(config)
 	if err != nil {
 		return CatapultRunConfig{}, err
 	}
 
-	req, err := http.NewRequest("POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return CatapultRunConfig{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return CatapultRunConfig{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return CatapultRunConfig{}, err
+		return CatapultRunConfig{}, newAPIError(req, resp)
 	}
 	decoder := json.NewDecoder(resp.Body)
 	var newConfig CatapultRunConfig
@@ -618,10 +760,57 @@ func (c *CatapultBackend) CreateCatapultRunConfig(config CatapultRunConfig) (Cat
 	if err != nil {
 		return CatapultRunConfig{}, err
 	}
+	c.Paths.local(&newConfig)
 	return newConfig, nil
 }
 
+func (c *CatapultBackend) UpdateCatapultRunConfig(config CatapultRunConfig) (CatapultRunConfig, error) {
+	return c.UpdateCatapultRunConfigContext(context.Background(), config)
+}
+
+func (c *CatapultBackend) UpdateCatapultRunConfigContext(ctx context.Context, config CatapultRunConfig) (CatapultRunConfig, error) {
+	baseUrl, err := url.Parse(c.Url + "api/catapultrunconfig/" + strconv.Itoa(config.Id) + "/")
+	if err != nil {
+		return CatapultRunConfig{}, err
+	}
+
+	c.Paths.remote(&config)
+	bodyJson, err := json.Marshal(config)
+	if err != nil {
+		return CatapultRunConfig{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	if err != nil {
+		return CatapultRunConfig{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+c.Token)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return CatapultRunConfig{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return CatapultRunConfig{}, newAPIError(req, resp)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	var updatedConfig CatapultRunConfig
+	err = decoder.Decode(&updatedConfig)
+	if err != nil {
+		return CatapultRunConfig{}, err
+	}
+	c.Paths.local(&updatedConfig)
+	return updatedConfig, nil
+}
+
 func (c *CatapultBackend) FilterCatapultRunConfig(prefix string, experimentId int) (CatapultRunConfigQuery, error) {
+	return c.FilterCatapultRunConfigContext(context.Background(), prefix, experimentId)
+}
+
+func (c *CatapultBackend) FilterCatapultRunConfigContext(ctx context.Context, prefix string, experimentId int) (CatapultRunConfigQuery, error) {
 	baseUrl, err := url.Parse(c.Url + "api/catapultrunconfig/")
 	if err != nil {
 		return CatapultRunConfigQuery{}, err
@@ -629,7 +818,7 @@ func (c *CatapultBackend) FilterCatapultRunConfig(prefix string, experimentId in
 
 	params := url.Values{}
 	if prefix != "" {
-		params.Add("prefix", prefix)
+		params.Add("prefix", c.Paths.ToRemote(prefix))
 	}
 	if experimentId != 0 {
 		params.Add("experiment", strconv.Itoa(experimentId))
@@ -638,20 +827,20 @@ func (c *CatapultBackend) FilterCatapultRunConfig(prefix string, experimentId in
 	baseUrl.RawQuery = params.Encode()
 	log.Printf("URL: %s", baseUrl.String())
 
-	req, err := http.NewRequest("GET", baseUrl.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseUrl.String(), nil)
 	if err != nil {
 		return CatapultRunConfigQuery{}, err
 	}
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return CatapultRunConfigQuery{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return CatapultRunConfigQuery{}, err
+		return CatapultRunConfigQuery{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -660,10 +849,15 @@ func (c *CatapultBackend) FilterCatapultRunConfig(prefix string, experimentId in
 	if err != nil {
 		return CatapultRunConfigQuery{}, err
 	}
+	c.Paths.local(configs.Results)
 	return configs, nil
 }
 
 func (c *CatapultBackend) GetFolderWatchingLocation(folderPath string) (FolderWatchingLocation, error) {
+	return c.GetFolderWatchingLocationContext(context.Background(), folderPath)
+}
+
+func (c *CatapultBackend) GetFolderWatchingLocationContext(ctx context.Context, folderPath string) (FolderWatchingLocation, error) {
 	baseUrl, err := url.Parse(c.Url + "api/folderlocations/get_exact_path/")
 	if err != nil {
 		return FolderWatchingLocation{}, err
@@ -673,7 +867,7 @@ func (c *CatapultBackend) GetFolderWatchingLocation(folderPath string) (FolderWa
 		FolderPath string `json:"folder_path"`
 		Create     bool   `json:"create"`
 	}{
-		FolderPath: folderPath,
+		FolderPath: c.Paths.ToRemote(folderPath),
 		Create:     true,
 	}
 
@@ -682,15 +876,16 @@ func (c *CatapultBackend) GetFolderWatchingLocation(folderPath string) (FolderWa
 		return FolderWatchingLocation{}, err
 	}
 
-	req, err := http.NewRequest("POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseUrl.String(), bytes.NewBuffer(bodyJson))
 	if err != nil {
 		return FolderWatchingLocation{}, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", newIdempotencyKey())
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return FolderWatchingLocation{}, err
 	}
@@ -698,7 +893,7 @@ func (c *CatapultBackend) GetFolderWatchingLocation(folderPath string) (FolderWa
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return FolderWatchingLocation{}, err
+		return FolderWatchingLocation{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -707,23 +902,28 @@ func (c *CatapultBackend) GetFolderWatchingLocation(folderPath string) (FolderWa
 	if err != nil {
 		return FolderWatchingLocation{}, err
 	}
+	c.Paths.local(&folderWatchingLocation)
 	return folderWatchingLocation, nil
 }
 
 func (c *CatapultBackend) GetAllFolderWatchingLocations() ([]FolderWatchingLocation, error) {
+	return c.GetAllFolderWatchingLocationsContext(context.Background())
+}
+
+func (c *CatapultBackend) GetAllFolderWatchingLocationsContext(ctx context.Context) ([]FolderWatchingLocation, error) {
 	baseUrl, err := url.Parse(c.Url + "api/folderlocations/get_all_paths/")
 	if err != nil {
 		return []FolderWatchingLocation{}, err
 	}
 
-	req, err := http.NewRequest("GET", baseUrl.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseUrl.String(), nil)
 	if err != nil {
 		return []FolderWatchingLocation{}, err
 	}
 
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return []FolderWatchingLocation{}, err
 	}
@@ -731,7 +931,7 @@ func (c *CatapultBackend) GetAllFolderWatchingLocations() ([]FolderWatchingLocat
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return []FolderWatchingLocation{}, err
+		return []FolderWatchingLocation{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -740,10 +940,15 @@ func (c *CatapultBackend) GetAllFolderWatchingLocations() ([]FolderWatchingLocat
 	if err != nil {
 		return []FolderWatchingLocation{}, err
 	}
+	c.Paths.local(folderWatchingLocations)
 	return folderWatchingLocations, nil
 }
 
 func (c *CatapultBackend) GetFolderWatchingLocationById(folderId int) (FolderWatchingLocation, error) {
+	return c.GetFolderWatchingLocationByIdContext(context.Background(), folderId)
+}
+
+func (c *CatapultBackend) GetFolderWatchingLocationByIdContext(ctx context.Context, folderId int) (FolderWatchingLocation, error) {
 	baseUrl, err := url.Parse(c.Url + "api/folderlocations/" + strconv.Itoa(folderId) + "/")
 	if err != nil {
 		return FolderWatchingLocation{}, err
@@ -752,14 +957,14 @@ func (c *CatapultBackend) GetFolderWatchingLocationById(folderId int) (FolderWat
 	params := url.Values{}
 	baseUrl.RawQuery = params.Encode()
 
-	req, err := http.NewRequest("GET", baseUrl.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseUrl.String(), nil)
 	if err != nil {
 		return FolderWatchingLocation{}, err
 	}
 
 	req.Header.Set("Authorization", "Token "+c.Token)
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return FolderWatchingLocation{}, err
 	}
@@ -767,7 +972,7 @@ func (c *CatapultBackend) GetFolderWatchingLocationById(folderId int) (FolderWat
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return FolderWatchingLocation{}, err
+		return FolderWatchingLocation{}, newAPIError(req, resp)
 	}
 
 	decoder := json.NewDecoder(resp.Body)
@@ -776,5 +981,6 @@ func (c *CatapultBackend) GetFolderWatchingLocationById(folderId int) (FolderWat
 	if err != nil {
 		return FolderWatchingLocation{}, err
 	}
+	c.Paths.local(&folderWatchingLocation)
 	return folderWatchingLocation, nil
 }
@@ -3,10 +3,32 @@ package catapult_sentinel
 import (
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
 )
 
+// testBackendUrl points the backend tests at an in-memory Catapult server
+// seeded from testdata, which mirrors the database dump CI used to restore
+// into a live instance.
+var testBackendUrl string
+
+func TestMain(m *testing.M) {
+	server := catapulttest.NewServer()
+	for _, collection := range []string{catapulttest.Files, catapulttest.Experiments, catapulttest.RunConfigs, catapulttest.FolderLocations} {
+		if err := server.SeedFile(collection, filepath.Join("testdata", collection+".json")); err != nil {
+			server.Close()
+			panic(err)
+		}
+	}
+	testBackendUrl = server.BaseUrl()
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
 func TestCatapultBackend_GetFile(t *testing.T) {
 	type fields struct {
 		Url    string
@@ -26,7 +48,7 @@ func TestCatapultBackend_GetFile(t *testing.T) {
 		{
 			name: "Test GetFile",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -82,7 +104,7 @@ func TestCatapultBackend_GetFiles(t *testing.T) {
 		{
 			name: "Test GetFiles",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -137,7 +159,7 @@ func TestCatapultBackend_GetExperimentsByNames(t *testing.T) {
 		{
 			name: "Test GetExperimentsByNames",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -189,7 +211,7 @@ func TestCatapultBackend_UpdateFile(t *testing.T) {
 		{
 			name: "Test UpdateFile",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -242,7 +264,7 @@ func TestCatapultBackend_UpdateExperiments(t *testing.T) {
 		{
 			name: "Test UpdateExperiments",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -297,7 +319,7 @@ func TestCatapultBackend_CreateCatapultRunConfig(t *testing.T) {
 		{
 			name: "Test CreateCatapultRunConfig",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -331,6 +353,60 @@ func TestCatapultBackend_CreateCatapultRunConfig(t *testing.T) {
 	}
 }
 
+func TestCatapultBackend_UpdateCatapultRunConfig(t *testing.T) {
+	type fields struct {
+		Url    string
+		Client *http.Client
+		Token  string
+	}
+	type args struct {
+		config CatapultRunConfig
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "Test UpdateCatapultRunConfig",
+			fields: fields{
+				Url:    testBackendUrl,
+				Client: &http.Client{},
+				Token:  os.Getenv("API_TOKEN"),
+			},
+			args: args{
+				config: CatapultRunConfig{
+					Id:             1,
+					Experiment:     2,
+					Content:        map[string]interface{}{"cat_ready": true},
+					ConfigFilePath: "1000ngHeLa_search\\diann_config.cat.yml",
+					ContentHash:    "sha256:00",
+					Revision:       2,
+				},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CatapultBackend{
+				Url:    tt.fields.Url,
+				Client: tt.fields.Client,
+				Token:  tt.fields.Token,
+			}
+			got, err := c.UpdateCatapultRunConfig(tt.args.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UpdateCatapultRunConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got.Id != tt.args.config.Id || got.Revision != tt.args.config.Revision {
+				t.Errorf("UpdateCatapultRunConfig() = %+v, want revision %d of %d", got, tt.args.config.Revision, tt.args.config.Id)
+			}
+		})
+	}
+}
+
 func TestCatapultBackend_FilterCatapultRunConfig(t *testing.T) {
 	type fields struct {
 		Url    string
@@ -350,7 +426,7 @@ func TestCatapultBackend_FilterCatapultRunConfig(t *testing.T) {
 		{
 			name: "Test FilterCatapultRunConfig",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -403,7 +479,7 @@ func TestCatapultBackend_GetFolderWatchingLocation(t *testing.T) {
 		{
 			name: "Test GetFolderWatchingLocation",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -446,7 +522,7 @@ func TestCatapultBackend_GetAllFolderWatchingLocations(t *testing.T) {
 		{
 			name: "Test GetAllFolderWatchingLocations",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -495,7 +571,7 @@ func TestCatapultBackend_GetFolderWatchingLocationById(t *testing.T) {
 		{
 			name: "Test GetFolderWatchingLocationById",
 			fields: fields{
-				Url:    "http://localhost:8000/",
+				Url:    testBackendUrl,
 				Client: &http.Client{},
 				Token:  os.Getenv("API_TOKEN"),
 			},
@@ -0,0 +1,540 @@
+// Package catapulttest provides an in-memory implementation of the Catapult
+// backend endpoints used by catapult_sentinel, for tests that should not need a
+// running Catapult server.
+//
+// Records are stored as plain JSON objects, so any field sent by the client is
+// kept and returned as is. Faults such as latency, 5xx responses or malformed
+// JSON can be injected per path.
+package catapulttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Files           = "files"
+	Experiments     = "experiments"
+	RunConfigs      = "catapultrunconfig"
+	FolderLocations = "folderlocations"
+)
+
+// Record is a stored backend object, keyed by its JSON field names.
+type Record map[string]interface{}
+
+// lookupKeys is the natural key of each collection used by the get_exact_*
+// endpoints.
+var lookupKeys = map[string]string{
+	Files:           "file_path",
+	Experiments:     "experiment_name",
+	FolderLocations: "folder_path",
+	RunConfigs:      "config_file_path",
+}
+
+func defaults(collection string) Record {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	switch collection {
+	case Files:
+		return Record{"folder_watching_location": nil, "size": 0, "experiment": nil, "processing": false, "ready_for_processing": false}
+	case Experiments:
+		return Record{"vendor": nil, "sample_count": nil, "created_at": now, "updated_at": now}
+	case FolderLocations:
+		return Record{"extensions": "", "ignore_term": "", "network_folder": false}
+	case RunConfigs:
+		return Record{"folder_watching_location": nil, "experiment": nil, "content": map[string]interface{}{}, "fasta_ready": false, "fasta_required": false, "spectral_library_ready": false, "spectral_library_required": false}
+	}
+	return Record{}
+}
+
+// Fault alters the response to requests whose path starts with Path.
+type Fault struct {
+	// Path is matched as a prefix of the request path, empty matches everything.
+	Path string
+	// Times is the number of requests the fault applies to, 0 means until
+	// ClearFaults is called.
+	Times int
+	// Latency delays the response.
+	Latency time.Duration
+	// Status, when set, replaces the response with this status code and Body.
+	Status     int
+	Body       string
+	RetryAfter string
+	// Malformed answers 200 with a truncated JSON document.
+	Malformed bool
+}
+
+// Request is a request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   string
+}
+
+type Server struct {
+	*httptest.Server
+	// Token, when set, is required in the Authorization header.
+	Token string
+	// PageSize is the number of records returned per page by list endpoints.
+	PageSize int
+
+	mu       sync.Mutex
+	records  map[string]map[int]Record
+	nextId   map[string]int
+	faults   []*Fault
+	requests []Request
+}
+
+// NewServer starts an empty fake backend. The client Url is Server.URL + "/".
+func NewServer() *Server {
+	s := &Server{
+		PageSize: 100,
+		records:  make(map[string]map[int]Record),
+		nextId:   make(map[string]int),
+	}
+	for collection := range lookupKeys {
+		s.records[collection] = make(map[int]Record)
+		s.nextId[collection] = 1
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseUrl returns the url to give to catapult_sentinel.NewCatapultBackend.
+func (s *Server) BaseUrl() string {
+	return s.Server.URL + "/"
+}
+
+// Add stores record in collection and returns its id. A missing id is assigned.
+func (s *Server) Add(collection string, record Record) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(collection, record)
+}
+
+func (s *Server) add(collection string, record Record) int {
+	stored := defaults(collection)
+	for k, v := range record {
+		stored[k] = v
+	}
+	id, ok := toInt(stored["id"])
+	if !ok || id == 0 {
+		id = s.nextId[collection]
+	}
+	if id >= s.nextId[collection] {
+		s.nextId[collection] = id + 1
+	}
+	stored["id"] = id
+	s.records[collection][id] = stored
+	return id
+}
+
+// Seed loads a JSON list of records, e.g. testdata/experiments.json, into
+// collection.
+func (s *Server) Seed(collection string, r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var records []Record
+	if err := decoder.Decode(&records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		s.Add(collection, record)
+	}
+	return nil
+}
+
+// SeedFile is Seed reading from a file.
+func (s *Server) SeedFile(collection string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Seed(collection, f)
+}
+
+// Get returns a copy of the record with the given id.
+func (s *Server) Get(collection string, id int) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[collection][id]
+	if !ok {
+		return nil, false
+	}
+	return copyRecord(record), true
+}
+
+// Records returns copies of every record of collection ordered by id.
+func (s *Server) Records(collection string) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted(collection)
+}
+
+func (s *Server) sorted(collection string) []Record {
+	ids := make([]int, 0, len(s.records[collection]))
+	for id := range s.records[collection] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	records := make([]Record, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, copyRecord(s.records[collection][id]))
+	}
+	return records
+}
+
+// AddFault registers a fault. Faults are matched in the order they were added.
+func (s *Server) AddFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fault := f
+	s.faults = append(s.faults, &fault)
+}
+
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) takeFault(path string) *Fault {
+	for i, f := range s.faults {
+		if !strings.HasPrefix(path, f.Path) {
+			continue
+		}
+		fault := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return &fault
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Body: string(body)})
+	fault := s.takeFault(r.URL.Path)
+	s.mu.Unlock()
+
+	if fault != nil {
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.RetryAfter != "" {
+			w.Header().Set("Retry-After", fault.RetryAfter)
+		}
+		if fault.Status != 0 {
+			w.WriteHeader(fault.Status)
+			w.Write([]byte(fault.Body))
+			return
+		}
+		if fault.Malformed {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 1, "file_path": "`))
+			return
+		}
+	}
+
+	if s.Token != "" && r.Header.Get("Authorization") != "Token "+s.Token {
+		writeJSON(w, http.StatusUnauthorized, Record{"detail": "Invalid token."})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, response := s.route(r, body)
+	writeJSON(w, status, response)
+}
+
+func (s *Server) route(r *http.Request, body []byte) (int, interface{}) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	collection := parts[0]
+	if _, ok := s.records[collection]; !ok || !strings.HasPrefix(r.URL.Path, "/api/") {
+		return http.StatusNotFound, Record{"detail": "Not found."}
+	}
+
+	var payload interface{}
+	if len(body) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&payload); err != nil {
+			return http.StatusBadRequest, Record{"detail": "JSON parse error - " + err.Error()}
+		}
+	}
+	object, _ := payload.(map[string]interface{})
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			return s.list(collection, r)
+		case http.MethodPost:
+			return s.create(collection, object)
+		}
+		return methodNotAllowed(r)
+	}
+
+	switch action := parts[1]; action {
+	case "get_exact_path", "get_exact_name":
+		if r.Method != http.MethodPost {
+			return methodNotAllowed(r)
+		}
+		return s.getExact(collection, object)
+	case "get_exact_paths", "get_exact_names":
+		if r.Method != http.MethodPost {
+			return methodNotAllowed(r)
+		}
+		return s.getExactMany(collection, object)
+	case "get_all_paths":
+		if r.Method != http.MethodGet {
+			return methodNotAllowed(r)
+		}
+		return http.StatusOK, s.sorted(collection)
+	case "update_multiple":
+		if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+			return methodNotAllowed(r)
+		}
+		return s.updateMultiple(collection, object)
+	default:
+		id, err := strconv.Atoi(action)
+		if err != nil {
+			return http.StatusNotFound, Record{"detail": "Not found."}
+		}
+		record, ok := s.records[collection][id]
+		if !ok {
+			return http.StatusNotFound, Record{"detail": "No " + collection + " matches the given query."}
+		}
+		switch r.Method {
+		case http.MethodGet:
+			return http.StatusOK, copyRecord(record)
+		case http.MethodPut, http.MethodPatch:
+			if object == nil {
+				return http.StatusBadRequest, Record{"non_field_errors": []string{"Invalid data. Expected a dictionary."}}
+			}
+			return http.StatusOK, s.update(collection, id, object)
+		case http.MethodDelete:
+			delete(s.records[collection], id)
+			return http.StatusNoContent, nil
+		}
+		return methodNotAllowed(r)
+	}
+}
+
+func (s *Server) create(collection string, object map[string]interface{}) (int, interface{}) {
+	key := lookupKeys[collection]
+	if value, _ := object[key].(string); value == "" {
+		return http.StatusBadRequest, Record{key: []string{"This field is required."}}
+	}
+	delete(object, "id")
+	id := s.add(collection, object)
+	return http.StatusCreated, copyRecord(s.records[collection][id])
+}
+
+func (s *Server) update(collection string, id int, object map[string]interface{}) Record {
+	record := s.records[collection][id]
+	for k, v := range object {
+		if k == "id" {
+			continue
+		}
+		record[k] = v
+	}
+	if _, ok := record["updated_at"]; ok {
+		record["updated_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	return copyRecord(record)
+}
+
+func (s *Server) findBy(collection string, value string) (Record, bool) {
+	key := lookupKeys[collection]
+	for _, record := range s.records[collection] {
+		if record[key] == value {
+			return record, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) getExact(collection string, object map[string]interface{}) (int, interface{}) {
+	key := lookupKeys[collection]
+	value, _ := object[key].(string)
+	if value == "" {
+		return http.StatusBadRequest, Record{key: []string{"This field is required."}}
+	}
+	if record, ok := s.findBy(collection, value); ok {
+		return http.StatusOK, copyRecord(record)
+	}
+	if create, _ := object["create"].(bool); !create {
+		return http.StatusNotFound, Record{"detail": "Not found."}
+	}
+	id := s.add(collection, Record{key: value})
+	return http.StatusOK, copyRecord(s.records[collection][id])
+}
+
+func (s *Server) getExactMany(collection string, object map[string]interface{}) (int, interface{}) {
+	key := lookupKeys[collection] + "s"
+	values, ok := object[key].([]interface{})
+	if !ok {
+		return http.StatusBadRequest, Record{key: []string{"This field is required."}}
+	}
+	create, _ := object["create"].(bool)
+	results := []Record{}
+	for _, v := range values {
+		value, _ := v.(string)
+		if record, ok := s.findBy(collection, value); ok {
+			results = append(results, copyRecord(record))
+		} else if create && value != "" {
+			id := s.add(collection, Record{lookupKeys[collection]: value})
+			results = append(results, copyRecord(s.records[collection][id]))
+		}
+	}
+	return http.StatusOK, results
+}
+
+func (s *Server) updateMultiple(collection string, object map[string]interface{}) (int, interface{}) {
+	items, ok := object[collection].([]interface{})
+	if !ok {
+		return http.StatusBadRequest, Record{collection: []string{"This field is required."}}
+	}
+	results := []Record{}
+	for _, item := range items {
+		itemObject, _ := item.(map[string]interface{})
+		id, _ := toInt(itemObject["id"])
+		if _, ok := s.records[collection][id]; !ok {
+			continue
+		}
+		results = append(results, s.update(collection, id, itemObject))
+	}
+	return http.StatusOK, results
+}
+
+// list serves a DRF style paginated list. Query parameters matching a record
+// field filter on equality; "prefix" filters run configs on the config file
+// path.
+func (s *Server) list(collection string, r *http.Request) (int, interface{}) {
+	query := r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	var matched []Record
+	for _, record := range s.sorted(collection) {
+		if matches(collection, record, query) {
+			matched = append(matched, record)
+		}
+	}
+
+	pageSize := s.PageSize
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	start := (page - 1) * pageSize
+	end := start + pageSize
+	if start > len(matched) {
+		start = len(matched)
+	}
+	if end > len(matched) {
+		end = len(matched)
+	}
+	response := Record{"count": len(matched), "next": nil, "previous": nil, "results": append([]Record{}, matched[start:end]...)}
+	pageUrl := func(p int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(p))
+		return s.Server.URL + r.URL.Path + "?" + q.Encode()
+	}
+	if end < len(matched) {
+		response["next"] = pageUrl(page + 1)
+	}
+	if page > 1 {
+		response["previous"] = pageUrl(page - 1)
+	}
+	return http.StatusOK, response
+}
+
+func matches(collection string, record Record, query map[string][]string) bool {
+	for key, values := range query {
+		if key == "page" || key == "page_size" || len(values) == 0 {
+			continue
+		}
+		if key == "prefix" && collection == RunConfigs {
+			path, _ := record["config_file_path"].(string)
+			if !strings.HasPrefix(path, values[0]) {
+				return false
+			}
+			continue
+		}
+		value, ok := record[key]
+		if !ok {
+			continue
+		}
+		if fmt.Sprint(value) != values[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func methodNotAllowed(r *http.Request) (int, interface{}) {
+	return http.StatusMethodNotAllowed, Record{"detail": fmt.Sprintf("Method \"%s\" not allowed.", r.Method)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func copyRecord(record Record) Record {
+	c := make(Record, len(record))
+	for k, v := range record {
+		c[k] = v
+	}
+	return c
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case float64:
+		return int(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err == nil
+	case string:
+		i, err := strconv.Atoi(n)
+		return i, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,107 @@
+package catapulttest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel"
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func newBackend(server *catapulttest.Server) *catapult_sentinel.CatapultBackend {
+	backend := catapult_sentinel.NewCatapultBackend(server.BaseUrl(), server.Token)
+	backend.Retry = &catapult_sentinel.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	return backend
+}
+
+func TestServer_GetOrCreate(t *testing.T) {
+	server := catapulttest.NewServer()
+	defer server.Close()
+	backend := newBackend(server)
+
+	file, err := backend.GetFile("D:\\watch_folder\\exp\\a.raw")
+	if err != nil {
+		t.Fatalf("GetFile() error: %v", err)
+	}
+	again, err := backend.GetFile("D:\\watch_folder\\exp\\a.raw")
+	if err != nil || again.Id != file.Id {
+		t.Fatalf("GetFile() second call = %+v, %v, want id %d", again, err, file.Id)
+	}
+
+	file.Size = 42
+	file.ReadyForProcessing = true
+	if _, err := backend.UpdateFile(file); err != nil {
+		t.Fatalf("UpdateFile() error: %v", err)
+	}
+	record, _ := server.Get(catapulttest.Files, file.Id)
+	if record["ready_for_processing"] != true {
+		t.Errorf("stored record = %v, want ready_for_processing", record)
+	}
+
+	experiments, err := backend.GetExperimentsByNames([]string{"exp1", "exp2"})
+	if err != nil || len(experiments) != 2 {
+		t.Fatalf("GetExperimentsByNames() = %+v, %v", experiments, err)
+	}
+}
+
+func TestServer_NotFoundAndAuth(t *testing.T) {
+	server := catapulttest.NewServer()
+	server.Token = "secret"
+	defer server.Close()
+
+	backend := newBackend(server)
+	if _, err := backend.GetFileById(99); !catapult_sentinel.IsNotFound(err) {
+		t.Errorf("GetFileById() error = %v, want not found", err)
+	}
+
+	backend.Token = "wrong"
+	if _, err := backend.GetAllFolderWatchingLocations(); !catapult_sentinel.IsUnauthorized(err) {
+		t.Errorf("GetAllFolderWatchingLocations() error = %v, want unauthorized", err)
+	}
+}
+
+func TestServer_Pagination(t *testing.T) {
+	server := catapulttest.NewServer()
+	server.PageSize = 2
+	defer server.Close()
+	for i := 0; i < 5; i++ {
+		server.Add(catapulttest.RunConfigs, catapulttest.Record{"config_file_path": "exp\\run.cat.yml", "experiment": 2})
+	}
+	server.Add(catapulttest.RunConfigs, catapulttest.Record{"config_file_path": "exp\\run.cat.yml", "experiment": 3})
+
+	configs, err := newBackend(server).IterCatapultRunConfig("exp", 2).Collect(context.Background())
+	if err != nil || len(configs) != 5 {
+		t.Fatalf("Collect() = %d configs, %v, want 5", len(configs), err)
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	server := catapulttest.NewServer()
+	defer server.Close()
+	backend := newBackend(server)
+
+	server.AddFault(catapulttest.Fault{Path: "/api/files/", Times: 2, Status: http.StatusServiceUnavailable})
+	if _, err := backend.GetFile("a.raw"); err != nil {
+		t.Errorf("GetFile() after two 503 = %v, want retried success", err)
+	}
+
+	server.AddFault(catapulttest.Fault{Times: 1, Malformed: true})
+	if _, err := backend.GetFile("a.raw"); err == nil {
+		t.Errorf("GetFile() with malformed body succeeded")
+	}
+
+	server.AddFault(catapulttest.Fault{Latency: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := backend.GetFileContext(ctx, "a.raw"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetFileContext() with latency = %v, want deadline exceeded", err)
+	}
+	server.ClearFaults()
+
+	if len(server.Requests()) != 5 {
+		t.Errorf("Requests() = %d, want 5", len(server.Requests()))
+	}
+}
@@ -0,0 +1,239 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumXXH64  = "xxh64"
+)
+
+const (
+	// DefaultChecksumChunkSize is the size of each read while hashing.
+	DefaultChecksumChunkSize = 1 << 20
+	// DefaultChecksumBytesPerSecond keeps hashing from competing with an
+	// instrument still writing to the same disk.
+	DefaultChecksumBytesPerSecond = 100 << 20
+)
+
+// RateLimiter spreads reads so that they stay under BytesPerSecond on average.
+// It is safe for concurrent use and a rate of zero does not limit.
+type RateLimiter struct {
+	BytesPerSecond int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{BytesPerSecond: bytesPerSecond}
+}
+
+// Wait accounts for n bytes read and blocks until the previous reads are paid
+// for.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil || l.BytesPerSecond <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.BytesPerSecond) * float64(time.Second)))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Checksummer hashes acquisitions. Checksums read "algorithm:hex".
+type Checksummer struct {
+	Algorithm string
+	ChunkSize int
+	Limiter   *RateLimiter
+}
+
+func NewChecksummer(algorithm string, bytesPerSecond int64) *Checksummer {
+	return &Checksummer{
+		Algorithm: algorithm,
+		ChunkSize: DefaultChecksumChunkSize,
+		Limiter:   NewRateLimiter(bytesPerSecond),
+	}
+}
+
+func (c *Checksummer) newHash() (hash.Hash, error) {
+	switch c.Algorithm {
+	case ChecksumSHA256, "":
+		return sha256.New(), nil
+	case ChecksumXXH64:
+		return newXXH64(), nil
+	}
+	return nil, fmt.Errorf("catapult: unknown checksum algorithm %q", c.Algorithm)
+}
+
+func (c *Checksummer) algorithm() string {
+	if c.Algorithm == "" {
+		return ChecksumSHA256
+	}
+	return c.Algorithm
+}
+
+// Sum returns the checksum of the file or directory acquisition at path.
+func (c *Checksummer) Sum(ctx context.Context, path string) (string, error) {
+	return c.SumAcquisition(ctx, path, nil)
+}
+
+// SumAcquisition returns the checksum of the acquisition at path along with
+// its companion files, such as the .wiff.scan of a .wiff file. Companions that
+// do not exist are left out. When none exists the checksum is the one of path
+// alone, otherwise path and its companions are hashed like the entries of a
+// directory.
+func (c *Checksummer) SumAcquisition(ctx context.Context, path string, companions []string) (string, error) {
+	entries := []string{path}
+	for _, companion := range companions {
+		if _, err := os.Stat(companion); err == nil {
+			entries = append(entries, companion)
+		}
+	}
+	var sum []byte
+	var err error
+	if len(entries) == 1 {
+		_, sum, err = c.sumPath(ctx, path)
+	} else {
+		sum, err = c.sumEntries(ctx, entries)
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.algorithm() + ":" + hex.EncodeToString(sum), nil
+}
+
+// sumPath hashes the file or directory at path and returns its kind, 'f' or
+// 'd', along with the hash.
+func (c *Checksummer) sumPath(ctx context.Context, path string) (byte, []byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	if info.IsDir() {
+		sum, err := c.sumDir(ctx, path)
+		return 'd', sum, err
+	}
+	sum, err := c.sumFile(ctx, path)
+	return 'f', sum, err
+}
+
+// sumEntries hashes the files and directories at paths the way sumDir hashes
+// the entries of a directory, in name order.
+func (c *Checksummer) sumEntries(ctx context.Context, paths []string) ([]byte, error) {
+	paths = append([]string(nil), paths...)
+	sort.Slice(paths, func(i, j int) bool { return filepath.Base(paths[i]) < filepath.Base(paths[j]) })
+	h, err := c.newHash()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		kind, sum, err := c.sumPath(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		h.Write([]byte{kind})
+		h.Write([]byte(filepath.Base(path)))
+		h.Write([]byte{0})
+		h.Write(sum)
+	}
+	return h.Sum(nil), nil
+}
+
+func (c *Checksummer) sumFile(ctx context.Context, path string) ([]byte, error) {
+	h, err := c.newHash()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size := c.ChunkSize
+	if size <= 0 {
+		size = DefaultChecksumChunkSize
+	}
+	buf := make([]byte, size)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			if err := c.Limiter.Wait(ctx, n); err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			return h.Sum(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sumDir hashes a directory as a Merkle tree: every directory hashes the name,
+// kind and hash of its entries in name order, so the result only depends on
+// the names and content below it, not on timestamps or listing order.
+func (c *Checksummer) sumDir(ctx context.Context, dir string) ([]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	h, err := c.newHash()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		var kind byte
+		var sum []byte
+		switch {
+		case entry.IsDir():
+			kind = 'd'
+			sum, err = c.sumDir(ctx, path)
+		case entry.Type().IsRegular():
+			kind = 'f'
+			sum, err = c.sumFile(ctx, path)
+		default:
+			// links and devices have no content of their own
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		h.Write([]byte{kind})
+		h.Write([]byte(entry.Name()))
+		h.Write([]byte{0})
+		h.Write(sum)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,219 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXXH64(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"as", 0x1c330fb2d66be179},
+		{"asd", 0x631c37ce72a97393},
+		{"asdf", 0x415872f599cea71e},
+		{"Call me Ishmael. Some years ago--never mind how long precisely-", 0x02a2e85470d6fd96},
+	}
+	for _, tt := range tests {
+		d := newXXH64()
+		d.Write([]byte(tt.input))
+		if got := d.Sum64(); got != tt.want {
+			t.Errorf("xxh64(%q) = %016x, want %016x", tt.input, got, tt.want)
+		}
+
+		// the same input written in pieces
+		d.Reset()
+		for _, c := range []byte(tt.input) {
+			d.Write([]byte{c})
+		}
+		if got := d.Sum64(); got != tt.want {
+			t.Errorf("xxh64(%q) written bytewise = %016x, want %016x", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestChecksummer_Sum(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "sample.raw")
+	if err := os.WriteFile(path, []byte("abc"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	sum, err := NewChecksummer(ChecksumSHA256, 0).Sum(context.Background(), path)
+	if err != nil || sum != "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("Sum() sha256 = %q, %v", sum, err)
+	}
+	sum, err = NewChecksummer(ChecksumXXH64, 0).Sum(context.Background(), path)
+	if err != nil || sum != "xxh64:44bc2cf5ad770999" {
+		t.Errorf("Sum() xxh64 = %q, %v", sum, err)
+	}
+	if _, err := NewChecksummer("md5", 0).Sum(context.Background(), path); err == nil {
+		t.Errorf("Sum() with unknown algorithm succeeded")
+	}
+}
+
+func TestChecksummer_Directory(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a.d", "b.d"} {
+		writeTestFile(t, filepath.Join(root, dir, "analysis.tdf"), 10)
+		writeTestFile(t, filepath.Join(root, dir, "sub", "data.bin"), 20)
+	}
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(root, "b.d", "analysis.tdf"), past, past)
+
+	checksummer := NewChecksummer(ChecksumSHA256, 0)
+	a, err := checksummer.Sum(context.Background(), filepath.Join(root, "a.d"))
+	if err != nil {
+		t.Fatalf("Sum() error: %v", err)
+	}
+	b, _ := checksummer.Sum(context.Background(), filepath.Join(root, "b.d"))
+	if a != b {
+		t.Errorf("Sum() of identical folders = %s and %s", a, b)
+	}
+
+	os.Rename(filepath.Join(root, "b.d", "sub", "data.bin"), filepath.Join(root, "b.d", "sub", "renamed.bin"))
+	if renamed, _ := checksummer.Sum(context.Background(), filepath.Join(root, "b.d")); renamed == a {
+		t.Errorf("Sum() did not change after renaming a file")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1000)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := limiter.Wait(context.Background(), 50); err != nil {
+			t.Fatalf("Wait() error: %v", err)
+		}
+	}
+	// the last 50 bytes are paid for by the next caller
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("4 waits of 50 bytes at 1000 B/s took %v, want at least 150ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx, 1000); err == nil {
+		t.Errorf("Wait() with cancelled context succeeded")
+	}
+}
+
+func TestScanner_ChecksumWhenReady(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	path := filepath.Join(root, "exp1", "sample_01.raw")
+	writeTestFile(t, path, 10)
+
+	scanner := NewScanner(db)
+	scanner.Stability.Default = StabilityRule{MinScans: 1}
+	scanner.Checksums = NewChecksummer(ChecksumXXH64, 0)
+	scanner.Outbox = true
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if file, _ := GetFile(db, path); file.Checksum != "" {
+		t.Fatalf("checksum %q recorded before the file is ready", file.Checksum)
+	}
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil || len(task.ReadyFile) != 1 || task.ReadyFile[0].Checksum != "" {
+		t.Fatalf("Scan() = %+v, %v, want a ready file hashed later", task.ReadyFile, err)
+	}
+
+	// a cancelled pass leaves the file pending
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if hashed, err := scanner.HashPending(ctx, location); !errors.Is(err, context.Canceled) || len(hashed) != 0 {
+		t.Fatalf("HashPending() cancelled = %+v, %v", hashed, err)
+	}
+	hashed, err := scanner.HashPending(context.Background(), location)
+	if err != nil || len(hashed) != 1 || !hashed[0].ReadyForProcessing || !strings.HasPrefix(hashed[0].Checksum, "xxh64:") {
+		t.Fatalf("HashPending() = %+v, %v, want the ready file with checksum", hashed, err)
+	}
+	if file, _ := GetFile(db, path); file.Checksum != hashed[0].Checksum {
+		t.Errorf("stored checksum = %q, want %q", file.Checksum, hashed[0].Checksum)
+	}
+	// the queued update keeps the ready flag along with the checksum
+	entries, _ := PendingOutbox(db, 10)
+	var updates []string
+	for _, entry := range entries {
+		if entry.Kind == OutboxFile && entry.Operation == OutboxUpdate {
+			updates = append(updates, string(entry.Payload))
+		}
+	}
+	if len(updates) != 1 || !strings.Contains(updates[0], hashed[0].Checksum) || !strings.Contains(updates[0], `"ready_for_processing":true`) {
+		t.Errorf("queued updates = %v, want the ready file with checksum", updates)
+	}
+	if hashed, err := scanner.HashPending(context.Background(), location); err != nil || len(hashed) != 0 {
+		t.Errorf("HashPending() again = %+v, %v, want nothing pending", hashed, err)
+	}
+
+	writeTestFile(t, path, 20)
+	if _, err := scanner.FullScan(context.Background(), location); err != nil {
+		t.Fatalf("FullScan() error: %v", err)
+	}
+	if file, _ := GetFile(db, path); file.Checksum != "" {
+		t.Errorf("checksum %q kept after the file changed", file.Checksum)
+	}
+	// and the backend is told to drop it
+	entries, _ = PendingOutbox(db, 10)
+	last := entries[len(entries)-1]
+	if last.Operation != OutboxUpdate || !strings.Contains(string(last.Payload), `"clear_checksum":true`) {
+		t.Errorf("queued update after the change = %s, want the checksum cleared", last.Payload)
+	}
+}
+
+func TestChecksummer_SumAcquisition(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "sample.wiff")
+	scan := path + ".scan"
+	os.WriteFile(path, []byte("abc"), 0o644)
+	checksummer := NewChecksummer(ChecksumSHA256, 0)
+
+	alone, _ := checksummer.Sum(context.Background(), path)
+	if sum, err := checksummer.SumAcquisition(context.Background(), path, []string{scan}); err != nil || sum != alone {
+		t.Errorf("SumAcquisition() without the companion = %q, %v, want %q", sum, err, alone)
+	}
+	os.WriteFile(scan, []byte("def"), 0o644)
+	with, err := checksummer.SumAcquisition(context.Background(), path, []string{scan})
+	if err != nil || with == alone {
+		t.Fatalf("SumAcquisition() = %q, %v, want the companion hashed", with, err)
+	}
+	os.WriteFile(scan, []byte("deg"), 0o644)
+	if sum, _ := checksummer.SumAcquisition(context.Background(), path, []string{scan}); sum == with {
+		t.Errorf("SumAcquisition() unchanged after the companion changed")
+	}
+}
+
+func TestCreateTables_AddsColumns(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "old.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() error: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE files (path TEXT PRIMARY KEY, size INTEGER, is_folder BOOLEAN, last_modified TIMESTAMP, remote_id INTEGER)")
+	if err != nil {
+		t.Fatalf("create old table error: %v", err)
+	}
+	db.Exec("INSERT INTO files (path, size, is_folder, last_modified, remote_id) VALUES ('a.raw', 1, 0, 2, 3)")
+
+	if err := createTables(db); err != nil {
+		t.Fatalf("createTables() error: %v", err)
+	}
+	if err := createTables(db); err != nil {
+		t.Fatalf("createTables() second run error: %v", err)
+	}
+	file, err := GetFile(db, "a.raw")
+	if err != nil || file.RemoteId != 3 || file.Checksum != "" {
+		t.Errorf("GetFile() = %+v, %v", file, err)
+	}
+}
@@ -0,0 +1,218 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+)
+
+// RunConfigRevision is one version of a cat.yml file as read by the sentinel.
+// Revisions are numbered from 1 per path.
+type RunConfigRevision struct {
+	Path        string `json:"path"`
+	Revision    int    `json:"revision"`
+	ContentHash string `json:"content_hash"`
+	Content     string `json:"content"`
+	CatReady    bool   `json:"cat_ready"`
+	// Errors holds the validation errors of the revision, one per line, empty
+	// when it is valid.
+	Errors     string `json:"errors"`
+	RecordedAt int64  `json:"recorded_at"`
+}
+
+// RunConfigChange is a run configuration to create or update on the backend.
+// The experiment is named rather than referenced since it may not exist yet.
+type RunConfigChange struct {
+	Config         CatapultRunConfig `json:"config"`
+	ExperimentName string            `json:"experiment_name"`
+}
+
+// runConfigHash returns the content hash of a cat.yml file as "algorithm:hex".
+func runConfigHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return ChecksumSHA256 + ":" + hex.EncodeToString(sum[:])
+}
+
+// RecordRunConfigRevision stores revision as the next revision of its path and
+// returns it numbered. When the content hash is the one of the latest revision
+// nothing is stored and the latest revision is returned with false.
+func RecordRunConfigRevision(db *sql.DB, revision RunConfigRevision) (RunConfigRevision, bool, error) {
+	latest, err := LatestRunConfigRevision(db, revision.Path)
+	if err != nil {
+		return RunConfigRevision{}, false, err
+	}
+	if latest.Revision != 0 && latest.ContentHash == revision.ContentHash {
+		return latest, false, nil
+	}
+	revision.Revision = latest.Revision + 1
+	if revision.RecordedAt == 0 {
+		revision.RecordedAt = time.Now().Unix()
+	}
+	if err := insertRunConfigRevision(db, revision); err != nil {
+		return RunConfigRevision{}, false, err
+	}
+	return revision, true, nil
+}
+
+func insertRunConfigRevision(db Execer, revision RunConfigRevision) error {
+	_, err := db.Exec("INSERT INTO run_config_revisions (path, revision, content_hash, content, cat_ready, errors, recorded_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		revision.Path, revision.Revision, revision.ContentHash, revision.Content, revision.CatReady, revision.Errors, revision.RecordedAt)
+	return err
+}
+
+// LatestRunConfigRevision returns the latest revision of path, a zero revision
+// when there is none.
+func LatestRunConfigRevision(db *sql.DB, path string) (RunConfigRevision, error) {
+	var revision RunConfigRevision
+	err := db.QueryRow("SELECT path, revision, content_hash, content, cat_ready, errors, recorded_at FROM run_config_revisions WHERE path = ? ORDER BY revision DESC LIMIT 1", path).
+		Scan(&revision.Path, &revision.Revision, &revision.ContentHash, &revision.Content, &revision.CatReady, &revision.Errors, &revision.RecordedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RunConfigRevision{}, nil
+	}
+	return revision, err
+}
+
+// GetRunConfigRevisions returns the revisions of path, the most recent first.
+func GetRunConfigRevisions(db *sql.DB, path string) ([]RunConfigRevision, error) {
+	rows, err := db.Query("SELECT path, revision, content_hash, content, cat_ready, errors, recorded_at FROM run_config_revisions WHERE path = ? ORDER BY revision DESC", path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []RunConfigRevision
+	for rows.Next() {
+		var revision RunConfigRevision
+		if err := rows.Scan(&revision.Path, &revision.Revision, &revision.ContentHash, &revision.Content, &revision.CatReady, &revision.Errors, &revision.RecordedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// wasRunConfigReady reports whether a valid revision of path before revision
+// was cat_ready, and so was sent to the backend.
+func wasRunConfigReady(db *sql.DB, path string, revision int) (bool, error) {
+	var ready bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM run_config_revisions WHERE path = ? AND revision < ? AND cat_ready = 1 AND errors = '')", path, revision).Scan(&ready)
+	return ready, err
+}
+
+// ReviseRunConfig reads the cat.yml file at path, records it in the revision
+// history and returns the change to send to the backend. It returns false when
+// the content did not change since the latest revision or there is nothing to
+// send. Invalid revisions are recorded with their errors, which are returned,
+// but never sent, so that the backend keeps the last valid one. A valid
+// revision is sent when it is cat_ready or an earlier revision was, so that
+// withdrawing readiness reaches the backend, with the ready flags of the FASTA
+// and spectral library files it references. When Outbox is set the change is
+// queued in the transaction recording the revision, so that an edit that
+// could not be queued is revised again by the next scan.
+func (s *Scanner) ReviseRunConfig(location FolderWatchingLocation, path string) (RunConfigChange, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RunConfigChange{}, false, err
+	}
+	spec, content, invalid := ParseRunConfig(path, data)
+	latest, err := LatestRunConfigRevision(s.DB, path)
+	if err != nil {
+		return RunConfigChange{}, false, err
+	}
+	revision := RunConfigRevision{Path: path, ContentHash: runConfigHash(data), Content: string(data), CatReady: spec.CatReady, RecordedAt: time.Now().Unix()}
+	if latest.Revision != 0 && latest.ContentHash == revision.ContentHash {
+		return RunConfigChange{}, false, nil
+	}
+	revision.Revision = latest.Revision + 1
+	if invalid != nil {
+		revision.Errors = invalid.Error()
+		if err := insertRunConfigRevision(s.DB, revision); err != nil {
+			return RunConfigChange{}, false, err
+		}
+		return RunConfigChange{}, false, invalid
+	}
+	deps, err := s.resolveDependencies(location, path, spec)
+	if err != nil {
+		return RunConfigChange{}, false, err
+	}
+	send := spec.CatReady
+	if !send {
+		if send, err = wasRunConfigReady(s.DB, path, revision.Revision); err != nil {
+			return RunConfigChange{}, false, err
+		}
+	}
+	var change RunConfigChange
+	if send {
+		change = s.runConfigChange(location, revision, spec, content, deps)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return RunConfigChange{}, false, err
+	}
+	defer tx.Rollback()
+	if err := insertRunConfigRevision(tx, revision); err != nil {
+		return RunConfigChange{}, false, err
+	}
+	if send && s.Outbox {
+		if err := EnqueueRunConfigChange(tx, change); err != nil {
+			return RunConfigChange{}, false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return RunConfigChange{}, false, err
+	}
+	return change, send, nil
+}
+
+// runConfigChange returns the change sending revision, a valid revision of a
+// run config of location, with the ready flags of its dependencies.
+func (s *Scanner) runConfigChange(location FolderWatchingLocation, revision RunConfigRevision, spec RunConfigSpec, content map[string]interface{}, deps []RunConfigDependency) RunConfigChange {
+	config := CatapultRunConfig{
+		ConfigFilePath:         revision.Path,
+		FolderWatchingLocation: location.Id,
+		Content:                content,
+		Spec:                   &spec,
+		ContentHash:            revision.ContentHash,
+		Revision:               revision.Revision,
+	}
+	setDependencyFlags(&config, deps)
+	return RunConfigChange{Config: config, ExperimentName: s.experimentResolver(location).Name(revision.Path)}
+}
+
+// applyRunConfigChange updates the backend record of the configuration file,
+// found by path, or creates it when the file was never sent. The experiment is
+// looked up, or created, by name.
+func (r *OutboxReplayer) applyRunConfigChange(ctx context.Context, change RunConfigChange) error {
+	config := change.Config
+	if change.ExperimentName != "" {
+		experiment, err := r.Backend.GetExperimentByNameContext(ctx, change.ExperimentName)
+		if err != nil {
+			return err
+		}
+		config.Experiment = experiment.Id
+	}
+	existing, err := r.Backend.FilterCatapultRunConfigContext(ctx, config.ConfigFilePath, 0)
+	if err != nil {
+		return err
+	}
+	for _, remote := range existing.Results {
+		if remote.ConfigFilePath != config.ConfigFilePath {
+			continue
+		}
+		if remote.ContentHash == config.ContentHash && remote.Experiment == config.Experiment &&
+			remote.FastaRequired == config.FastaRequired && remote.FastaReady == config.FastaReady &&
+			remote.SpectralLibraryRequired == config.SpectralLibraryRequired && remote.SpectralLibraryReady == config.SpectralLibraryReady {
+			return nil
+		}
+		config.Id = remote.Id
+		_, err = r.Backend.UpdateCatapultRunConfigContext(ctx, config)
+		return err
+	}
+	_, err = r.Backend.CreateCatapultRunConfigContext(ctx, config)
+	return err
+}
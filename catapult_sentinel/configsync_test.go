@@ -0,0 +1,114 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func TestRecordRunConfigRevision(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for i, hash := range []string{"sha256:01", "sha256:01", "sha256:02", "sha256:01"} {
+		revision, recorded, err := RecordRunConfigRevision(db, RunConfigRevision{Path: "/data/run.cat.yml", ContentHash: hash})
+		if err != nil {
+			t.Fatalf("RecordRunConfigRevision() error: %v", err)
+		}
+		if recorded != (i != 1) || revision.ContentHash != hash {
+			t.Errorf("RecordRunConfigRevision(%s) = %+v, %v", hash, revision, recorded)
+		}
+	}
+	revisions, err := GetRunConfigRevisions(db, "/data/run.cat.yml")
+	if err != nil || len(revisions) != 3 || revisions[0].Revision != 3 || revisions[2].Revision != 1 {
+		t.Errorf("GetRunConfigRevisions() = %+v, %v", revisions, err)
+	}
+	if latest, err := LatestRunConfigRevision(db, "/data/other.cat.yml"); err != nil || latest.Revision != 0 {
+		t.Errorf("LatestRunConfigRevision() without revisions = %+v, %v", latest, err)
+	}
+}
+
+func TestOutboxReplayer_RunConfigChange(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), ""))
+	scanner := NewScanner(db)
+	scanner.Outbox = true
+	root := t.TempDir()
+	location := FolderWatchingLocation{FolderPath: root, ExperimentStrategy: ExperimentStrategyParent, Id: 7}
+	path := filepath.Join(root, "exp1", "run.cat.yml")
+	writeTestFile(t, path, 0)
+
+	revise := func(content string) (RunConfigChange, bool, error) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		change, ok, err := scanner.ReviseRunConfig(location, path)
+		if ok {
+			if _, err := replayer.Replay(context.Background()); err != nil {
+				t.Fatalf("Replay() error: %v", err)
+			}
+		}
+		return change, ok, err
+	}
+
+	// not ready yet, nothing to send
+	if _, ok, err := revise("cat_ready: false\n"); ok || err != nil {
+		t.Fatalf("ReviseRunConfig() not ready = %v, %v", ok, err)
+	}
+	change, ok, err := revise("cat_ready: true\nsearch_engine:\n  name: DIANN\n")
+	if !ok || err != nil || change.ExperimentName != "7/exp1" || change.Config.Revision != 2 {
+		t.Fatalf("ReviseRunConfig() ready = %+v, %v, %v", change, ok, err)
+	}
+	configs := server.Records(catapulttest.RunConfigs)
+	if len(configs) != 1 || configs[0]["content_hash"] != change.Config.ContentHash {
+		t.Fatalf("backend configs = %v", configs)
+	}
+	id := fmt.Sprint(configs[0]["id"])
+	server.Add(catapulttest.RunConfigs, catapulttest.Record{"config_file_path": path + ".bak"})
+
+	// an unchanged file is not sent again
+	if _, ok, err := revise("cat_ready: true\nsearch_engine:\n  name: DIANN\n"); ok || err != nil {
+		t.Errorf("ReviseRunConfig() unchanged = %v, %v", ok, err)
+	}
+	// an invalid edit is recorded but the backend keeps the last valid revision
+	if _, ok, err := revise("cat_ready: true\nsearch_engine:\n  name: DIANN\nsampels: []\n"); ok || err == nil {
+		t.Errorf("ReviseRunConfig() invalid = %v, %v", ok, err)
+	}
+	// withdrawing readiness updates the existing record
+	change, ok, err = revise("cat_ready: false\nsearch_engine:\n  name: DIANN\n")
+	if !ok || err != nil || change.Config.Revision != 4 {
+		t.Fatalf("ReviseRunConfig() withdrawn = %+v, %v, %v", change, ok, err)
+	}
+	configs = server.Records(catapulttest.RunConfigs)
+	if len(configs) != 2 || fmt.Sprint(configs[0]["id"]) != id || fmt.Sprint(configs[0]["revision"]) != "4" || configs[1]["content_hash"] != nil {
+		t.Errorf("backend configs after update = %v", configs)
+	}
+
+	revisions, err := GetRunConfigRevisions(db, path)
+	if err != nil || len(revisions) != 4 || revisions[1].Errors == "" || revisions[0].CatReady {
+		t.Errorf("GetRunConfigRevisions() = %+v, %v", revisions, err)
+	}
+
+	// an edit that could not be queued is revised again
+	db.Exec("ALTER TABLE outbox RENAME TO outbox_off")
+	ready := "cat_ready: true\nsearch_engine:\n  name: MSFragger\n"
+	_, ok, err = revise(ready)
+	db.Exec("ALTER TABLE outbox_off RENAME TO outbox")
+	if ok || err == nil {
+		t.Fatalf("ReviseRunConfig() without an outbox = %v, %v, want an error", ok, err)
+	}
+	if latest, _ := LatestRunConfigRevision(db, path); latest.Revision != 4 {
+		t.Errorf("LatestRunConfigRevision() after a failed enqueue = %d, want 4", latest.Revision)
+	}
+	if change, ok, err := revise(ready); !ok || err != nil || change.Config.Revision != 5 {
+		t.Errorf("ReviseRunConfig() retried = %+v, %v, %v", change, ok, err)
+	}
+}
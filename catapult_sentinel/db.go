@@ -3,15 +3,211 @@ package catapult_sentinel
 import (
 	"database/sql"
 	_ "modernc.org/sqlite"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 )
 
+// Tombstone records a file that disappeared from its location. FirstMissing is
+// the unix time of the first scan that did not find it and Reported the unix
+// time it was reported as deleted, 0 while still within the grace period.
+// LastModified, Checksum and the device and inode are kept to recognize the
+// file if it turns up in another location.
+type Tombstone struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	IsFolder     bool   `json:"is_folder"`
+	RemoteId     int64  `json:"remote_id"`
+	FirstMissing int64  `json:"first_missing"`
+	Reported     int64  `json:"reported"`
+	LastModified int64  `json:"last_modified"`
+	Checksum     string `json:"checksum"`
+	Device       int64  `json:"device"`
+	Inode        int64  `json:"inode"`
+}
+
 type LocalFile struct {
 	Path         string `json:"path"`
 	Size         int64  `json:"size"`
 	IsFolder     bool   `json:"is_folder"`
 	LastModified int64  `json:"last_modified"`
 	RemoteId     int64  `json:"remote_id"`
+	// Checksum is the content hash as "algorithm:hex", empty until the file is
+	// stable and hashed.
+	Checksum string `json:"checksum"`
+	// Device and Inode identify the file on its filesystem, 0 where the
+	// platform has no such notion. They let renames be told from deletions.
+	Device int64 `json:"device"`
+	Inode  int64 `json:"inode"`
+}
+
+// busyTimeout is the number of milliseconds a connection waits for a lock.
+const busyTimeout = 5000
+
+// schema holds the statements creating every table of the local database, in
+// the order they have to run.
+var schema = []string{
+	`
+	 CREATE TABLE IF NOT EXISTS files (
+	  path TEXT PRIMARY KEY,
+	  size INTEGER,
+	  is_folder BOOLEAN,
+	  last_modified TIMESTAMP,
+	  remote_id INTEGER,
+	  checksum TEXT NOT NULL DEFAULT '',
+	  scrubbed_at INTEGER NOT NULL DEFAULT 0,
+	  device INTEGER NOT NULL DEFAULT 0,
+	  inode INTEGER NOT NULL DEFAULT 0,
+	  metadata TEXT NOT NULL DEFAULT '',
+	  checksum_pending INTEGER NOT NULL DEFAULT 0
+	 );`,
+	`
+	 CREATE TABLE IF NOT EXISTS outbox (
+	  id INTEGER PRIMARY KEY AUTOINCREMENT,
+	  kind TEXT NOT NULL,
+	  operation TEXT NOT NULL,
+	  dedupe_key TEXT NOT NULL,
+	  payload TEXT NOT NULL,
+	  attempts INTEGER NOT NULL DEFAULT 0,
+	  last_error TEXT NOT NULL DEFAULT '',
+	  created_at INTEGER NOT NULL,
+	  updated_at INTEGER NOT NULL,
+	  dead BOOLEAN NOT NULL DEFAULT 0
+	 );`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS outbox_pending_dedupe ON outbox (dedupe_key) WHERE dead = 0;`,
+	`
+	 CREATE TABLE IF NOT EXISTS tombstones (
+	  path TEXT PRIMARY KEY,
+	  size INTEGER,
+	  is_folder BOOLEAN,
+	  remote_id INTEGER,
+	  first_missing INTEGER NOT NULL,
+	  reported INTEGER NOT NULL DEFAULT 0,
+	  last_modified INTEGER NOT NULL DEFAULT 0,
+	  checksum TEXT NOT NULL DEFAULT '',
+	  device INTEGER NOT NULL DEFAULT 0,
+	  inode INTEGER NOT NULL DEFAULT 0
+	 );`,
+	`
+	 CREATE TABLE IF NOT EXISTS stability (
+	  path TEXT PRIMARY KEY,
+	  size INTEGER,
+	  last_modified INTEGER,
+	  unchanged_scans INTEGER NOT NULL DEFAULT 0,
+	  unchanged_since INTEGER NOT NULL,
+	  ready BOOLEAN NOT NULL DEFAULT 0
+	 );`,
+	`
+	 CREATE TABLE IF NOT EXISTS stability_history (
+	  path TEXT NOT NULL,
+	  observed_at INTEGER NOT NULL,
+	  size INTEGER,
+	  last_modified INTEGER
+	 );`,
+	`CREATE INDEX IF NOT EXISTS stability_history_path ON stability_history (path, observed_at);`,
+	`
+	 CREATE TABLE IF NOT EXISTS folder_stats (
+	  path TEXT PRIMARY KEY,
+	  dir_modified INTEGER NOT NULL,
+	  size INTEGER,
+	  last_modified INTEGER
+	 );`,
+	`
+	 CREATE TABLE IF NOT EXISTS directories (
+	  path TEXT PRIMARY KEY,
+	  modified INTEGER NOT NULL
+	 );`,
+	`
+	 CREATE TABLE IF NOT EXISTS experiment_vendors (
+	  name TEXT PRIMARY KEY,
+	  vendor TEXT NOT NULL
+	 );`,
+	`
+	 CREATE TABLE IF NOT EXISTS scrub_history (
+	  id INTEGER PRIMARY KEY AUTOINCREMENT,
+	  path TEXT NOT NULL,
+	  scrubbed_at INTEGER NOT NULL,
+	  status TEXT NOT NULL,
+	  expected TEXT NOT NULL DEFAULT '',
+	  actual TEXT NOT NULL DEFAULT '',
+	  error TEXT NOT NULL DEFAULT ''
+	 );`,
+	`CREATE INDEX IF NOT EXISTS scrub_history_path ON scrub_history (path, scrubbed_at);`,
+	`
+	 CREATE TABLE IF NOT EXISTS run_config_revisions (
+	  id INTEGER PRIMARY KEY AUTOINCREMENT,
+	  path TEXT NOT NULL,
+	  revision INTEGER NOT NULL,
+	  content_hash TEXT NOT NULL,
+	  content TEXT NOT NULL,
+	  cat_ready BOOLEAN NOT NULL DEFAULT 0,
+	  errors TEXT NOT NULL DEFAULT '',
+	  recorded_at INTEGER NOT NULL
+	 );`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS run_config_revisions_path ON run_config_revisions (path, revision);`,
+	`
+	 CREATE TABLE IF NOT EXISTS run_config_dependencies (
+	  config_path TEXT NOT NULL,
+	  kind TEXT NOT NULL,
+	  reference TEXT NOT NULL,
+	  path TEXT NOT NULL,
+	  status TEXT NOT NULL,
+	  error TEXT NOT NULL DEFAULT '',
+	  size INTEGER NOT NULL DEFAULT 0,
+	  last_modified INTEGER NOT NULL DEFAULT 0,
+	  PRIMARY KEY (config_path, kind, reference)
+	 );`,
+	`
+	 CREATE TABLE IF NOT EXISTS move_candidates (
+	  path TEXT PRIMARY KEY,
+	  size INTEGER NOT NULL,
+	  last_modified INTEGER NOT NULL,
+	  algorithm TEXT NOT NULL,
+	  checksum TEXT NOT NULL DEFAULT '',
+	  hashed BOOLEAN NOT NULL DEFAULT 0
+	 );`,
+}
+
+// columns lists the columns added to tables after they were first released,
+// so that databases created by older versions are upgraded in place.
+var columns = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{"files", "checksum", "TEXT NOT NULL DEFAULT ''"},
+	{"files", "scrubbed_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"files", "device", "INTEGER NOT NULL DEFAULT 0"},
+	{"files", "inode", "INTEGER NOT NULL DEFAULT 0"},
+	{"files", "metadata", "TEXT NOT NULL DEFAULT ''"},
+	{"files", "checksum_pending", "INTEGER NOT NULL DEFAULT 0"},
+	{"tombstones", "last_modified", "INTEGER NOT NULL DEFAULT 0"},
+	{"tombstones", "checksum", "TEXT NOT NULL DEFAULT ''"},
+	{"tombstones", "device", "INTEGER NOT NULL DEFAULT 0"},
+	{"tombstones", "inode", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+func createTables(db *sql.DB) error {
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for _, column := range columns {
+		var exists bool
+		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", column.table, column.name).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + column.table + " ADD COLUMN " + column.name + " " + column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // setupTestDB sets up an in-memory SQLite database for testing purposes.
@@ -23,20 +219,12 @@ type LocalFile struct {
 // Returns:
 // - *sql.DB: The initialized in-memory SQLite database.
 func setupTestDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("sqlite", "file:/foobar?vfs=memdb")
+	db, err := sql.Open("sqlite", "file:/"+t.Name()+"?vfs=memdb")
 	if err != nil {
 		t.Fatalf("sql.Open() error: %v", err)
 	}
 
-	createTableSQL := `
-	 CREATE TABLE IF NOT EXISTS files (
-	  path TEXT PRIMARY KEY,
-	  size INTEGER,
-	  is_folder BOOLEAN,
-	  last_modified TIMESTAMP,
-	  remote_id INTEGER
-	 );`
-	_, err = db.Exec(createTableSQL)
+	err = createTables(db)
 	if err != nil {
 		t.Fatalf("Failed to create tables: %v", err)
 	}
@@ -53,21 +241,16 @@ func setupTestDB(t *testing.T) *sql.DB {
 // Returns:
 // - *sql.DB: The initialized SQLite database.
 // - error: An error object if there was an issue initializing the database.
+//
+// Connections wait up to busyTimeout for a lock held by another one, since
+// checksums are recorded while a scan may be writing.
 func InitDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout("+strconv.Itoa(busyTimeout)+")")
 	if err != nil {
 		return nil, err
 	}
 
-	createTableSQL := `
-	 CREATE TABLE IF NOT EXISTS files (
-	  path TEXT PRIMARY KEY,
-	  size INTEGER,
-	  is_folder BOOLEAN,
-	  last_modified TIMESTAMP,
-	  remote_id INTEGER
-	 );`
-	_, err = db.Exec(createTableSQL)
+	err = createTables(db)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +269,7 @@ func CheckFileExists(db *sql.DB, path string) (bool, error) {
 
 func GetFile(db *sql.DB, path string) (LocalFile, error) {
 	var file LocalFile
-	err := db.QueryRow("SELECT path, size, is_folder, last_modified, remote_id FROM files WHERE path = ?", path).Scan(&file.Path, &file.Size, &file.IsFolder, &file.LastModified, &file.RemoteId)
+	err := db.QueryRow("SELECT path, size, is_folder, last_modified, remote_id, checksum, device, inode FROM files WHERE path = ?", path).Scan(&file.Path, &file.Size, &file.IsFolder, &file.LastModified, &file.RemoteId, &file.Checksum, &file.Device, &file.Inode)
 	if err != nil {
 		return LocalFile{}, err
 	}
@@ -94,12 +277,24 @@ func GetFile(db *sql.DB, path string) (LocalFile, error) {
 }
 
 func InsertFile(db *sql.DB, file LocalFile) error {
-	_, err := db.Exec("INSERT INTO files (path, size, is_folder, last_modified, remote_id) VALUES (?, ?, ?, ?, ?)", file.Path, file.Size, file.IsFolder, file.LastModified, file.RemoteId)
+	_, err := db.Exec("INSERT INTO files (path, size, is_folder, last_modified, remote_id, checksum, device, inode) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", file.Path, file.Size, file.IsFolder, file.LastModified, file.RemoteId, file.Checksum, file.Device, file.Inode)
 	return err
 }
 
 func UpdateFile(db *sql.DB, file LocalFile) error {
-	_, err := db.Exec("UPDATE files SET size = ?, is_folder = ?, last_modified = ?, remote_id = ? WHERE path = ?", file.Size, file.IsFolder, file.LastModified, file.RemoteId, file.Path)
+	_, err := db.Exec("UPDATE files SET size = ?, is_folder = ?, last_modified = ?, remote_id = ?, checksum = ?, device = ?, inode = ? WHERE path = ?", file.Size, file.IsFolder, file.LastModified, file.RemoteId, file.Checksum, file.Device, file.Inode, file.Path)
+	return err
+}
+
+// SetRemoteId records the id the backend assigned to the file at path.
+func SetRemoteId(db *sql.DB, path string, remoteId int64) error {
+	_, err := db.Exec("UPDATE files SET remote_id = ? WHERE path = ?", remoteId, path)
+	return err
+}
+
+// SetChecksum records the content hash of the file at path.
+func SetChecksum(db *sql.DB, path string, checksum string) error {
+	_, err := db.Exec("UPDATE files SET checksum = ? WHERE path = ?", checksum, path)
 	return err
 }
 
@@ -109,14 +304,14 @@ func UpdateMultipleFiles(db *sql.DB, files []LocalFile) error {
 		return err
 	}
 
-	stmt, err := tx.Prepare("UPDATE files SET size = ?, is_folder = ?, last_modified = ?, remote_id = ? WHERE path = ?")
+	stmt, err := tx.Prepare("UPDATE files SET size = ?, is_folder = ?, last_modified = ?, remote_id = ?, checksum = ?, device = ?, inode = ? WHERE path = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, file := range files {
-		_, err = stmt.Exec(file.Size, file.IsFolder, file.LastModified, file.RemoteId, file.Path)
+		_, err = stmt.Exec(file.Size, file.IsFolder, file.LastModified, file.RemoteId, file.Checksum, file.Device, file.Inode, file.Path)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -125,3 +320,177 @@ func UpdateMultipleFiles(db *sql.DB, files []LocalFile) error {
 
 	return tx.Commit()
 }
+
+func DeleteFile(db *sql.DB, path string) error {
+	_, err := db.Exec("DELETE FROM files WHERE path = ?", path)
+	return err
+}
+
+// underRoot returns the condition selecting the values of column that are
+// root or a path below it, and its arguments. Matching root followed by a
+// separator keeps a location from matching the files of a sibling sharing its
+// prefix, such as /data/ab for /data/a.
+func underRoot(column string, root string) (string, []interface{}) {
+	prefix := strings.TrimRight(root, `/\`) + string(os.PathSeparator)
+	return "(" + column + " = ? OR substr(" + column + ", 1, length(?)) = ?)", []interface{}{root, prefix, prefix}
+}
+
+// ListFilesUnder returns every file at or below root.
+func ListFilesUnder(db *sql.DB, root string) ([]LocalFile, error) {
+	under, args := underRoot("path", root)
+	rows, err := db.Query("SELECT path, size, is_folder, last_modified, remote_id, checksum, device, inode FROM files WHERE "+under, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []LocalFile
+	for rows.Next() {
+		var file LocalFile
+		err = rows.Scan(&file.Path, &file.Size, &file.IsFolder, &file.LastModified, &file.RemoteId, &file.Checksum, &file.Device, &file.Inode)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// ListChecksumPending returns the files at or below root that became ready and
+// still wait for their checksum.
+func ListChecksumPending(db *sql.DB, root string) ([]LocalFile, error) {
+	under, args := underRoot("path", root)
+	rows, err := db.Query("SELECT path, size, is_folder, last_modified, remote_id, checksum, device, inode FROM files WHERE checksum_pending = 1 AND "+under+" ORDER BY path", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []LocalFile
+	for rows.Next() {
+		var file LocalFile
+		err = rows.Scan(&file.Path, &file.Size, &file.IsFolder, &file.LastModified, &file.RemoteId, &file.Checksum, &file.Device, &file.Inode)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// lookupBatch is the number of paths looked up by a single query, well below
+// the SQLite limit on bound parameters.
+const lookupBatch = 500
+
+// GetFiles returns the known files among paths, keyed by path.
+func GetFiles(db *sql.DB, paths []string) (map[string]LocalFile, error) {
+	files := make(map[string]LocalFile, len(paths))
+	for start := 0; start < len(paths); start += lookupBatch {
+		batch := paths[start:min(start+lookupBatch, len(paths))]
+		args := make([]interface{}, len(batch))
+		for i, path := range batch {
+			args[i] = path
+		}
+		rows, err := db.Query("SELECT path, size, is_folder, last_modified, remote_id, checksum, device, inode FROM files WHERE path IN (?"+strings.Repeat(", ?", len(batch)-1)+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var file LocalFile
+			if err := rows.Scan(&file.Path, &file.Size, &file.IsFolder, &file.LastModified, &file.RemoteId, &file.Checksum, &file.Device, &file.Inode); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			files[file.Path] = file
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// GetTombstones returns the tombstones of every path starting with root, keyed
+// by path.
+func GetTombstones(db *sql.DB, root string) (map[string]Tombstone, error) {
+	under, args := underRoot("path", root)
+	list, err := queryTombstones(db, under, args)
+	if err != nil {
+		return nil, err
+	}
+	tombstones := make(map[string]Tombstone, len(list))
+	for _, t := range list {
+		tombstones[t.Path] = t
+	}
+	return tombstones, nil
+}
+
+// GetTombstonesOutside returns the tombstones of every path that is not root
+// or below it, those of the other locations.
+func GetTombstonesOutside(db *sql.DB, root string) ([]Tombstone, error) {
+	under, args := underRoot("path", root)
+	return queryTombstones(db, "NOT "+under, args)
+}
+
+func queryTombstones(db *sql.DB, where string, args []interface{}) ([]Tombstone, error) {
+	rows, err := db.Query("SELECT path, size, is_folder, remote_id, first_missing, reported, last_modified, checksum, device, inode FROM tombstones WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tombstones []Tombstone
+	for rows.Next() {
+		var t Tombstone
+		err = rows.Scan(&t.Path, &t.Size, &t.IsFolder, &t.RemoteId, &t.FirstMissing, &t.Reported, &t.LastModified, &t.Checksum, &t.Device, &t.Inode)
+		if err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, t)
+	}
+	return tombstones, rows.Err()
+}
+
+func InsertTombstone(db *sql.DB, t Tombstone) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO tombstones (path, size, is_folder, remote_id, first_missing, reported, last_modified, checksum, device, inode) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		t.Path, t.Size, t.IsFolder, t.RemoteId, t.FirstMissing, t.Reported, t.LastModified, t.Checksum, t.Device, t.Inode)
+	return err
+}
+
+func DeleteTombstone(db *sql.DB, path string) error {
+	_, err := db.Exec("DELETE FROM tombstones WHERE path = ?", path)
+	return err
+}
+
+// ReportTombstone marks the tombstone as reported and drops the file from the
+// files table in a single transaction, so a crash cannot leave the file both
+// known and reported.
+func ReportTombstone(db *sql.DB, path string, reported int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := reportTombstone(tx, path, reported); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// reportTombstone is ReportTombstone within tx.
+func reportTombstone(tx *sql.Tx, path string, reported int64) error {
+	_, err := tx.Exec("UPDATE tombstones SET reported = ? WHERE path = ?", reported, path)
+	for _, stmt := range []string{
+		"DELETE FROM files WHERE path = ?",
+		"DELETE FROM stability WHERE path = ?",
+		"DELETE FROM stability_history WHERE path = ?",
+		"DELETE FROM folder_stats WHERE path = ?",
+	} {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(stmt, path)
+	}
+	return err
+}
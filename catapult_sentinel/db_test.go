@@ -134,8 +134,8 @@ func TestUpdateMultipleFiles(t *testing.T) {
 
 	// Insert test files
 	files := []LocalFile{
-		{"test1.txt", 123, false, 1234567890, 1},
-		{"test2.txt", 456, true, 9876543210, 2},
+		{Path: "test1.txt", Size: 123, IsFolder: false, LastModified: 1234567890, RemoteId: 1},
+		{Path: "test2.txt", Size: 456, IsFolder: true, LastModified: 9876543210, RemoteId: 2},
 	}
 	for _, file := range files {
 		_, err := db.Exec("INSERT INTO files (path, size, is_folder, last_modified, remote_id) VALUES (?, ?, ?, ?, ?)", file.Path, file.Size, file.IsFolder, file.LastModified, file.RemoteId)
@@ -146,8 +146,8 @@ func TestUpdateMultipleFiles(t *testing.T) {
 
 	// Update files
 	updatedFiles := []LocalFile{
-		{"test1.txt", 789, true, 1111111111, 3},
-		{"test2.txt", 101112, false, 2222222222, 4},
+		{Path: "test1.txt", Size: 789, IsFolder: true, LastModified: 1111111111, RemoteId: 3},
+		{Path: "test2.txt", Size: 101112, IsFolder: false, LastModified: 2222222222, RemoteId: 4},
 	}
 	err := UpdateMultipleFiles(db, updatedFiles)
 	if err != nil {
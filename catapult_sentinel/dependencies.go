@@ -0,0 +1,396 @@
+package catapult_sentinel
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Kinds of files a run config depends on.
+const (
+	DependencyFasta           = "fasta"
+	DependencySpectralLibrary = "spectral_library"
+)
+
+// Statuses of a run config dependency. Only ready dependencies count towards
+// the ready flags of the config.
+const (
+	DependencyMissing  = "missing"
+	DependencyUnstable = "unstable"
+	DependencyInvalid  = "invalid"
+	DependencyReady    = "ready"
+)
+
+// SpectralLibraryExtensions lists the spectral library formats recognised:
+// DIA-NN .speclib, Parquet, tab or comma separated tables, NIST .msp and
+// SpectraST .sptxt text libraries, and BiblioSpec and EncyclopeDIA SQLite
+// libraries.
+var SpectralLibraryExtensions = []string{".speclib", ".parquet", ".tsv", ".csv", ".xls", ".msp", ".sptxt", ".blib", ".dlib", ".elib"}
+
+// RunConfigDependency is a FASTA or spectral library file referenced by a run
+// config. Reference is the path as written in the cat.yml file and Path where
+// it was resolved. Size and LastModified are those of the file when it was
+// last validated.
+type RunConfigDependency struct {
+	ConfigPath   string `json:"config_path"`
+	Kind         string `json:"kind"`
+	Reference    string `json:"reference"`
+	Path         string `json:"path"`
+	Status       string `json:"status"`
+	Error        string `json:"error"`
+	Size         int64  `json:"size"`
+	LastModified int64  `json:"last_modified"`
+}
+
+// ResolveDependencyPath returns the path of reference, a file named in the
+// run config at configPath. Relative references are looked up in the
+// directory of the config, then at the root of location; a file found in
+// neither is expected in the directory of the config.
+func ResolveDependencyPath(location FolderWatchingLocation, configPath string, reference string) string {
+	reference = filepath.FromSlash(reference)
+	if filepath.IsAbs(reference) {
+		return filepath.Clean(reference)
+	}
+	candidates := []string{filepath.Join(filepath.Dir(configPath), reference), filepath.Join(location.FolderPath, reference)}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+// ValidateFasta checks that the file at path is a FASTA file: records made of
+// a > header naming the sequence followed by lines of residues.
+func ValidateFasta(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	records, residues := 0, 0
+	for line := 1; ; line++ {
+		text, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		text = strings.TrimRight(text, "\r\n")
+		switch {
+		case strings.HasPrefix(text, ">"):
+			if records > 0 && residues == 0 {
+				return fmt.Errorf("%s:%d: sequence of the previous record is empty", path, line)
+			}
+			if strings.TrimSpace(text[1:]) == "" {
+				return fmt.Errorf("%s:%d: header has no identifier", path, line)
+			}
+			records++
+			residues = 0
+		case strings.TrimSpace(text) == "" || strings.HasPrefix(text, ";"):
+		case records == 0:
+			return fmt.Errorf("%s:%d: sequence before the first > header", path, line)
+		default:
+			for _, c := range strings.TrimSpace(text) {
+				if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '*' || c == '-') {
+					return fmt.Errorf("%s:%d: invalid residue %q", path, line, c)
+				}
+				residues++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if records == 0 {
+		return fmt.Errorf("%s: no FASTA record", path)
+	}
+	if residues == 0 {
+		return fmt.Errorf("%s: sequence of the last record is empty", path)
+	}
+	return nil
+}
+
+// spectralLibraryColumns lists, in lower case, the precursor and fragment m/z
+// columns of the table formats written by common search engines. A table
+// library needs one of each.
+var spectralLibraryColumns = [2][]string{
+	{"precursormz", "precursor.mz", "q1", "prec_mz"},
+	{"productmz", "fragmentmz", "fragment.mz", "q3", "frag_mz"},
+}
+
+// ValidateSpectralLibrary checks that the file at path is a spectral library
+// in one of SpectralLibraryExtensions, reading its first bytes where the
+// format has a recognisable header.
+func ValidateSpectralLibrary(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	known := false
+	for _, e := range SpectralLibraryExtensions {
+		known = known || ext == e
+	}
+	if !known {
+		return fmt.Errorf("%s: unknown spectral library format, want one of %s", path, strings.Join(SpectralLibraryExtensions, ", "))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 64<<10)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+	if len(head) == 0 {
+		return fmt.Errorf("%s: empty spectral library", path)
+	}
+
+	switch ext {
+	case ".parquet":
+		if !bytes.HasPrefix(head, []byte("PAR1")) {
+			return fmt.Errorf("%s: not a Parquet file", path)
+		}
+	case ".blib", ".dlib", ".elib":
+		if !bytes.HasPrefix(head, []byte("SQLite format 3\x00")) {
+			return fmt.Errorf("%s: not a SQLite library", path)
+		}
+	case ".msp", ".sptxt":
+		first, _, _ := strings.Cut(strings.TrimLeft(string(head), " \t\r\n"), "\n")
+		if !strings.HasPrefix(strings.ToLower(first), "name:") {
+			return fmt.Errorf("%s: library does not start with a Name: entry", path)
+		}
+	case ".tsv", ".csv", ".xls":
+		header, _, _ := strings.Cut(string(head), "\n")
+		separator := "\t"
+		if ext == ".csv" {
+			separator = ","
+		}
+		columns := make(map[string]bool)
+		for _, column := range strings.Split(strings.TrimRight(header, "\r"), separator) {
+			columns[strings.ToLower(strings.Trim(column, `" `))] = true
+		}
+		for _, names := range spectralLibraryColumns {
+			found := false
+			for _, name := range names {
+				found = found || columns[name]
+			}
+			if !found {
+				return fmt.Errorf("%s: table has no %s column", path, names[0])
+			}
+		}
+	}
+	return nil
+}
+
+// checkDependency updates the status of dep from the file at dep.Path. Files
+// are validated once they are stable, and again only when they change
+// afterwards; previous is the last known state of dep.
+func (s *Scanner) checkDependency(dep *RunConfigDependency, previous RunConfigDependency) error {
+	info, err := os.Stat(dep.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		dep.Status = DependencyMissing
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		dep.Status, dep.Error = DependencyInvalid, dep.Path+" is a directory"
+		return nil
+	}
+	size, modified := info.Size(), info.ModTime().Unix()
+	if s.Stability != nil {
+		if _, err := s.Stability.Observe(dep.Path, size, modified, time.Now()); err != nil {
+			return err
+		}
+		stability, err := GetStability(s.DB, dep.Path)
+		if err != nil {
+			return err
+		}
+		if !stability.Ready {
+			dep.Status = DependencyUnstable
+			return nil
+		}
+	}
+	if previous.Path == dep.Path && previous.Size == size && previous.LastModified == modified &&
+		(previous.Status == DependencyReady || previous.Status == DependencyInvalid) {
+		dep.Status, dep.Error = previous.Status, previous.Error
+		dep.Size, dep.LastModified = size, modified
+		return nil
+	}
+	validate := ValidateFasta
+	if dep.Kind == DependencySpectralLibrary {
+		validate = ValidateSpectralLibrary
+	}
+	dep.Status, dep.Size, dep.LastModified = DependencyReady, size, modified
+	if err := validate(dep.Path); err != nil {
+		dep.Status, dep.Error = DependencyInvalid, err.Error()
+	}
+	return nil
+}
+
+// resolveDependencies checks the FASTA and spectral library files referenced
+// by spec, the run config at configPath, and stores their status in place of
+// the previous ones.
+func (s *Scanner) resolveDependencies(location FolderWatchingLocation, configPath string, spec RunConfigSpec) ([]RunConfigDependency, error) {
+	stored, err := GetRunConfigDependencies(s.DB, configPath)
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[string]RunConfigDependency, len(stored))
+	for _, dep := range stored {
+		previous[dep.Kind+":"+dep.Reference] = dep
+	}
+
+	var deps []RunConfigDependency
+	add := func(kind string, reference string) error {
+		dep := RunConfigDependency{ConfigPath: configPath, Kind: kind, Reference: reference, Path: ResolveDependencyPath(location, configPath, reference)}
+		if err := s.checkDependency(&dep, previous[kind+":"+reference]); err != nil {
+			return err
+		}
+		deps = append(deps, dep)
+		return nil
+	}
+	for _, fasta := range spec.Fasta {
+		if err := add(DependencyFasta, fasta); err != nil {
+			return nil, err
+		}
+	}
+	if spec.SpectralLibrary != "" {
+		if err := add(DependencySpectralLibrary, spec.SpectralLibrary); err != nil {
+			return nil, err
+		}
+	}
+	return deps, SetRunConfigDependencies(s.DB, configPath, deps)
+}
+
+// SetRunConfigDependencies replaces the dependencies stored for the run config
+// at configPath.
+func SetRunConfigDependencies(db *sql.DB, configPath string, deps []RunConfigDependency) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM run_config_dependencies WHERE config_path = ?", configPath); err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		_, err := tx.Exec("INSERT OR REPLACE INTO run_config_dependencies (config_path, kind, reference, path, status, error, size, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			configPath, dep.Kind, dep.Reference, dep.Path, dep.Status, dep.Error, dep.Size, dep.LastModified)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GetRunConfigDependencies(db *sql.DB, configPath string) ([]RunConfigDependency, error) {
+	rows, err := db.Query("SELECT config_path, kind, reference, path, status, error, size, last_modified FROM run_config_dependencies WHERE config_path = ? ORDER BY kind, rowid", configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deps []RunConfigDependency
+	for rows.Next() {
+		var dep RunConfigDependency
+		if err := rows.Scan(&dep.ConfigPath, &dep.Kind, &dep.Reference, &dep.Path, &dep.Status, &dep.Error, &dep.Size, &dep.LastModified); err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+	return deps, rows.Err()
+}
+
+// setDependencyFlags sets the required and ready flags of config from deps.
+// A kind of dependency is ready when every file of that kind is.
+func setDependencyFlags(config *CatapultRunConfig, deps []RunConfigDependency) {
+	config.FastaRequired, config.FastaReady = false, true
+	config.SpectralLibraryRequired, config.SpectralLibraryReady = false, true
+	for _, dep := range deps {
+		ready := dep.Status == DependencyReady
+		switch dep.Kind {
+		case DependencyFasta:
+			config.FastaRequired = true
+			config.FastaReady = config.FastaReady && ready
+		case DependencySpectralLibrary:
+			config.SpectralLibraryRequired = true
+			config.SpectralLibraryReady = config.SpectralLibraryReady && ready
+		}
+	}
+}
+
+// Runnable reports whether the run config is cat_ready and every FASTA and
+// spectral library file it requires is ready.
+func (c CatapultRunConfig) Runnable() bool {
+	return c.Spec != nil && c.Spec.CatReady &&
+		(!c.FastaRequired || c.FastaReady) &&
+		(!c.SpectralLibraryRequired || c.SpectralLibraryReady)
+}
+
+// CheckRunConfigDependencies checks again the dependencies of the run configs
+// of location, so that files becoming ready and ready files changing or
+// disappearing are both noticed, and returns the changes to send for the
+// cat_ready configs whose ready flags changed. Configs whose latest revision
+// is invalid are left alone until they are fixed.
+func (s *Scanner) CheckRunConfigDependencies(location FolderWatchingLocation) ([]RunConfigChange, error) {
+	under, args := underRoot("config_path", location.FolderPath)
+	rows, err := s.DB.Query("SELECT DISTINCT config_path FROM run_config_dependencies WHERE "+under, args...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var changes []RunConfigChange
+	for _, path := range paths {
+		revision, err := LatestRunConfigRevision(s.DB, path)
+		if err != nil {
+			return changes, err
+		}
+		if revision.Revision == 0 || revision.Errors != "" {
+			continue
+		}
+		spec, content, err := ParseRunConfig(path, []byte(revision.Content))
+		if err != nil {
+			continue
+		}
+		stored, err := GetRunConfigDependencies(s.DB, path)
+		if err != nil {
+			return changes, err
+		}
+		var before CatapultRunConfig
+		setDependencyFlags(&before, stored)
+		deps, err := s.resolveDependencies(location, path, spec)
+		if err != nil {
+			return changes, err
+		}
+		change := s.runConfigChange(location, revision, spec, content, deps)
+		if !spec.CatReady || change.Config.FastaReady == before.FastaReady && change.Config.SpectralLibraryReady == before.SpectralLibraryReady {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
@@ -0,0 +1,163 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func TestValidateFasta(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid", ">sp|P69905|HBA_HUMAN Hemoglobin\nMVLSPADKTN\nVKAAWGKVGA\n\n>sp|P68871|HBB_HUMAN\r\nMVHLTPEEK*\r\n", false},
+		{"comment", ";generated\n>seq1\nPEPTIDE", false},
+		{"empty", "", true},
+		{"no header", "MVLSPADKTN\n", true},
+		{"no identifier", ">\nMVLSPADKTN\n", true},
+		{"empty record", ">seq1\n>seq2\nPEPTIDE\n", true},
+		{"empty last record", ">seq1\nPEPTIDE\n>seq2\n", true},
+		{"residue", ">seq1\nPEP7IDE\n", true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "db.fasta")
+		os.WriteFile(path, []byte(tt.content), 0o644)
+		if err := ValidateFasta(path); (err != nil) != tt.wantErr {
+			t.Errorf("%s: ValidateFasta() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateSpectralLibrary(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"lib.tsv", "ModifiedPeptide\tPrecursorMz\tPrecursorCharge\tFragmentMz\nPEPTIDE\t400.2\t2\t500.3\n", false},
+		{"lib.csv", "\"Q1\",\"Q3\",\"PeptideSequence\"\n400.2,500.3,PEPTIDE\n", false},
+		{"lib.tsv", "ModifiedPeptide\tPrecursorMz\n", true},
+		{"lib.parquet", "PAR1\x15\x04", false},
+		{"lib.parquet", "PK\x03\x04", true},
+		{"lib.blib", "SQLite format 3\x00\x10", false},
+		{"lib.msp", "\nName: PEPTIDE/2\nMW: 800.4\n", false},
+		{"lib.msp", "PEPTIDE\n", true},
+		{"lib.speclib", "\x01\x02", false},
+		{"lib.speclib", "", true},
+		{"lib.txt", "PrecursorMz\tFragmentMz\n", true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.name)
+		os.WriteFile(path, []byte(tt.content), 0o644)
+		if err := ValidateSpectralLibrary(path); (err != nil) != tt.wantErr {
+			t.Errorf("%s %q: ValidateSpectralLibrary() error = %v, wantErr %v", tt.name, tt.content, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveDependencyPath(t *testing.T) {
+	root := t.TempDir()
+	location := FolderWatchingLocation{FolderPath: root}
+	config := filepath.Join(root, "exp1", "run.cat.yml")
+	writeTestFile(t, filepath.Join(root, "exp1", "local.fasta"), 1)
+	writeTestFile(t, filepath.Join(root, "fasta", "shared.fasta"), 1)
+
+	tests := []struct {
+		reference string
+		want      string
+	}{
+		{"local.fasta", filepath.Join(root, "exp1", "local.fasta")},
+		{"fasta/shared.fasta", filepath.Join(root, "fasta", "shared.fasta")},
+		{"missing.fasta", filepath.Join(root, "exp1", "missing.fasta")},
+		{filepath.Join(root, "fasta", "shared.fasta"), filepath.Join(root, "fasta", "shared.fasta")},
+	}
+	for _, tt := range tests {
+		if got := ResolveDependencyPath(location, config, tt.reference); got != tt.want {
+			t.Errorf("ResolveDependencyPath(%s) = %s, want %s", tt.reference, got, tt.want)
+		}
+	}
+}
+
+func TestScanner_CheckRunConfigDependencies(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	root := t.TempDir()
+	location := FolderWatchingLocation{FolderPath: root, ExperimentStrategy: ExperimentStrategyParent, Id: 3}
+	scanner := NewScanner(db)
+	scanner.Stability.Default = StabilityRule{}
+
+	config := filepath.Join(root, "exp1", "run.cat.yml")
+	writeTestFile(t, config, 0)
+	os.WriteFile(config, []byte("cat_ready: true\nsearch_engine:\n  name: DIANN\nfasta: [fasta/human.fasta]\nspectral_library: lib.tsv\n"), 0o644)
+	os.MkdirAll(filepath.Join(root, "fasta"), 0o755)
+	os.WriteFile(filepath.Join(root, "fasta", "human.fasta"), []byte(">seq1\nPEPTIDE\n"), 0o644)
+
+	change, ok, err := scanner.ReviseRunConfig(location, config)
+	if err != nil || !ok {
+		t.Fatalf("ReviseRunConfig() = %v, %v", ok, err)
+	}
+	if got := change.Config; !got.FastaRequired || got.FastaReady || !got.SpectralLibraryRequired || got.SpectralLibraryReady || got.Runnable() {
+		t.Errorf("ReviseRunConfig() flags = %+v, want both required and unstable", got)
+	}
+
+	// the FASTA file is seen unchanged and becomes ready, the library is missing
+	changes, err := scanner.CheckRunConfigDependencies(location)
+	if err != nil || len(changes) != 1 || !changes[0].Config.FastaReady || changes[0].Config.SpectralLibraryReady {
+		t.Fatalf("CheckRunConfigDependencies() = %+v, %v", changes, err)
+	}
+	os.WriteFile(filepath.Join(root, "exp1", "lib.tsv"), []byte("PrecursorMz\tFragmentMz\n400.2\t500.3\n"), 0o644)
+	if changes, err := scanner.CheckRunConfigDependencies(location); err != nil || len(changes) != 0 {
+		t.Fatalf("CheckRunConfigDependencies() while the library is unstable = %+v, %v", changes, err)
+	}
+	changes, err = scanner.CheckRunConfigDependencies(location)
+	if err != nil || len(changes) != 1 || !changes[0].Config.Runnable() {
+		t.Fatalf("CheckRunConfigDependencies() = %+v, %v, want a runnable config", changes, err)
+	}
+	deps, err := GetRunConfigDependencies(db, config)
+	if err != nil || len(deps) != 2 || deps[0].Status != DependencyReady || deps[1].Path != filepath.Join(root, "exp1", "lib.tsv") {
+		t.Errorf("GetRunConfigDependencies() = %+v, %v", deps, err)
+	}
+	if changes, err := scanner.CheckRunConfigDependencies(location); err != nil || len(changes) != 0 {
+		t.Errorf("CheckRunConfigDependencies() with nothing pending = %+v, %v", changes, err)
+	}
+
+	// the ready flags reach the backend
+	if err := EnqueueRunConfigChange(db, changes[0]); err != nil {
+		t.Fatal(err)
+	}
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), ""))
+	if _, err := replayer.Replay(context.Background()); err != nil {
+		t.Fatalf("Replay() error: %v", err)
+	}
+	configs := server.Records(catapulttest.RunConfigs)
+	if len(configs) != 1 || fmt.Sprint(configs[0]["fasta_ready"], configs[0]["spectral_library_ready"]) != "true true" {
+		t.Errorf("backend configs = %v", configs)
+	}
+
+	// a ready file that disappears is noticed, by its own location only
+	os.Remove(filepath.Join(root, "fasta", "human.fasta"))
+	sibling := FolderWatchingLocation{FolderPath: filepath.Join(root, "exp"), Id: 4}
+	if changes, err := scanner.CheckRunConfigDependencies(sibling); err != nil || len(changes) != 0 {
+		t.Errorf("CheckRunConfigDependencies() of a sibling location = %+v, %v", changes, err)
+	}
+	changes, err = scanner.CheckRunConfigDependencies(location)
+	if err != nil || len(changes) != 1 || changes[0].Config.FastaReady || !changes[0].Config.SpectralLibraryReady {
+		t.Errorf("CheckRunConfigDependencies() after removing the FASTA file = %+v, %v", changes, err)
+	}
+
+	// an invalid library is not ready
+	os.WriteFile(filepath.Join(root, "exp1", "lib.tsv"), []byte("Peptide\n"), 0o644)
+	os.WriteFile(config, []byte("cat_ready: true\nsearch_engine:\n  name: DIANN\nspectral_library: lib.tsv\n"), 0o644)
+	change, ok, err = scanner.ReviseRunConfig(location, config)
+	if err != nil || !ok || change.Config.FastaRequired || change.Config.SpectralLibraryReady {
+		t.Errorf("ReviseRunConfig() with a changed library = %+v, %v, %v", change.Config, ok, err)
+	}
+}
@@ -0,0 +1,204 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// racyWindow is how recent a directory mtime may be before it is not trusted:
+// an entry added within the same clock tick as the listing would otherwise
+// leave the mtime unchanged and never be seen.
+const racyWindow = 2 * time.Second
+
+// ListDirectoriesUnder returns the stored modification time, in nanoseconds,
+// of root and every directory below it.
+func ListDirectoriesUnder(db *sql.DB, root string) (map[string]int64, error) {
+	under, args := underRoot("path", root)
+	rows, err := db.Query("SELECT path, modified FROM directories WHERE "+under, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dirs := make(map[string]int64)
+	for rows.Next() {
+		var path string
+		var modified int64
+		if err := rows.Scan(&path, &modified); err != nil {
+			return nil, err
+		}
+		dirs[path] = modified
+	}
+	return dirs, rows.Err()
+}
+
+// SaveDirectories stores the modification times of the directories walked
+// under root. With prune set, directories under root that were not walked are
+// forgotten.
+func SaveDirectories(db *sql.DB, root string, dirs map[string]int64, prune bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if prune {
+		under, args := underRoot("path", root)
+		_, err = tx.Exec("DELETE FROM directories WHERE "+under, args...)
+	}
+	if err == nil {
+		var stmt *sql.Stmt
+		stmt, err = tx.Prepare("INSERT OR REPLACE INTO directories (path, modified) VALUES (?, ?)")
+		if err == nil {
+			for path, modified := range dirs {
+				if _, err = stmt.Exec(path, modified); err != nil {
+					break
+				}
+			}
+			stmt.Close()
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// knownFile stands for a file found in a directory that has not changed since
+// the previous scan. It carries the stored state and is not compared again.
+type knownFile struct {
+	file LocalFile
+}
+
+func (k knownFile) Name() string       { return filepath.Base(k.file.Path) }
+func (k knownFile) Size() int64        { return k.file.Size }
+func (k knownFile) Mode() fs.FileMode  { return 0 }
+func (k knownFile) ModTime() time.Time { return time.Unix(k.file.LastModified, 0) }
+func (k knownFile) IsDir() bool        { return k.file.IsFolder }
+func (k knownFile) Sys() interface{}   { return nil }
+
+// walkState is the state of one walk of a location.
+type walkState struct {
+	location    FolderWatchingLocation
+	vendors     *VendorRegistry
+	incremental bool
+	now         time.Time
+	// storedDirs and the known maps describe the previous scan.
+	storedDirs map[string]int64
+	knownFiles map[string][]LocalFile
+	knownDirs  map[string][]string
+	unstable   map[string]bool
+
+	files      map[string]os.FileInfo
+	dirs       map[string]int64
+	unreadable []string
+}
+
+func newWalkState(location FolderWatchingLocation, vendors *VendorRegistry, incremental bool, now time.Time, known []LocalFile, storedDirs map[string]int64, unstable []string) *walkState {
+	st := &walkState{
+		location:    location,
+		vendors:     vendors,
+		incremental: incremental,
+		now:         now,
+		storedDirs:  storedDirs,
+		knownFiles:  make(map[string][]LocalFile),
+		knownDirs:   make(map[string][]string),
+		unstable:    make(map[string]bool, len(unstable)),
+		files:       make(map[string]os.FileInfo),
+		dirs:        make(map[string]int64),
+	}
+	for _, file := range known {
+		parent := filepath.Dir(file.Path)
+		st.knownFiles[parent] = append(st.knownFiles[parent], file)
+	}
+	for dir := range storedDirs {
+		if dir != location.FolderPath {
+			parent := filepath.Dir(dir)
+			st.knownDirs[parent] = append(st.knownDirs[parent], dir)
+		}
+	}
+	for _, path := range unstable {
+		st.unstable[path] = true
+	}
+	return st
+}
+
+// list returns the entries of dir. A directory whose mtime is the one stored
+// by the previous scan has the same entries, so instead of being listed it
+// yields its known files and subdirectories. Files still being tracked for
+// stability, run configs and files not hashed yet are looked at again, since
+// a rewrite in place does not touch the directory; only hashed acquisitions
+// rewritten in place are left to a full scan. list only reads the walk state
+// and is safe for concurrent use.
+func (st *walkState) list(dir string, info os.FileInfo) ([]WalkEntry, error) {
+	stored, ok := st.storedDirs[dir]
+	if !st.incremental || !ok || stored != info.ModTime().UnixNano() {
+		return ReadDir(dir, info)
+	}
+
+	var entries []WalkEntry
+	for _, file := range st.knownFiles[dir] {
+		if !st.restat(file) {
+			entries = append(entries, WalkEntry{Path: file.Path, Info: knownFile{file}})
+			continue
+		}
+		for _, path := range append([]string{file.Path}, st.vendors.CompanionPaths(file.Path)...) {
+			if info, err := os.Stat(path); err == nil {
+				entries = append(entries, WalkEntry{Path: path, Info: info})
+			}
+		}
+	}
+	for _, sub := range st.knownDirs[dir] {
+		info, err := os.Stat(sub)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		entries = append(entries, WalkEntry{Path: sub, Info: info, Err: err})
+	}
+	return entries, nil
+}
+
+// restat reports whether a known file in an unchanged directory is stat'd
+// again rather than taken from the previous scan.
+func (st *walkState) restat(file LocalFile) bool {
+	return st.unstable[file.Path] || file.Checksum == "" || IsRunConfig(filepath.Base(file.Path))
+}
+
+// walkLocation walks the location with the location walker and records the
+// tracked files, the directories walked and the unreadable entries.
+func (s *Scanner) walkLocation(ctx context.Context, st *walkState) error {
+	ignores := s.ignoreMatcher(st.location)
+	ignores.Reset()
+	s.experimentResolver(st.location).Reset()
+	descend := func(entry WalkEntry) bool {
+		// a folder acquisition is a single file, its content is not tracked
+		_, ok := s.Vendors.Classify(entry.Path, true)
+		return !ok && !ignores.match(entry.Path, true)
+	}
+	entries, err := s.walker(st.location).Walk(ctx, st.location.FolderPath, st.list, descend)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Err != nil {
+			// everything below an unreadable directory would otherwise look deleted
+			st.unreadable = append(st.unreadable, entry.Path)
+			continue
+		}
+		if _, ok := entry.Info.(knownFile); !ok && entry.Info.IsDir() && (entry.Path == st.location.FolderPath || descend(entry)) {
+			modified := entry.Info.ModTime().UnixNano()
+			if st.now.Sub(entry.Info.ModTime()) < racyWindow {
+				modified = 0
+			}
+			st.dirs[entry.Path] = modified
+		}
+		if entry.Path != st.location.FolderPath && s.tracked(st.location, entry.Path, entry.Info) {
+			st.files[entry.Path] = entry.Info
+		}
+	}
+	return nil
+}
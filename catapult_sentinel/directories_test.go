@@ -0,0 +1,147 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ageTree moves the mtime of every directory under root an hour back, so the
+// scanner trusts them.
+func ageTree(t *testing.T, root string) {
+	t.Helper()
+	past := time.Now().Add(-time.Hour)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, past, past)
+	})
+	if err != nil {
+		t.Fatalf("ageTree() error: %v", err)
+	}
+}
+
+func TestScanner_SkipsUnchangedDirectories(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	rewritten := filepath.Join(root, "exp1", "sample_01.raw")
+	writeTestFile(t, rewritten, 10)
+	writeTestFile(t, filepath.Join(root, "exp2", "nested", "sample_02.raw"), 10)
+	ageTree(t, root)
+
+	scanner := NewScanner(db)
+	scanner.Stability = nil
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if task, err := scanner.Scan(context.Background(), location); err != nil || len(task.NewFile) != 2 {
+		t.Fatalf("Scan() = %+v, %v, want 2 new files", task, err)
+	}
+	dirs, err := ListDirectoriesUnder(db, root)
+	if err != nil || len(dirs) != 4 {
+		t.Fatalf("ListDirectoriesUnder() = %v, %v, want 4 directories", dirs, err)
+	}
+
+	// an in place rewrite does not touch the directory, only a full scan sees
+	// it once the file is hashed
+	SetChecksum(db, rewritten, "sha256:00")
+	writeTestFile(t, rewritten, 20)
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil || len(task.ChangedFile) != 0 || len(task.DeletedFile) != 0 || task.Health.Degraded {
+		t.Fatalf("Scan() after rewrite = %+v, %v, want nothing", task, err)
+	}
+	task, err = scanner.FullScan(context.Background(), location)
+	if err != nil || len(task.ChangedFile) != 1 || task.ChangedFile[0].Size != 20 {
+		t.Fatalf("FullScan() = %+v, %v, want %s changed", task, err, rewritten)
+	}
+
+	added := filepath.Join(root, "exp2", "nested", "sample_03.raw")
+	writeTestFile(t, added, 5)
+	task, err = scanner.Scan(context.Background(), location)
+	if err != nil || len(task.NewFile) != 1 || task.NewFile[0].FilePath != added {
+		t.Fatalf("Scan() after add = %+v, %v, want %s", task, err, added)
+	}
+	dirs, _ = ListDirectoriesUnder(db, root)
+	if dirs[filepath.Dir(added)] != 0 {
+		t.Errorf("directory modified just now stored as %d, want 0", dirs[filepath.Dir(added)])
+	}
+}
+
+func TestScanner_UnchangedDirectoryRewrite(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	unhashed := filepath.Join(root, "exp1", "sample_01.raw")
+	config := filepath.Join(root, "exp1", "run.cat.yml")
+	writeTestFile(t, unhashed, 10)
+	writeTestFile(t, config, 10)
+	ageTree(t, root)
+
+	scanner := NewScanner(db)
+	scanner.Stability = nil
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	SetChecksum(db, config, "sha256:00")
+	info, _ := os.Stat(filepath.Dir(config))
+	writeTestFile(t, unhashed, 20)
+	writeTestFile(t, config, 20)
+	if after, _ := os.Stat(filepath.Dir(config)); !after.ModTime().Equal(info.ModTime()) {
+		t.Fatal("rewriting files in place touched their directory")
+	}
+
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil || len(task.ChangedFile) != 2 {
+		t.Fatalf("Scan() after rewrites = %+v, %v, want %s and %s changed", task, err, config, unhashed)
+	}
+}
+
+func TestScanner_UnchangedDirectoryUnstableFile(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	growing := filepath.Join(root, "exp1", "sample_01.raw")
+	writeTestFile(t, growing, 10)
+	ageTree(t, root)
+
+	scanner := NewScanner(db)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	writeTestFile(t, growing, 30)
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil || len(task.ChangedFile) != 1 || task.ChangedFile[0].Size != 30 {
+		t.Fatalf("Scan() = %+v, %v, want %s grown to 30", task, err, growing)
+	}
+}
+
+func TestGetFiles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var paths []string
+	for i := 0; i < 2*lookupBatch+10; i++ {
+		path := fmt.Sprintf("/data/exp/sample_%04d.raw", i)
+		paths = append(paths, path)
+		if i%2 == 0 {
+			if err := InsertFile(db, LocalFile{Path: path, Size: int64(i)}); err != nil {
+				t.Fatalf("InsertFile() error: %v", err)
+			}
+		}
+	}
+	files, err := GetFiles(db, paths)
+	if err != nil {
+		t.Fatalf("GetFiles() error: %v", err)
+	}
+	if len(files) != lookupBatch+5 {
+		t.Errorf("GetFiles() returned %d files, want %d", len(files), lookupBatch+5)
+	}
+	if file := files[paths[1000]]; file.Size != 1000 {
+		t.Errorf("GetFiles()[%s] = %+v, want size 1000", paths[1000], file)
+	}
+}
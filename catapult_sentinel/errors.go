@@ -0,0 +1,168 @@
+package catapult_sentinel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrNotFound     = errors.New("catapult: not found")
+	ErrUnauthorized = errors.New("catapult: unauthorized")
+	ErrForbidden    = errors.New("catapult: forbidden")
+	ErrValidation   = errors.New("catapult: validation failed")
+	ErrServer       = errors.New("catapult: server error")
+)
+
+// APIError is returned by every CatapultBackend method when the backend answers
+// with a status code outside of the expected 2xx range.
+//
+// Detail and Fields hold the decoded Django REST Framework error body. DRF either
+// returns {"detail": "..."} for generic errors or a map of field name to list of
+// messages for validation errors, with "non_field_errors" for errors that are not
+// bound to a single field.
+type APIError struct {
+	Endpoint   string              `json:"endpoint"`
+	Method     string              `json:"method"`
+	StatusCode int                 `json:"status_code"`
+	Detail     string              `json:"detail"`
+	Fields     map[string][]string `json:"fields"`
+	RequestId  string              `json:"request_id"`
+	Body       []byte              `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "catapult: %s %s: %d %s", e.Method, e.Endpoint, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Detail != "" {
+		fmt.Fprintf(&b, ": %s", e.Detail)
+	}
+	if len(e.Fields) > 0 {
+		keys := make([]string, 0, len(e.Fields))
+		for k := range e.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "; %s: %s", k, strings.Join(e.Fields[k], " "))
+		}
+	}
+	if e.RequestId != "" {
+		fmt.Fprintf(&b, " (request id %s)", e.RequestId)
+	}
+	return b.String()
+}
+
+// Is lets callers match an APIError against the package level sentinel errors,
+// e.g. errors.Is(err, ErrNotFound).
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrValidation:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// IsNotFound reports whether err is an APIError for a 404 response.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsUnauthorized reports whether err is an APIError for a 401 or 403 response.
+func IsUnauthorized(err error) bool {
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden)
+}
+
+// IsValidation reports whether err is an APIError for a 400 response.
+func IsValidation(err error) bool {
+	return errors.Is(err, ErrValidation)
+}
+
+// newAPIError builds an APIError from a non 2xx response. The body is read and
+// decoded on a best effort basis, an unreadable or non JSON body is kept as is.
+func newAPIError(req *http.Request, resp *http.Response) *APIError {
+	apiErr := &APIError{
+		Method:     req.Method,
+		Endpoint:   req.URL.Path,
+		StatusCode: resp.StatusCode,
+		RequestId:  resp.Header.Get("X-Request-ID"),
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return apiErr
+	}
+	apiErr.Body = body
+	apiErr.Detail, apiErr.Fields = decodeErrorBody(body)
+	return apiErr
+}
+
+func decodeErrorBody(body []byte) (string, map[string][]string) {
+	var raw interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return strings.TrimSpace(string(body)), nil
+	}
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		detail := ""
+		fields := make(map[string][]string)
+		for key, value := range v {
+			if key == "detail" {
+				detail = strings.Join(flattenErrorValue(value), " ")
+				continue
+			}
+			fields[key] = flattenErrorValue(value)
+		}
+		if len(fields) == 0 {
+			fields = nil
+		}
+		return detail, fields
+	case []interface{}:
+		return strings.Join(flattenErrorValue(v), " "), nil
+	case string:
+		return v, nil
+	}
+	return "", nil
+}
+
+// flattenErrorValue turns the nested list/object structure DRF uses for
+// serializer errors into a flat list of messages.
+func flattenErrorValue(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var messages []string
+		for _, item := range v {
+			messages = append(messages, flattenErrorValue(item)...)
+		}
+		return messages
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var messages []string
+		for _, k := range keys {
+			for _, m := range flattenErrorValue(v[k]) {
+				messages = append(messages, k+": "+m)
+			}
+		}
+		return messages
+	case nil:
+		return nil
+	}
+	return []string{fmt.Sprint(value)}
+}
@@ -0,0 +1,97 @@
+package catapult_sentinel
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCatapultBackend_APIError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantDetail string
+		wantFields map[string][]string
+		wantIs     error
+	}{
+		{
+			name:       "Not found",
+			status:     http.StatusNotFound,
+			body:       `{"detail": "Not found."}`,
+			wantDetail: "Not found.",
+			wantIs:     ErrNotFound,
+		},
+		{
+			name:       "Unauthorized",
+			status:     http.StatusUnauthorized,
+			body:       `{"detail": "Invalid token."}`,
+			wantDetail: "Invalid token.",
+			wantIs:     ErrUnauthorized,
+		},
+		{
+			name:   "Validation",
+			status: http.StatusBadRequest,
+			body:   `{"file_path": ["This field is required."], "non_field_errors": ["Duplicate file."]}`,
+			wantFields: map[string][]string{
+				"file_path":        {"This field is required."},
+				"non_field_errors": {"Duplicate file."},
+			},
+			wantIs: ErrValidation,
+		},
+		{
+			name:       "Server error with html body",
+			status:     http.StatusInternalServerError,
+			body:       "<h1>Server Error (500)</h1>",
+			wantDetail: "<h1>Server Error (500)</h1>",
+			wantIs:     ErrServer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-ID", "abc123")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			c := NewCatapultBackend(server.URL+"/", "token")
+			c.Retry = nil
+			_, err := c.GetFileById(5)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("GetFileById() error = %v, want *APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Method != "GET" || apiErr.Endpoint != "/api/files/5/" || apiErr.RequestId != "abc123" {
+				t.Errorf("GetFileById() error = %+v", apiErr)
+			}
+			if apiErr.Detail != tt.wantDetail {
+				t.Errorf("Detail = %q, want %q", apiErr.Detail, tt.wantDetail)
+			}
+			for field, messages := range tt.wantFields {
+				if len(apiErr.Fields[field]) != len(messages) || apiErr.Fields[field][0] != messages[0] {
+					t.Errorf("Fields[%s] = %v, want %v", field, apiErr.Fields[field], messages)
+				}
+			}
+			if !errors.Is(err, tt.wantIs) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.wantIs)
+			}
+		})
+	}
+}
+
+func TestCatapultBackend_GetFilesAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"detail": "You do not have permission to perform this action."}`))
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	_, err := c.GetFiles([]string{"a.raw"})
+	if !IsUnauthorized(err) {
+		t.Fatalf("GetFiles() error = %v, want unauthorized", err)
+	}
+}
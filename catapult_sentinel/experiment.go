@@ -0,0 +1,400 @@
+package catapult_sentinel
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Experiment strategies, set in FolderWatchingLocation.ExperimentStrategy.
+// Every strategy but the legacy one names experiments by a slash separated path
+// relative to the location, so the same experiment has the same name whatever
+// the host mounting the location. The backend knows them prefixed with the id
+// of the location, so that locations using the same names do not share
+// experiments.
+const (
+	// ExperimentStrategyLegacy names an experiment by the absolute path of the
+	// parent directory of its files, as sentinels always did.
+	ExperimentStrategyLegacy = ""
+	// ExperimentStrategyParent names an experiment by the parent directory of
+	// its files.
+	ExperimentStrategyParent = "parent"
+	// ExperimentStrategyDepth names an experiment by the directories at
+	// ExperimentDepth levels below the location root, so deeper directories
+	// belong to the experiment of their ancestor.
+	ExperimentStrategyDepth = "depth"
+	// ExperimentStrategyRunConfig names an experiment by the nearest directory
+	// holding a .cat.yml file.
+	ExperimentStrategyRunConfig = "run_config"
+	// ExperimentStrategyRegex matches the regular expression in
+	// ExperimentPattern against the relative path of each file. The name is
+	// the group named experiment, or the other named groups joined by
+	// slashes, or the whole match when the expression has no named group.
+	ExperimentStrategyRegex = "regex"
+	// ExperimentStrategyMapping reads the mapping file named by
+	// ExperimentPattern, relative to the location root unless absolute. Each
+	// line maps a pattern in the syntax of .sentinelignore files to an
+	// experiment name, as in "2024-*/QC = QC runs". A pattern matches a file
+	// or one of its parent directories and the first matching line wins.
+	ExperimentStrategyMapping = "mapping"
+	// ExperimentStrategyMetadata names an experiment by expanding
+	// ExperimentPattern, such as "{sample}_{date}", with the fields parsed
+	// from the file name by the filename template of the location.
+	ExperimentStrategyMetadata = "metadata"
+)
+
+type experimentMapping struct {
+	re   *regexp.Regexp
+	name string
+}
+
+// ExperimentResolver names the experiment of the files of a location. Files
+// the regex, mapping and metadata strategies do not match, and every file of a strategy
+// that could not be set up, fall back to the parent strategy. Files directly in
+// the location root belong to no experiment, except with the legacy strategy.
+type ExperimentResolver struct {
+	location FolderWatchingLocation
+	strategy string
+	regex    *regexp.Regexp
+	mappings []experimentMapping
+	template *FilenameTemplate
+
+	mu      sync.Mutex
+	configs map[string]bool
+}
+
+// NewExperimentResolver returns the resolver of location. An error reports a
+// strategy that could not be set up; the resolver then uses the parent
+// strategy.
+func NewExperimentResolver(location FolderWatchingLocation) (*ExperimentResolver, error) {
+	r := &ExperimentResolver{location: location, strategy: location.ExperimentStrategy, configs: make(map[string]bool)}
+	var err error
+	switch location.ExperimentStrategy {
+	case ExperimentStrategyLegacy, ExperimentStrategyParent, ExperimentStrategyRunConfig:
+	case ExperimentStrategyDepth:
+		if location.ExperimentDepth < 1 {
+			err = fmt.Errorf("experiment depth %d, want at least 1", location.ExperimentDepth)
+		}
+	case ExperimentStrategyRegex:
+		r.regex, err = regexp.Compile(location.ExperimentPattern)
+	case ExperimentStrategyMapping:
+		r.mappings, err = r.readMappings()
+	case ExperimentStrategyMetadata:
+		if location.FilenameTemplate == "" {
+			err = fmt.Errorf("experiment strategy %s without a filename template", location.ExperimentStrategy)
+		} else {
+			r.template, err = ParseFilenameTemplate(location.FilenameTemplate)
+		}
+	default:
+		err = fmt.Errorf("unknown experiment strategy %q", location.ExperimentStrategy)
+	}
+	if err != nil {
+		r.strategy = ExperimentStrategyParent
+	}
+	return r, err
+}
+
+// readMappings parses the mapping file of the location.
+func (r *ExperimentResolver) readMappings() ([]experimentMapping, error) {
+	name := r.location.ExperimentPattern
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(r.location.FolderPath, name)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mappings []experimentMapping
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, experiment, ok := strings.Cut(line, "=")
+		pattern, experiment = strings.TrimSpace(pattern), strings.TrimSpace(experiment)
+		if !ok || pattern == "" || experiment == "" {
+			return nil, fmt.Errorf("%s:%d: want pattern = experiment", name, n)
+		}
+		re, err := regexp.Compile("^" + globToRegexp(strings.Trim(pattern, "/")) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		mappings = append(mappings, experimentMapping{re: re, name: experiment})
+	}
+	return mappings, scanner.Err()
+}
+
+// Reset forgets which directories hold a .cat.yml file.
+func (r *ExperimentResolver) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configs = make(map[string]bool)
+}
+
+// Resolve returns the name of the experiment of the file or directory
+// acquisition at path, empty when it belongs to none.
+func (r *ExperimentResolver) Resolve(path string) string {
+	if r.strategy == ExperimentStrategyLegacy {
+		return filepath.Dir(path)
+	}
+	rel, err := filepath.Rel(r.location.FolderPath, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	parent := relDir(rel)
+
+	switch r.strategy {
+	case ExperimentStrategyDepth:
+		parts := strings.Split(parent, "/")
+		if len(parts) > r.location.ExperimentDepth {
+			parts = parts[:r.location.ExperimentDepth]
+		}
+		return strings.Join(parts, "/")
+	case ExperimentStrategyRunConfig:
+		for dir := parent; dir != ""; dir = relDir(dir) {
+			if r.hasRunConfig(filepath.Join(r.location.FolderPath, filepath.FromSlash(dir))) {
+				return dir
+			}
+		}
+	case ExperimentStrategyRegex:
+		if name := r.matchRegex(rel); name != "" {
+			return name
+		}
+	case ExperimentStrategyMapping:
+		for dir := rel; dir != ""; dir = relDir(dir) {
+			for _, mapping := range r.mappings {
+				if mapping.re.MatchString(dir) {
+					return mapping.name
+				}
+			}
+		}
+	case ExperimentStrategyMetadata:
+		if fields, err := r.template.Parse(filepath.Base(path)); err == nil {
+			if name, err := ExpandMetadata(r.location.ExperimentPattern, fields); err == nil && name != "" {
+				return name
+			}
+		}
+	}
+	return parent
+}
+
+// Name returns the backend name of the experiment of path: the name given by
+// Resolve, prefixed with the location id unless the strategy is the legacy
+// one, whose names are absolute.
+func (r *ExperimentResolver) Name(path string) string {
+	name := r.Resolve(path)
+	if name == "" || r.strategy == ExperimentStrategyLegacy {
+		return name
+	}
+	return strconv.Itoa(r.location.Id) + "/" + name
+}
+
+// relDir returns the directory of a slash separated relative path, empty for
+// the root.
+func relDir(rel string) string {
+	if i := strings.LastIndexByte(rel, '/'); i >= 0 {
+		return rel[:i]
+	}
+	return ""
+}
+
+func (r *ExperimentResolver) matchRegex(rel string) string {
+	match := r.regex.FindStringSubmatch(rel)
+	if match == nil {
+		return ""
+	}
+	if i := r.regex.SubexpIndex("experiment"); i > 0 {
+		return match[i]
+	}
+	named := false
+	var parts []string
+	for i, group := range r.regex.SubexpNames() {
+		if group == "" {
+			continue
+		}
+		named = true
+		if match[i] != "" {
+			parts = append(parts, match[i])
+		}
+	}
+	if !named {
+		return match[0]
+	}
+	return strings.Join(parts, "/")
+}
+
+// hasRunConfig reports whether dir holds a run configuration file.
+func (r *ExperimentResolver) hasRunConfig(dir string) bool {
+	r.mu.Lock()
+	found, ok := r.configs[dir]
+	r.mu.Unlock()
+	if ok {
+		return found
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && IsRunConfig(entry.Name()) {
+			found = true
+			break
+		}
+	}
+	r.mu.Lock()
+	r.configs[dir] = found
+	r.mu.Unlock()
+	return found
+}
+
+// experimentResolver returns the resolver of location, built again when the
+// location changed.
+func (s *Scanner) experimentResolver(location FolderWatchingLocation) *ExperimentResolver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.experiments == nil {
+		s.experiments = make(map[int]*ExperimentResolver)
+	}
+	resolver, ok := s.experiments[location.Id]
+	if !ok || resolver.location != location {
+		var err error
+		resolver, err = NewExperimentResolver(location)
+		if err != nil {
+			log.Printf("location %s: %v", location.FolderPath, err)
+		}
+		s.experiments[location.Id] = resolver
+	}
+	return resolver
+}
+
+// ExperimentLink is a backend file whose experiment is changed by
+// RelinkExperiments.
+type ExperimentLink struct {
+	FilePath       string
+	FileId         int
+	ExperimentName string
+	// From and To are experiment ids. To is 0 when a dry run found no
+	// experiment by that name yet.
+	From int
+	To   int
+}
+
+// relinkBatchSize is the number of files fetched and updated per request by
+// RelinkExperiments.
+const relinkBatchSize = 100
+
+// RelinkExperiments points the backend files of location at the experiments
+// named by its current strategy, creating missing experiments. It is the
+// migration to run after changing the strategy of a location. Only files sent
+// to the backend are considered. A dry run changes nothing and returns the
+// links it would make.
+func RelinkExperiments(ctx context.Context, db *sql.DB, backend *CatapultBackend, location FolderWatchingLocation, dryRun bool) ([]ExperimentLink, error) {
+	resolver, err := NewExperimentResolver(location)
+	if err != nil {
+		return nil, err
+	}
+	local, err := ListFilesUnder(db, location.FolderPath)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	var sent []LocalFile
+	for _, file := range local {
+		if file.RemoteId == 0 {
+			continue
+		}
+		names[file.Path] = resolver.Name(file.Path)
+		sent = append(sent, file)
+	}
+
+	experiments := make(map[string]int)
+	for _, name := range names {
+		if _, ok := experiments[name]; ok || name == "" {
+			continue
+		}
+		experiments[name] = 0
+		if dryRun {
+			found, err := backend.ListExperiments(ExperimentFilter{ExperimentName: name}).Collect(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, experiment := range found {
+				if experiment.ExperimentName == name {
+					experiments[name] = experiment.Id
+				}
+			}
+			continue
+		}
+		experiment, err := backend.GetExperimentByNameContext(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		experiments[name] = experiment.Id
+	}
+
+	var links []ExperimentLink
+	for start := 0; start < len(sent); start += relinkBatchSize {
+		end := min(start+relinkBatchSize, len(sent))
+		remote, err := relinkBatch(ctx, backend, sent[start:end], dryRun)
+		if err != nil {
+			return links, err
+		}
+		var updates []File
+		for _, file := range remote {
+			name, ok := names[file.FilePath]
+			if !ok || name == "" || file.Experiment == experiments[name] && file.Experiment != 0 {
+				continue
+			}
+			links = append(links, ExperimentLink{
+				FilePath:       file.FilePath,
+				FileId:         file.Id,
+				ExperimentName: name,
+				From:           file.Experiment,
+				To:             experiments[name],
+			})
+			file.Experiment = experiments[name]
+			updates = append(updates, file)
+		}
+		if dryRun || len(updates) == 0 {
+			continue
+		}
+		if _, err := backend.UpdateFilesContext(ctx, updates); err != nil {
+			return links, err
+		}
+	}
+	return links, nil
+}
+
+// relinkBatch fetches the backend records of files. A dry run looks each file
+// up by remote id, since the batch endpoint creates the records it does not
+// find.
+func relinkBatch(ctx context.Context, backend *CatapultBackend, files []LocalFile, dryRun bool) ([]File, error) {
+	if !dryRun {
+		paths := make([]string, len(files))
+		for i, file := range files {
+			paths[i] = file.Path
+		}
+		return backend.GetFilesContext(ctx, paths)
+	}
+	var remote []File
+	for _, file := range files {
+		found, err := backend.GetFileByIdContext(ctx, int(file.RemoteId))
+		if err != nil {
+			return remote, err
+		}
+		remote = append(remote, found)
+	}
+	return remote, nil
+}
@@ -0,0 +1,163 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func TestExperimentResolver(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "2024", "projectA", "run.cat.yml"), 1)
+	os.WriteFile(filepath.Join(root, "experiments.txt"), []byte("# QC runs\n*/QC = QC\nprojectB/** = Project B\n"), 0o644)
+
+	tests := []struct {
+		location FolderWatchingLocation
+		path     string
+		want     string
+	}{
+		{FolderWatchingLocation{}, "2024/projectA/a.raw", filepath.Join(root, "2024", "projectA")},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyParent}, "2024/projectA/a.raw", "2024/projectA"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyParent}, "a.raw", ""},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyParent}, "..exp1/a.raw", "..exp1"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyDepth, ExperimentDepth: 1}, "2024/projectA/day2/a.raw", "2024"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyDepth, ExperimentDepth: 2}, "2024/projectA/day2/a.raw", "2024/projectA"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyDepth, ExperimentDepth: 2}, "2024/a.raw", "2024"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyRunConfig}, "2024/projectA/day2/a.raw", "2024/projectA"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyRunConfig}, "2024/projectC/a.raw", "2024/projectC"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyRegex, ExperimentPattern: `^(?P<year>\d+)/(?P<project>[^/]+)/`}, "2024/projectA/day2/a.raw", "2024/projectA"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyRegex, ExperimentPattern: `(?P<experiment>project\w)`}, "2024/projectA/a.raw", "projectA"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyRegex, ExperimentPattern: `^\d+`}, "2024/projectA/a.raw", "2024"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyRegex, ExperimentPattern: `^none/`}, "2024/projectA/a.raw", "2024/projectA"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyMapping, ExperimentPattern: "experiments.txt"}, "2024/QC/a.raw", "QC"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyMapping, ExperimentPattern: "experiments.txt"}, "projectB/x/a.raw", "Project B"},
+		{FolderWatchingLocation{ExperimentStrategy: ExperimentStrategyMapping, ExperimentPattern: "experiments.txt"}, "2024/projectA/a.raw", "2024/projectA"},
+	}
+	for _, tt := range tests {
+		tt.location.FolderPath = root
+		resolver, err := NewExperimentResolver(tt.location)
+		if err != nil {
+			t.Errorf("NewExperimentResolver(%+v) error: %v", tt.location, err)
+			continue
+		}
+		if got := resolver.Resolve(filepath.Join(root, filepath.FromSlash(tt.path))); got != tt.want {
+			t.Errorf("%s Resolve(%s) = %q, want %q", tt.location.ExperimentStrategy, tt.path, got, tt.want)
+		}
+	}
+
+	for _, location := range []FolderWatchingLocation{
+		{FolderPath: root, ExperimentStrategy: "nearest"},
+		{FolderPath: root, ExperimentStrategy: ExperimentStrategyDepth},
+		{FolderPath: root, ExperimentStrategy: ExperimentStrategyRegex, ExperimentPattern: "("},
+		{FolderPath: root, ExperimentStrategy: ExperimentStrategyMapping, ExperimentPattern: "missing.txt"},
+	} {
+		resolver, err := NewExperimentResolver(location)
+		if err == nil {
+			t.Errorf("NewExperimentResolver(%+v) succeeded", location)
+		}
+		if got := resolver.Resolve(filepath.Join(root, "exp1", "a.raw")); got != "exp1" {
+			t.Errorf("fallback Resolve() = %q, want exp1", got)
+		}
+	}
+}
+
+func TestExperimentResolver_Name(t *testing.T) {
+	root := t.TempDir()
+	names := make(map[string]bool)
+	for _, id := range []int{1, 2} {
+		location := FolderWatchingLocation{FolderPath: filepath.Join(root, fmt.Sprint(id)), ExperimentStrategy: ExperimentStrategyParent, Id: id}
+		resolver, _ := NewExperimentResolver(location)
+		names[resolver.Name(filepath.Join(location.FolderPath, "exp1", "a.raw"))] = true
+		if got := resolver.Name(filepath.Join(location.FolderPath, "a.raw")); got != "" {
+			t.Errorf("Name() of a root file = %q, want none", got)
+		}
+	}
+	if !names["1/exp1"] || !names["2/exp1"] {
+		t.Errorf("Name() = %v, want one experiment per location", names)
+	}
+
+	legacy, _ := NewExperimentResolver(FolderWatchingLocation{FolderPath: root, Id: 1})
+	if got := legacy.Name(filepath.Join(root, "exp1", "a.raw")); got != filepath.Join(root, "exp1") {
+		t.Errorf("legacy Name() = %q, want the absolute parent", got)
+	}
+}
+
+func TestOutboxReplayer_NewFileExperiment(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	root := t.TempDir()
+	path := filepath.Join(root, "exp1", "a.raw")
+	writeTestFile(t, path, 10)
+
+	scanner := NewScanner(db)
+	scanner.Outbox = true
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", ExperimentStrategy: ExperimentStrategyParent, Id: 3}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), ""))
+	if _, err := replayer.Replay(context.Background()); err != nil {
+		t.Fatalf("Replay() error: %v", err)
+	}
+	experiments := server.Records(catapulttest.Experiments)
+	files := server.Records(catapulttest.Files)
+	if len(experiments) != 1 || experiments[0]["experiment_name"] != "3/exp1" {
+		t.Fatalf("backend experiments = %v, want 3/exp1", experiments)
+	}
+	if len(files) != 1 || fmt.Sprint(files[0]["experiment"]) != fmt.Sprint(experiments[0]["id"]) {
+		t.Errorf("backend files = %v, want the file in experiment %v", files, experiments[0]["id"])
+	}
+}
+
+func TestRelinkExperiments(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	root := t.TempDir()
+	old := server.Add(catapulttest.Experiments, catapulttest.Record{"experiment_name": filepath.Join(root, "projectA", "day1")})
+	var ids []int
+	for _, name := range []string{"projectA/day1/a.raw", "projectA/day2/b.raw"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		id := server.Add(catapulttest.Files, catapulttest.Record{"file_path": path, "experiment": old})
+		InsertFile(db, LocalFile{Path: path, Size: 10})
+		SetRemoteId(db, path, int64(id))
+		ids = append(ids, id)
+	}
+	InsertFile(db, LocalFile{Path: filepath.Join(root, "projectA", "c.raw")})
+
+	c := NewCatapultBackend(server.BaseUrl(), "")
+	location := FolderWatchingLocation{FolderPath: root, ExperimentStrategy: ExperimentStrategyDepth, ExperimentDepth: 1, Id: 1}
+	links, err := RelinkExperiments(context.Background(), db, c, location, true)
+	if err != nil || len(links) != 2 || links[0].To != 0 || links[0].ExperimentName != "1/projectA" {
+		t.Fatalf("RelinkExperiments() dry run = %+v, %v", links, err)
+	}
+	for _, request := range server.Requests() {
+		if request.Method != "GET" {
+			t.Errorf("dry run sent %s %s", request.Method, request.Path)
+		}
+	}
+	if experiments := server.Records(catapulttest.Experiments); len(experiments) != 1 {
+		t.Errorf("dry run created experiments: %v", experiments)
+	}
+
+	links, err = RelinkExperiments(context.Background(), db, c, location, false)
+	if err != nil || len(links) != 2 {
+		t.Fatalf("RelinkExperiments() = %+v, %v", links, err)
+	}
+	for _, id := range ids {
+		record, _ := server.Get(catapulttest.Files, id)
+		if fmt.Sprint(record["experiment"]) != fmt.Sprint(links[0].To) {
+			t.Errorf("file %d experiment = %v, want %d", id, record["experiment"], links[0].To)
+		}
+	}
+	if links, err := RelinkExperiments(context.Background(), db, c, location, false); err != nil || len(links) != 0 {
+		t.Errorf("second RelinkExperiments() = %+v, %v, want nothing left to do", links, err)
+	}
+}
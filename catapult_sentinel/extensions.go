@@ -0,0 +1,113 @@
+package catapult_sentinel
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ExtensionRules is the parsed form of FolderWatchingLocation.Extensions. The
+// zero value, parsed from an empty list, matches no name, as an empty list
+// always did; "*" matches every name.
+type ExtensionRules struct {
+	// Suffixes are lower case and start with a dot, such as ".raw" or
+	// ".converted.mzml".
+	Suffixes []string
+	// Globs are lower case patterns matched against the whole name.
+	Globs []string
+}
+
+// ParseExtensionRules parses a list of suffixes and glob patterns separated by
+// commas, semicolons or spaces. Suffixes may span several dots, like
+// ".converted.mzML" or ".wiff.scan", and the leading dot is optional. Entries
+// holding *, ? or [ are globs, so "*" alone matches everything. Matching
+// ignores case. Invalid globs are left out and reported in the error.
+func ParseExtensionRules(spec string) (ExtensionRules, error) {
+	var rules ExtensionRules
+	var invalid []string
+	fields := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	for _, field := range fields {
+		field = strings.ToLower(field)
+		if strings.ContainsAny(field, "*?[") {
+			if _, err := path.Match(field, ""); err != nil {
+				invalid = append(invalid, field)
+				continue
+			}
+			rules.Globs = append(rules.Globs, field)
+			continue
+		}
+		if !strings.HasPrefix(field, ".") {
+			field = "." + field
+		}
+		if field == "." {
+			continue
+		}
+		rules.Suffixes = append(rules.Suffixes, field)
+	}
+	if len(invalid) > 0 {
+		return rules, fmt.Errorf("invalid extension patterns %q", invalid)
+	}
+	return rules, nil
+}
+
+// Empty reports whether the rules hold no entry and so match no name.
+func (r ExtensionRules) Empty() bool {
+	return len(r.Suffixes) == 0 && len(r.Globs) == 0
+}
+
+// Match reports whether the file or folder name matches one of the rules. A
+// suffix only matches a name longer than itself, so ".raw" does not match a
+// file named ".raw".
+func (r ExtensionRules) Match(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range r.Suffixes {
+		if len(name) > len(suffix) && strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	for _, glob := range r.Globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRunConfig reports whether name is a Catapult run configuration file, which
+// is tracked whatever the extension rules of its location.
+func IsRunConfig(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".cat.yml") || strings.HasSuffix(name, ".cat.yaml")
+}
+
+type cachedExtensions struct {
+	spec  string
+	rules ExtensionRules
+}
+
+var (
+	extensionsMu    sync.Mutex
+	extensionsCache = make(map[int]cachedExtensions)
+)
+
+// LocationExtensions returns the parsed extension rules of location. Rules are
+// parsed once per location and parsed again when its Extensions change;
+// invalid entries are logged and skipped.
+func LocationExtensions(location FolderWatchingLocation) ExtensionRules {
+	extensionsMu.Lock()
+	defer extensionsMu.Unlock()
+	cached, ok := extensionsCache[location.Id]
+	if ok && cached.spec == location.Extensions {
+		return cached.rules
+	}
+	rules, err := ParseExtensionRules(location.Extensions)
+	if err != nil {
+		log.Printf("location %s: %v", location.FolderPath, err)
+	}
+	extensionsCache[location.Id] = cachedExtensions{spec: location.Extensions, rules: rules}
+	return rules
+}
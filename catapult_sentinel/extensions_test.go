@@ -0,0 +1,106 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestExtensionRules_Match(t *testing.T) {
+	tests := []struct {
+		spec string
+		name string
+		want bool
+	}{
+		{".mzML,.raw,.d", "sample.d", true},
+		{".mzML,.raw,.d", "sample.RAW", true},
+		{".mzML,.raw,.d", "sample.dat", false},
+		{".mzML,.raw,.d", "README", false},
+		{".mzML,.raw,.d", ".raw", false},
+		{"raw mzML", "sample.mzml", true},
+		{".converted.mzML", "sample.converted.mzML", true},
+		{".converted.mzML", "sample.mzML", false},
+		{".wiff; .wiff.scan", "sample.wiff.scan", true},
+		{"QC_*.raw", "qc_01.raw", true},
+		{"QC_*.raw", "sample_01.raw", false},
+		{"sample_0?.*", "sample_01.wiff", true},
+		{"*", "anything", true},
+		{"", "anything", false},
+		{" , ;", "anything", false},
+	}
+	for _, tt := range tests {
+		rules, err := ParseExtensionRules(tt.spec)
+		if err != nil {
+			t.Errorf("ParseExtensionRules(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if got := rules.Match(tt.name); got != tt.want {
+			t.Errorf("rules %q Match(%s) = %v, want %v", tt.spec, tt.name, got, tt.want)
+		}
+	}
+
+	rules, err := ParseExtensionRules(".raw,[a-")
+	if err == nil || len(rules.Suffixes) != 1 || len(rules.Globs) != 0 {
+		t.Errorf("ParseExtensionRules() with a bad glob = %+v, %v", rules, err)
+	}
+}
+
+func TestLocationExtensions(t *testing.T) {
+	location := FolderWatchingLocation{Id: 41, Extensions: ".raw"}
+	if !LocationExtensions(location).Match("a.raw") || LocationExtensions(location).Match("a.d") {
+		t.Errorf("LocationExtensions(.raw) does not match .raw only")
+	}
+	location.Extensions = ".d"
+	if rules := LocationExtensions(location); rules.Match("a.raw") || !rules.Match("a.d") {
+		t.Errorf("LocationExtensions() kept the rules of the old extensions: %+v", rules)
+	}
+}
+
+func TestScanner_Extensions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	for name, size := range map[string]int{
+		"exp1/a.raw":        10,
+		"exp1/b.mzML":       10,
+		"exp1/c.wiff":       10,
+		"exp1/c.wiff.scan":  5,
+		"exp1/d.d/data.tdf": 10,
+		"exp1/run.cat.yml":  1,
+		"exp1/notes":        1,
+	} {
+		writeTestFile(t, filepath.Join(root, name), size)
+	}
+
+	location := FolderWatchingLocation{FolderPath: root, Extensions: ".raw, .WIFF", Id: 42}
+	task, err := NewScanner(db).Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	var got []string
+	sizes := make(map[string]int64)
+	for _, file := range task.NewFile {
+		rel, _ := filepath.Rel(root, file.FilePath)
+		got = append(got, rel)
+		sizes[rel] = file.Size
+	}
+	sort.Strings(got)
+	want := []string{"exp1/a.raw", "exp1/c.wiff", "exp1/run.cat.yml"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("Scan() new files = %v, want %v", got, want)
+	}
+	if sizes["exp1/c.wiff"] != 15 {
+		t.Errorf("c.wiff size = %d, want 15 with its companion", sizes["exp1/c.wiff"])
+	}
+
+	// a location without extensions only tracks its run configs
+	other := t.TempDir()
+	writeTestFile(t, filepath.Join(other, "exp1", "a.raw"), 10)
+	writeTestFile(t, filepath.Join(other, "exp1", "run.cat.yml"), 1)
+	location = FolderWatchingLocation{FolderPath: other, Id: 43}
+	task, err = NewScanner(db).Scan(context.Background(), location)
+	if err != nil || len(task.NewFile) != 1 || filepath.Base(task.NewFile[0].FilePath) != "run.cat.yml" {
+		t.Errorf("Scan() without extensions = %+v, %v, want the run config only", task.NewFile, err)
+	}
+}
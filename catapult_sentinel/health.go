@@ -0,0 +1,127 @@
+package catapult_sentinel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// LocationHealth tells whether a scan can be trusted to reflect the real
+// content of a location. A degraded scan still reports new and changed files
+// but never deletions, and callers must not derive experiment changes from it.
+type LocationHealth struct {
+	Degraded bool   `json:"degraded"`
+	Reason   string `json:"reason"`
+}
+
+func degraded(format string, args ...interface{}) LocationHealth {
+	return LocationHealth{Degraded: true, Reason: fmt.Sprintf(format, args...)}
+}
+
+const (
+	// DefaultMaxMissingRatio is the fraction of known files that may disappear
+	// between two scans before the location is considered unhealthy.
+	DefaultMaxMissingRatio = 0.2
+	// DefaultMinKnownFiles is the number of known files below which the missing
+	// ratio is not checked.
+	DefaultMinKnownFiles = 20
+	// DefaultConfirmDropScans is the number of consecutive scans finding the
+	// same reduced file count after which a large drop is accepted as a real
+	// deletion. A location where no file is found at all is never accepted
+	// this way, see DropConfirmFile.
+	DefaultConfirmDropScans = 10
+)
+
+// DropConfirmFile is the file an operator creates at the root of a location to
+// confirm that its files were really deleted when a scan finds none of them,
+// which is otherwise taken for an unmounted share. It is removed once the
+// deletions have been reported.
+const DropConfirmFile = ".sentinel-confirm-drop"
+
+// checkRoot verifies the location root exists, is a readable directory and,
+// when the scanner requires one, holds the marker file. Network shares that
+// are not mounted usually show up as a missing root or as an empty mount point
+// without the marker.
+func (s *Scanner) checkRoot(location FolderWatchingLocation) LocationHealth {
+	info, err := os.Stat(location.FolderPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return degraded("root %s does not exist", location.FolderPath)
+	case errors.Is(err, fs.ErrPermission):
+		return degraded("permission denied on root %s", location.FolderPath)
+	case err != nil:
+		return degraded("cannot stat root %s: %v", location.FolderPath, err)
+	case !info.IsDir():
+		return degraded("root %s is not a directory", location.FolderPath)
+	}
+
+	dir, err := os.Open(location.FolderPath)
+	if err != nil {
+		return degraded("cannot open root %s: %v", location.FolderPath, err)
+	}
+	_, err = dir.Readdirnames(1)
+	dir.Close()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return degraded("cannot list root %s: %v", location.FolderPath, err)
+	}
+
+	if s.MarkerFile != "" {
+		if _, err := os.Stat(filepath.Join(location.FolderPath, s.MarkerFile)); err != nil {
+			return degraded("marker file %s missing from %s", s.MarkerFile, location.FolderPath)
+		}
+	}
+	return LocationHealth{}
+}
+
+type dropState struct {
+	found int
+	scans int
+}
+
+// checkDrop trips when more than MaxMissingRatio of the known files are not
+// found by the walk. A drop that is seen with the same file count for
+// ConfirmDropScans consecutive scans is accepted as a real mass deletion.
+// Finding no file at all where some were known trips whatever the number of
+// known files, and is only accepted once an operator creates DropConfirmFile.
+func (s *Scanner) checkDrop(location FolderWatchingLocation, known int, missing int, found int) LocationHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	confirmFile := filepath.Join(location.FolderPath, DropConfirmFile)
+	_, err := os.Stat(confirmFile)
+	confirmed := err == nil
+	emptied := known > 0 && found == 0
+	if !emptied && (known < s.MinKnownFiles || float64(missing) <= float64(known)*s.MaxMissingRatio) {
+		delete(s.drops, location.Id)
+		if confirmed {
+			if err := os.Remove(confirmFile); err != nil {
+				log.Printf("cannot remove %s: %v", confirmFile, err)
+			}
+		}
+		return LocationHealth{}
+	}
+	if confirmed {
+		return LocationHealth{}
+	}
+	if emptied {
+		delete(s.drops, location.Id)
+		return degraded("none of the %d known files found in %s, create %s there if they were deleted", known, location.FolderPath, DropConfirmFile)
+	}
+
+	if s.drops == nil {
+		s.drops = make(map[int]dropState)
+	}
+	state := s.drops[location.Id]
+	if state.found != found {
+		state = dropState{found: found}
+	}
+	state.scans++
+	s.drops[location.Id] = state
+	if s.ConfirmDropScans > 0 && state.scans > s.ConfirmDropScans {
+		return LocationHealth{}
+	}
+	return degraded("%d of %d known files missing from %s", missing, known, location.FolderPath)
+}
@@ -0,0 +1,160 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanner_RootMissing(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFile(t, filepath.Join(root, "sample_01.raw"), 10)
+
+	now := time.Unix(1700000000, 0)
+	scanner := NewScanner(db)
+	scanner.now = func() time.Time { return now }
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", NetworkFolder: true, Id: 1}
+	scanner.Scan(context.Background(), location)
+
+	os.RemoveAll(root)
+	for i := 0; i < 2; i++ {
+		task, err := scanner.Scan(context.Background(), location)
+		if err != nil {
+			t.Fatalf("Scan() error: %v", err)
+		}
+		if !task.Health.Degraded || len(task.DeletedFile) != 0 {
+			t.Fatalf("Scan() with missing root = %+v, want degraded without deletions", task)
+		}
+		now = now.Add(DefaultDeletionGracePeriod)
+	}
+	tombstones, _ := GetTombstones(db, root)
+	if len(tombstones) != 0 {
+		t.Errorf("GetTombstones() = %v, want none while degraded", tombstones)
+	}
+}
+
+func TestScanner_MarkerFile(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "sample_01.raw"), 10)
+
+	scanner := NewScanner(db)
+	scanner.MarkerFile = ".sentinel"
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	task, _ := scanner.Scan(context.Background(), location)
+	if !task.Health.Degraded {
+		t.Fatalf("Scan() without marker = %+v, want degraded", task.Health)
+	}
+
+	writeTestFile(t, filepath.Join(root, ".sentinel"), 0)
+	task, _ = scanner.Scan(context.Background(), location)
+	if task.Health.Degraded || len(task.NewFile) != 1 {
+		t.Fatalf("Scan() with marker = %+v, want healthy with only the raw file", task)
+	}
+}
+
+func TestScanner_MassDrop(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	for i := 0; i < 30; i++ {
+		writeTestFile(t, filepath.Join(root, fmt.Sprintf("sample_%02d.raw", i)), 10)
+	}
+
+	now := time.Unix(1700000000, 0)
+	scanner := NewScanner(db)
+	scanner.ConfirmDropScans = 2
+	scanner.DeletionGracePeriod = 0
+	scanner.now = func() time.Time { return now }
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	scanner.Scan(context.Background(), location)
+
+	for i := 0; i < 20; i++ {
+		os.Remove(filepath.Join(root, fmt.Sprintf("sample_%02d.raw", i)))
+	}
+	for i := 0; i < 2; i++ {
+		task, _ := scanner.Scan(context.Background(), location)
+		if !task.Health.Degraded || len(task.DeletedFile) != 0 {
+			t.Fatalf("Scan() %d after mass drop = %+v, want degraded", i, task.Health)
+		}
+	}
+
+	deleted := 0
+	for i := 0; i < 3; i++ {
+		task, _ := scanner.Scan(context.Background(), location)
+		if task.Health.Degraded {
+			t.Fatalf("Scan() after confirmed drop = %+v, want healthy", task.Health)
+		}
+		deleted += len(task.DeletedFile)
+	}
+	if deleted != 20 {
+		t.Errorf("Scan() reported %d deletions after confirmation, want 20", deleted)
+	}
+}
+
+func TestScanner_EmptiedLocation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	for i := 0; i < 3; i++ {
+		writeTestFile(t, filepath.Join(root, fmt.Sprintf("sample_%02d.raw", i)), 10)
+	}
+
+	scanner := NewScanner(db)
+	scanner.ConfirmDropScans = 2
+	scanner.DeletionGracePeriod = 0
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	scanner.Scan(context.Background(), location)
+
+	// an empty mount point looks the same, however long it lasts
+	for i := 0; i < 3; i++ {
+		os.Remove(filepath.Join(root, fmt.Sprintf("sample_%02d.raw", i)))
+	}
+	for i := 0; i < 5; i++ {
+		task, _ := scanner.Scan(context.Background(), location)
+		if !task.Health.Degraded || len(task.DeletedFile) != 0 {
+			t.Fatalf("Scan() %d of an emptied location = %+v, want degraded", i, task.Health)
+		}
+	}
+
+	writeTestFile(t, filepath.Join(root, DropConfirmFile), 0)
+	deleted := 0
+	for i := 0; i < 3; i++ {
+		task, _ := scanner.Scan(context.Background(), location)
+		if task.Health.Degraded {
+			t.Fatalf("Scan() after confirmation = %+v, want healthy", task.Health)
+		}
+		deleted += len(task.DeletedFile)
+	}
+	if deleted != 3 {
+		t.Errorf("Scan() reported %d deletions after confirmation, want 3", deleted)
+	}
+	if _, err := os.Stat(filepath.Join(root, DropConfirmFile)); err == nil {
+		t.Errorf("%s kept once the deletions were reported", DropConfirmFile)
+	}
+}
+
+func TestScanner_UnreadableSubdirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.raw"), 10)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	NewScanner(db).Scan(context.Background(), location)
+
+	os.Chmod(filepath.Join(root, "exp1"), 0)
+	defer os.Chmod(filepath.Join(root, "exp1"), 0o755)
+	task, err := NewScanner(db).Scan(context.Background(), location)
+	if err != nil || !task.Health.Degraded {
+		t.Fatalf("Scan() with unreadable subdirectory = %+v, %v, want degraded", task.Health, err)
+	}
+}
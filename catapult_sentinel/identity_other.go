@@ -0,0 +1,11 @@
+//go:build !unix
+
+package catapult_sentinel
+
+import "os"
+
+// fileIdentity has no portable equivalent here; moves are only matched on
+// size and checksum.
+func fileIdentity(info os.FileInfo) (int64, int64) {
+	return 0, 0
+}
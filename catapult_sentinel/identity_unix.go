@@ -0,0 +1,17 @@
+//go:build unix
+
+package catapult_sentinel
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode info was read from.
+func fileIdentity(info os.FileInfo) (int64, int64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return int64(stat.Dev), int64(stat.Ino)
+}
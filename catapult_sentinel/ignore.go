@@ -0,0 +1,256 @@
+package catapult_sentinel
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// IgnoreFileName is the name of the files holding ignore rules for the
+// directory they are in and everything below it.
+const IgnoreFileName = ".sentinelignore"
+
+// ignoreRule is one line of ignore rules, compiled to match a slash separated
+// path relative to the directory the rule was defined in.
+type ignoreRule struct {
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// parseIgnoreRules parses rules with gitignore semantics, one per line:
+//
+//   - blank lines and lines starting with # are skipped, \# and \! escape them
+//   - a leading ! re-includes what an earlier rule ignored
+//   - a trailing / only matches directories
+//   - a pattern holding a / other than a trailing one is anchored to the
+//     directory of the rules, any other pattern matches at any depth
+//   - *, ? and [...] do not match /, ** matches any number of directories
+//   - re: introduces a regular expression matched against the relative path
+//
+// Rules that do not compile are left out and reported in the error.
+func parseIgnoreRules(text string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	var errs []error
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+
+		var expr string
+		if pattern, ok := strings.CutPrefix(line, "re:"); ok {
+			expr = pattern
+		} else {
+			if strings.HasSuffix(line, "/") {
+				rule.dirOnly = true
+				line = strings.TrimRight(line, "/")
+			}
+			if strings.Contains(line, "/") {
+				expr = "^" + globToRegexp(strings.TrimPrefix(line, "/")) + "$"
+			} else {
+				expr = "^(?:.*/)?" + globToRegexp(line) + "$"
+			}
+		}
+		if line == "" {
+			errs = append(errs, fmt.Errorf("ignore rule %d: empty pattern", n+1))
+			continue
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ignore rule %d: %w", n+1, err))
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules, errors.Join(errs...)
+}
+
+// globToRegexp translates a glob into a regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				rest := glob[i+2:]
+				switch {
+				case strings.HasPrefix(rest, "/"):
+					b.WriteString("(?:.*/)?")
+					i += 2
+				default:
+					b.WriteString(".*")
+					i++
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// matchRules applies rules to rel, a path relative to their directory, and
+// returns whether the last matching rule ignores it, ignored when none does.
+func matchRules(rules []ignoreRule, rel string, isDir bool, ignored bool) bool {
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// IgnoreMatcher decides which paths of a location are not tracked. The
+// location contributes its IgnoreTerm, a substring of ignored names that
+// spares .cat.yml files, then its IgnorePatterns; every .sentinelignore below
+// the root adds rules for its own directory. Later rules win, so deeper files
+// override shallower ones and the location. The .sentinelignore files are read
+// once and kept until Reset.
+type IgnoreMatcher struct {
+	location FolderWatchingLocation
+	rules    []ignoreRule
+
+	mu    sync.Mutex
+	files map[string][]ignoreRule
+}
+
+// NewIgnoreMatcher returns the matcher of location. An error reports location
+// patterns that do not compile; the matcher applies the others.
+func NewIgnoreMatcher(location FolderWatchingLocation) (*IgnoreMatcher, error) {
+	rules, err := parseIgnoreRules(location.IgnorePatterns)
+	return &IgnoreMatcher{location: location, rules: rules, files: make(map[string][]ignoreRule)}, err
+}
+
+// Reset forgets the .sentinelignore files read so far.
+func (m *IgnoreMatcher) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files = make(map[string][]ignoreRule)
+}
+
+// fileRules returns the rules of the .sentinelignore in dir.
+func (m *IgnoreMatcher) fileRules(dir string) []ignoreRule {
+	m.mu.Lock()
+	rules, ok := m.files[dir]
+	m.mu.Unlock()
+	if ok {
+		return rules
+	}
+	data, err := os.ReadFile(filepath.Join(dir, IgnoreFileName))
+	if err == nil {
+		rules, err = parseIgnoreRules(string(data))
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("%s: %v", filepath.Join(dir, IgnoreFileName), err)
+	}
+	m.mu.Lock()
+	m.files[dir] = rules
+	m.mu.Unlock()
+	return rules
+}
+
+// Ignored reports whether path, or one of the directories holding it below the
+// root, is ignored.
+func (m *IgnoreMatcher) Ignored(path string, isDir bool) bool {
+	root := m.location.FolderPath
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	dir := root
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if m.match(dir, true) {
+			return true
+		}
+	}
+	return m.match(path, isDir)
+}
+
+// match reports whether path itself is ignored, assuming the directories
+// holding it are not. Walks prune ignored directories and only need this.
+func (m *IgnoreMatcher) match(path string, isDir bool) bool {
+	name := filepath.Base(path)
+	term := m.location.IgnoreTerm
+	ignored := term != "" && strings.Contains(name, term) && !strings.HasSuffix(name, ".cat.yml")
+
+	root := m.location.FolderPath
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return ignored
+	}
+	ignored = matchRules(m.rules, filepath.ToSlash(rel), isDir, ignored)
+	for dir := root; ; {
+		// rel is relative to dir from here on
+		if rules := m.fileRules(dir); len(rules) > 0 {
+			ignored = matchRules(rules, filepath.ToSlash(rel), isDir, ignored)
+		}
+		next, _, found := strings.Cut(rel, string(filepath.Separator))
+		if !found {
+			break
+		}
+		dir = filepath.Join(dir, next)
+		rel = rel[len(next)+1:]
+	}
+	return ignored
+}
+
+// ignoreMatcher returns the matcher of location, built again when the
+// location changed.
+func (s *Scanner) ignoreMatcher(location FolderWatchingLocation) *IgnoreMatcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ignores == nil {
+		s.ignores = make(map[int]*IgnoreMatcher)
+	}
+	matcher, ok := s.ignores[location.Id]
+	if !ok || matcher.location != location {
+		var err error
+		matcher, err = NewIgnoreMatcher(location)
+		if err != nil {
+			log.Printf("location %s: %v", location.FolderPath, err)
+		}
+		s.ignores[location.Id] = matcher
+	}
+	return matcher
+}
@@ -0,0 +1,128 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIgnoreRules(t *testing.T) {
+	tests := []struct {
+		rules string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"*.tmp", "a.tmp", false, true},
+		{"*.tmp", "exp1/sub/a.tmp", false, true},
+		{"*.tmp", "exp1/a.tmp.raw", false, false},
+		{"/scratch", "scratch", true, true},
+		{"/scratch", "exp1/scratch", true, false},
+		{"exp1/*.raw", "exp1/a.raw", false, true},
+		{"exp1/*.raw", "exp1/sub/a.raw", false, false},
+		{"exp1/**/*.raw", "exp1/sub/deep/a.raw", false, true},
+		{"**/blank", "x/y/blank", true, true},
+		{"backup/", "exp1/backup", true, true},
+		{"backup/", "exp1/backup", false, false},
+		{"sample_0?.raw", "sample_01.raw", false, true},
+		{"sample_[!0]*.raw", "sample_01.raw", false, false},
+		{"sample_[!0]*.raw", "sample_11.raw", false, true},
+		{"*.raw\n!keep.raw", "keep.raw", false, false},
+		{"*.raw\n!keep.raw", "drop.raw", false, true},
+		{`re:^exp[0-9]+/QC_`, "exp12/QC_01.raw", false, true},
+		{`re:^exp[0-9]+/QC_`, "blank/QC_01.raw", false, false},
+		{"# *.raw\n\n", "a.raw", false, false},
+		{`\#hash`, "#hash", false, true},
+	}
+	for _, tt := range tests {
+		rules, err := parseIgnoreRules(tt.rules)
+		if err != nil {
+			t.Errorf("parseIgnoreRules(%q) error: %v", tt.rules, err)
+			continue
+		}
+		if got := matchRules(rules, tt.path, tt.isDir, false); got != tt.want {
+			t.Errorf("rules %q on %s = %v, want %v", tt.rules, tt.path, got, tt.want)
+		}
+	}
+
+	rules, err := parseIgnoreRules("*.tmp\nre:(\n!")
+	if err == nil || len(rules) != 1 {
+		t.Errorf("parseIgnoreRules() with bad lines = %d rules, %v", len(rules), err)
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", IgnoreFileName), 0)
+	os.WriteFile(filepath.Join(root, IgnoreFileName), []byte("*.log\nscratch/\n"), 0o644)
+	os.WriteFile(filepath.Join(root, "exp1", IgnoreFileName), []byte("!run.log\n*.raw\n"), 0o644)
+
+	matcher, err := NewIgnoreMatcher(FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", IgnorePatterns: "*.bak"})
+	if err != nil {
+		t.Fatalf("NewIgnoreMatcher() error: %v", err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"a.raw", false},
+		{"a.bak", true},
+		{"~lock.raw", true},
+		{"~run.cat.yml", false},
+		{"a.log", true},
+		{"exp1/run.log", false},
+		{"exp1/a.raw", true},
+		{"exp2/a.raw", false},
+		{"scratch/a.raw", true},
+		{"exp1/scratch/deep/b.mzML", true},
+		{"..scratch.bak", true},
+	}
+	for _, tt := range tests {
+		if got := matcher.Ignored(filepath.Join(root, tt.path), false); got != tt.want {
+			t.Errorf("Ignored(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// an empty ignore term used to ignore every file
+	matcher, _ = NewIgnoreMatcher(FolderWatchingLocation{FolderPath: t.TempDir(), Extensions: "*"})
+	if matcher.Ignored(filepath.Join(matcher.location.FolderPath, "a.raw"), false) {
+		t.Errorf("Ignored() with no rules = true")
+	}
+}
+
+func TestScanner_IgnorePrunesDirectories(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.raw"), 10)
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.tmp"), 10)
+	writeTestFile(t, filepath.Join(root, "scratch", "deep", "sample_02.raw"), 10)
+	os.WriteFile(filepath.Join(root, IgnoreFileName), []byte("scratch/\n"), 0o644)
+
+	scanner := NewScanner(db)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnorePatterns: "*.tmp", Id: 1}
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if len(task.NewFile) != 1 || task.NewFile[0].FilePath != filepath.Join(root, "exp1", "sample_01.raw") {
+		t.Errorf("Scan() new files = %+v, want only sample_01.raw", task.NewFile)
+	}
+	dirs, _ := ListDirectoriesUnder(db, root)
+	if _, ok := dirs[filepath.Join(root, "scratch")]; ok {
+		t.Errorf("ignored directory was walked: %v", dirs)
+	}
+
+	task, err = scanner.ScanPaths(context.Background(), location, []string{filepath.Join(root, "scratch", "deep", "sample_02.raw")})
+	if err != nil || len(task.NewFile) != 0 {
+		t.Errorf("ScanPaths() in an ignored directory = %+v, %v", task.NewFile, err)
+	}
+
+	// un-ignoring the directory takes effect on the next full scan
+	os.Remove(filepath.Join(root, IgnoreFileName))
+	task, err = scanner.FullScan(context.Background(), location)
+	if err != nil || len(task.NewFile) != 1 || task.NewFile[0].FilePath != filepath.Join(root, "scratch", "deep", "sample_02.raw") {
+		t.Errorf("FullScan() after removing the ignore file = %+v, %v", task.NewFile, err)
+	}
+}
@@ -0,0 +1,252 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+)
+
+// FileMove is a tracked file found under a new path. File carries the new path
+// and the remote id of the record to update, ExperimentName the experiment the
+// new path belongs to.
+type FileMove struct {
+	From           string `json:"from"`
+	File           File   `json:"file"`
+	ExperimentName string `json:"experiment_name"`
+}
+
+// moveCandidate is a file not recorded yet that may be a known file moved.
+type moveCandidate struct {
+	path          string
+	isFolder      bool
+	size          int64
+	lastModified  int64
+	device, inode int64
+}
+
+// MoveCandidateHash is the checksum of a new file of the same size as a
+// vanished hashed file, computed by HashPending with the algorithm of that
+// file. Hashed is set once it was attempted, Checksum stays empty when the
+// file could not be read.
+type MoveCandidateHash struct {
+	Path         string
+	Size         int64
+	LastModified int64
+	Algorithm    string
+	Checksum     string
+	Hashed       bool
+}
+
+// ListMoveCandidates returns the move candidates at or below root.
+func ListMoveCandidates(db *sql.DB, root string) (map[string]MoveCandidateHash, error) {
+	under, args := underRoot("path", root)
+	rows, err := db.Query("SELECT path, size, last_modified, algorithm, checksum, hashed FROM move_candidates WHERE "+under, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make(map[string]MoveCandidateHash)
+	for rows.Next() {
+		var c MoveCandidateHash
+		if err := rows.Scan(&c.Path, &c.Size, &c.LastModified, &c.Algorithm, &c.Checksum, &c.Hashed); err != nil {
+			return nil, err
+		}
+		candidates[c.Path] = c
+	}
+	return candidates, rows.Err()
+}
+
+// putMoveCandidate queues the candidate to be hashed, forgetting an earlier
+// checksum.
+func putMoveCandidate(db Execer, c MoveCandidateHash) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO move_candidates (path, size, last_modified, algorithm, checksum, hashed) VALUES (?, ?, ?, ?, '', 0)",
+		c.Path, c.Size, c.LastModified, c.Algorithm)
+	return err
+}
+
+// recordMoveCandidateHash stores the checksum of c unless the file changed
+// since it was queued.
+func recordMoveCandidateHash(db Execer, c MoveCandidateHash) error {
+	_, err := db.Exec("UPDATE move_candidates SET checksum = ?, hashed = 1 WHERE path = ? AND size = ? AND last_modified = ?",
+		c.Checksum, c.Path, c.Size, c.LastModified)
+	return err
+}
+
+// hashMoveCandidates hashes the move candidates of location queued by the
+// scans, which match them on their next pass.
+func (s *Scanner) hashMoveCandidates(ctx context.Context, location FolderWatchingLocation) error {
+	candidates, err := ListMoveCandidates(s.DB, location.FolderPath)
+	if err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		if c.Hashed {
+			continue
+		}
+		checksummer := *s.Checksums
+		checksummer.Algorithm = c.Algorithm
+		c.Checksum, err = checksummer.SumAcquisition(ctx, c.Path, s.Vendors.CompanionPaths(c.Path))
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			log.Printf("cannot hash %s: %v", c.Path, err)
+			c.Checksum = ""
+		}
+		if err := recordMoveCandidateHash(s.DB, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchMoves pairs new files with known files whose path no longer exists. A
+// file keeping its device and inode was renamed on the same filesystem; across
+// filesystems a file is matched on size and checksum. New files are not hashed
+// during the scan: a new file of the same size as a vanished hashed file is
+// deferred, left out of the scan until HashPending has hashed it, and matched
+// by the next scan seeing it. missing holds the known files not found by the
+// walk, they are looked up under the location when nil. Files that vanished
+// from the other locations, known by their tombstones, are matched as well, so
+// that a move between watched locations keeps the backend record. The result
+// maps new paths to the known file they replace, along with the deferred
+// paths.
+func (s *Scanner) matchMoves(ctx context.Context, location FolderWatchingLocation, candidates []moveCandidate, missing []LocalFile) (map[string]LocalFile, map[string]bool, error) {
+	if len(candidates) == 0 {
+		return nil, nil, nil
+	}
+	if missing == nil {
+		known, err := ListFilesUnder(s.DB, location.FolderPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		missing = known
+	}
+	elsewhere, err := GetTombstonesOutside(s.DB, location.FolderPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, t := range elsewhere {
+		missing = append(missing, LocalFile{
+			Path:         t.Path,
+			Size:         t.Size,
+			IsFolder:     t.IsFolder,
+			LastModified: t.LastModified,
+			RemoteId:     t.RemoteId,
+			Checksum:     t.Checksum,
+			Device:       t.Device,
+			Inode:        t.Inode,
+		})
+	}
+
+	type identity struct{ device, inode int64 }
+	sizes := make(map[int64]bool, len(candidates))
+	for _, c := range candidates {
+		sizes[c.size] = true
+	}
+	byIdentity := make(map[identity]LocalFile)
+	bySize := make(map[int64][]LocalFile)
+	for _, file := range missing {
+		// an inode is reused once freed, so only a file of the same size can
+		// have been moved
+		if !sizes[file.Size] || (file.Inode == 0 && file.Checksum == "") {
+			continue
+		}
+		if _, err := os.Lstat(file.Path); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if file.Inode != 0 {
+			byIdentity[identity{file.Device, file.Inode}] = file
+		}
+		if file.Checksum != "" {
+			bySize[file.Size] = append(bySize[file.Size], file)
+		}
+	}
+	if len(byIdentity) == 0 && len(bySize) == 0 {
+		return nil, nil, nil
+	}
+	hashes, err := ListMoveCandidates(s.DB, location.FolderPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	moves := make(map[string]LocalFile)
+	deferred := make(map[string]bool)
+	used := make(map[string]bool)
+	for _, c := range candidates {
+		if file, ok := byIdentity[identity{c.device, c.inode}]; ok && c.inode != 0 && !used[file.Path] && file.Size == c.size && file.IsFolder == c.isFolder {
+			moves[c.path] = file
+			used[file.Path] = true
+			continue
+		}
+		if s.Checksums == nil {
+			continue
+		}
+		var same []LocalFile
+		for _, file := range bySize[c.size] {
+			if !used[file.Path] && file.IsFolder == c.isFolder {
+				same = append(same, file)
+			}
+		}
+		if len(same) == 0 {
+			continue
+		}
+		hash, ok := hashes[c.path]
+		if !ok || hash.Size != c.size || hash.LastModified != c.lastModified {
+			algorithm, _, _ := strings.Cut(same[0].Checksum, ":")
+			hash = MoveCandidateHash{Path: c.path, Size: c.size, LastModified: c.lastModified, Algorithm: algorithm}
+			if err := putMoveCandidate(s.DB, hash); err != nil {
+				return nil, nil, err
+			}
+		}
+		if !hash.Hashed {
+			deferred[c.path] = true
+			continue
+		}
+		for _, file := range same {
+			if hash.Checksum != "" && hash.Checksum == file.Checksum {
+				moves[c.path] = file
+				used[file.Path] = true
+				break
+			}
+		}
+	}
+	return moves, deferred, nil
+}
+
+// moveFile records the file from under to, keeping its remote id, checksum
+// and stability, and gives it the identity it has now. A file already reported
+// deleted by its former location is recorded again from its tombstone.
+func moveFile(tx *sql.Tx, from LocalFile, to string, device int64, inode int64) error {
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM stability WHERE path = ?", []interface{}{to}},
+		{"DELETE FROM tombstones WHERE path IN (?, ?)", []interface{}{from.Path, to}},
+		{"DELETE FROM move_candidates WHERE path = ?", []interface{}{to}},
+		{"DELETE FROM folder_stats WHERE path = ?", []interface{}{from.Path}},
+		{"UPDATE stability SET path = ? WHERE path = ?", []interface{}{to, from.Path}},
+		{"UPDATE stability_history SET path = ? WHERE path = ?", []interface{}{to, from.Path}},
+		{"UPDATE scrub_history SET path = ? WHERE path = ?", []interface{}{to, from.Path}},
+	} {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec("UPDATE files SET path = ?, device = ?, inode = ? WHERE path = ?", to, device, inode, from.Path)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO files (path, size, is_folder, last_modified, remote_id, checksum, device, inode) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		to, from.Size, from.IsFolder, from.LastModified, from.RemoteId, from.Checksum, device, inode)
+	return err
+}
@@ -0,0 +1,226 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func TestScanner_Rename(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	from := filepath.Join(root, "exp1", "sample_01.raw")
+	to := filepath.Join(root, "exp2", "sample_01.raw")
+	writeTestFile(t, from, 10)
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_02.raw"), 10)
+	os.MkdirAll(filepath.Dir(to), 0o755)
+
+	scanner := NewScanner(db)
+	scanner.DeletionGracePeriod = 0
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	SetRemoteId(db, from, 5)
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if len(task.NewFile) != 0 || len(task.MovedFile) != 1 {
+		t.Fatalf("Scan() = %+v, want one moved file", task)
+	}
+	move := task.MovedFile[0]
+	if move.From != from || move.File.FilePath != to || move.File.Id != 5 || move.ExperimentName != filepath.Dir(to) {
+		t.Errorf("MovedFile[0] = %+v", move)
+	}
+	if file, err := GetFile(db, to); err != nil || file.RemoteId != 5 {
+		t.Errorf("GetFile(%s) = %+v, %v, want remote id 5", to, file, err)
+	}
+	if exists, _ := CheckFileExists(db, from); exists {
+		t.Errorf("%s still recorded after the move", from)
+	}
+
+	for i := 0; i < 2; i++ {
+		task, _ = scanner.Scan(context.Background(), location)
+		if len(task.DeletedFile) != 0 || len(task.MovedFile) != 0 || len(task.NewFile) != 0 {
+			t.Errorf("Scan() after the move = %+v", task)
+		}
+	}
+}
+
+func TestScanner_MoveByChecksum(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	from := filepath.Join(root, "exp1", "sample_01.raw")
+	writeTestFile(t, from, 10)
+	scanner := NewScanner(db)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	checksum, _ := scanner.Checksums.Sum(context.Background(), from)
+	SetChecksum(db, from, checksum)
+	SetRemoteId(db, from, 5)
+
+	// a copy to another filesystem gets a new inode
+	copied := filepath.Join(root, "exp2", "sample_01.raw")
+	different := filepath.Join(root, "exp2", "sample_02.raw")
+	writeTestFile(t, copied, 10)
+	os.WriteFile(different, []byte("0123456789"), 0o644)
+	os.Remove(from)
+
+	// files of the size of the vanished one wait to be hashed apart from the scan
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil || len(task.MovedFile) != 0 || len(task.NewFile) != 0 {
+		t.Fatalf("Scan() before hashing = %+v, %v, want both files deferred", task, err)
+	}
+	if _, err := scanner.HashPending(context.Background(), location); err != nil {
+		t.Fatalf("HashPending() error: %v", err)
+	}
+	task, err = scanner.Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if len(task.MovedFile) != 1 || task.MovedFile[0].File.FilePath != copied || task.MovedFile[0].File.Id != 5 {
+		t.Fatalf("Scan() moved = %+v, want %s", task.MovedFile, copied)
+	}
+	if len(task.NewFile) != 1 || task.NewFile[0].FilePath != different {
+		t.Errorf("Scan() new = %+v, want %s", task.NewFile, different)
+	}
+	if file, _ := GetFile(db, copied); file.Checksum != checksum {
+		t.Errorf("checksum not kept by the move: %+v", file)
+	}
+	if candidates, err := ListMoveCandidates(db, root); err != nil || len(candidates) != 0 {
+		t.Errorf("ListMoveCandidates() after matching = %+v, %v, want none", candidates, err)
+	}
+}
+
+func TestScanner_MoveAcrossLocations(t *testing.T) {
+	for _, reported := range []bool{false, true} {
+		db := setupTestDB(t)
+		root := t.TempDir()
+		source := FolderWatchingLocation{FolderPath: filepath.Join(root, "astral"), Extensions: "*", IgnoreTerm: "~", Id: 1}
+		target := FolderWatchingLocation{FolderPath: filepath.Join(root, "archive"), Extensions: "*", IgnoreTerm: "~", Id: 2}
+		from := filepath.Join(source.FolderPath, "exp1", "sample_01.raw")
+		to := filepath.Join(target.FolderPath, "exp1", "sample_01.raw")
+		writeTestFile(t, from, 10)
+		os.MkdirAll(target.FolderPath, 0o755)
+		scanner := NewScanner(db)
+		scanner.DeletionGracePeriod = 0
+		scanner.MaxMissingRatio = 1
+		for _, location := range []FolderWatchingLocation{source, target} {
+			if _, err := scanner.Scan(context.Background(), location); err != nil {
+				t.Fatalf("Scan() error: %v", err)
+			}
+		}
+		checksum, _ := scanner.Checksums.Sum(context.Background(), from)
+		SetChecksum(db, from, checksum)
+		SetRemoteId(db, from, 5)
+
+		// copied to the other filesystem, then removed
+		writeTestFile(t, to, 10)
+		os.Remove(from)
+		// keeps the source from looking emptied
+		writeTestFile(t, filepath.Join(source.FolderPath, "exp1", "sample_02.raw"), 11)
+		scans := 1
+		if reported {
+			scans = 2
+		}
+		deleted := 0
+		for i := 0; i < scans; i++ {
+			task, err := scanner.Scan(context.Background(), source)
+			if err != nil {
+				t.Fatalf("Scan() error: %v", err)
+			}
+			deleted += len(task.DeletedFile)
+		}
+		if deleted != scans-1 {
+			t.Fatalf("reported %v: source scans reported %d deletions", reported, deleted)
+		}
+
+		if _, err := scanner.Scan(context.Background(), target); err != nil {
+			t.Fatalf("Scan() error: %v", err)
+		}
+		if _, err := scanner.HashPending(context.Background(), target); err != nil {
+			t.Fatalf("HashPending() error: %v", err)
+		}
+		task, err := scanner.Scan(context.Background(), target)
+		if err != nil {
+			t.Fatalf("Scan() error: %v", err)
+		}
+		if len(task.NewFile) != 0 || len(task.MovedFile) != 1 || task.MovedFile[0].From != from || task.MovedFile[0].File.Id != 5 || task.MovedFile[0].File.FolderWatchingLocation != target.Id {
+			t.Fatalf("reported %v: Scan() of the target = %+v, want the file moved", reported, task)
+		}
+		if file, err := GetFile(db, to); err != nil || file.RemoteId != 5 || file.Checksum != checksum {
+			t.Errorf("reported %v: GetFile(%s) = %+v, %v", reported, to, file, err)
+		}
+		if task, _ := scanner.Scan(context.Background(), source); len(task.DeletedFile) != 0 {
+			t.Errorf("reported %v: source still reports %+v", reported, task.DeletedFile)
+		}
+		db.Close()
+	}
+}
+
+func TestScanner_ScanPathsRename(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	from := filepath.Join(root, "sample_01.raw")
+	to := filepath.Join(root, "sample_01_renamed.raw")
+	writeTestFile(t, from, 10)
+	scanner := NewScanner(db)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	os.Rename(from, to)
+
+	task, err := scanner.ScanPaths(context.Background(), location, []string{from, to})
+	if err != nil || len(task.MovedFile) != 1 || len(task.NewFile) != 0 {
+		t.Fatalf("ScanPaths() = %+v, %v, want one moved file", task, err)
+	}
+	if stability, err := GetStability(db, to); err != nil || stability.UnchangedScans != 1 {
+		t.Errorf("stability of %s = %+v, %v, want the state carried over", to, stability, err)
+	}
+}
+
+func TestOutboxReplayer_FileMove(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	// reported deleted by the location the file left
+	id := server.Add(catapulttest.Files, catapulttest.Record{"file_path": "/data/exp1/a.raw", "size": 10, "experiment": 1, "missing": true})
+	InsertFile(db, LocalFile{Path: "/data/exp2/a.raw", Size: 10})
+
+	move := FileMove{From: "/data/exp1/a.raw", File: File{FilePath: "/data/exp2/a.raw", Size: 10}, ExperimentName: "/data/exp2"}
+	if err := EnqueueFileMove(db, move); err != nil {
+		t.Fatalf("EnqueueFileMove() error: %v", err)
+	}
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), ""))
+	if applied, err := replayer.Replay(context.Background()); err != nil || applied != 1 {
+		t.Fatalf("Replay() = %d, %v", applied, err)
+	}
+
+	experiments := server.Records(catapulttest.Experiments)
+	record, _ := server.Get(catapulttest.Files, id)
+	if len(experiments) != 1 || record["file_path"] != "/data/exp2/a.raw" || record["missing"] != false || fmt.Sprint(record["experiment"]) != fmt.Sprint(experiments[0]["id"]) {
+		t.Errorf("backend file = %v, experiments = %v", record, experiments)
+	}
+	if files := server.Records(catapulttest.Files); len(files) != 1 {
+		t.Errorf("backend files = %v, want the record renamed", files)
+	}
+	if file, _ := GetFile(db, "/data/exp2/a.raw"); file.RemoteId != int64(id) {
+		t.Errorf("local remote id = %d, want %d", file.RemoteId, id)
+	}
+}
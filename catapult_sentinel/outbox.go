@@ -0,0 +1,508 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OutboxFile       = "file"
+	OutboxExperiment = "experiment"
+	OutboxRunConfig  = "run_config"
+	OutboxAlert      = "integrity_alert"
+
+	OutboxCreate = "create"
+	OutboxUpdate = "update"
+	OutboxMove   = "move"
+)
+
+var errMalformedOutboxEntry = errors.New("outbox: malformed entry")
+
+// DefaultOutboxMaxAttempts is the number of failed replays after which an
+// outbox entry is moved to the dead-letter list.
+const DefaultOutboxMaxAttempts = 10
+
+// OutboxEntry is a backend mutation that has not been acknowledged by the
+// backend yet. Payload is the JSON encoded File, Experiment, CatapultRunConfig
+// or IntegrityAlert.
+type OutboxEntry struct {
+	Id        int64  `json:"id"`
+	Kind      string `json:"kind"`
+	Operation string `json:"operation"`
+	DedupeKey string `json:"dedupe_key"`
+	Payload   []byte `json:"payload"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	CreatedAt int64  `json:"created_at"`
+	Dead      bool   `json:"dead"`
+}
+
+// Execer is implemented by *sql.DB and *sql.Tx. The Enqueue functions accept
+// either, so that an entry can be queued in the transaction recording the
+// change it reports.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// EnqueueOutbox stores a pending mutation. A pending entry with the same dedupe
+// key is replaced by the new payload but keeps its position in the queue.
+func EnqueueOutbox(db Execer, kind string, operation string, dedupeKey string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	_, err = db.Exec(`
+	 INSERT INTO outbox (kind, operation, dedupe_key, payload, created_at, updated_at)
+	 VALUES (?, ?, ?, ?, ?, ?)
+	 ON CONFLICT (dedupe_key) WHERE dead = 0
+	 DO UPDATE SET payload = excluded.payload, updated_at = excluded.updated_at`,
+		kind, operation, dedupeKey, string(data), now, now)
+	return err
+}
+
+// FileChange is the payload of file creations and updates. ExperimentName is
+// the experiment a new file belongs to. An empty Checksum keeps the one of the
+// backend unless ClearChecksum is set, as it is once the content changed and
+// the stored hash no longer matches it. File is embedded so that payloads
+// queued as a bare File still decode.
+type FileChange struct {
+	File
+	ExperimentName string `json:"experiment_name,omitempty"`
+	ClearChecksum  bool   `json:"clear_checksum,omitempty"`
+}
+
+func EnqueueFile(db Execer, operation string, file File) error {
+	return EnqueueFileChange(db, operation, FileChange{File: file})
+}
+
+// EnqueueFileChange queues the creation or update of a backend file along
+// with the experiment it belongs to.
+func EnqueueFileChange(db Execer, operation string, change FileChange) error {
+	key := OutboxFile + ":" + operation + ":" + change.FilePath
+	if operation == OutboxUpdate && change.Id != 0 {
+		key = OutboxFile + ":" + operation + ":" + strconv.Itoa(change.Id)
+	}
+	return EnqueueOutbox(db, OutboxFile, operation, key, change)
+}
+
+// EnqueueFileMove queues the rename of a backend file. The payload is the
+// FileMove rather than the File.
+func EnqueueFileMove(db Execer, move FileMove) error {
+	key := OutboxFile + ":" + OutboxMove + ":" + move.From
+	return EnqueueOutbox(db, OutboxFile, OutboxMove, key, move)
+}
+
+func EnqueueExperiment(db Execer, operation string, experiment Experiment) error {
+	key := OutboxExperiment + ":" + operation + ":" + experiment.ExperimentName
+	if operation == OutboxUpdate && experiment.Id != 0 {
+		key = OutboxExperiment + ":" + operation + ":" + strconv.Itoa(experiment.Id)
+	}
+	return EnqueueOutbox(db, OutboxExperiment, operation, key, experiment)
+}
+
+func EnqueueRunConfig(db Execer, operation string, config CatapultRunConfig) error {
+	key := OutboxRunConfig + ":" + operation + ":" + config.ConfigFilePath
+	return EnqueueOutbox(db, OutboxRunConfig, operation, key, config)
+}
+
+// EnqueueRunConfigChange queues the re-sync of an edited cat.yml file. The
+// payload is the RunConfigChange rather than the CatapultRunConfig, and only
+// the latest pending change of a file is kept.
+func EnqueueRunConfigChange(db Execer, change RunConfigChange) error {
+	key := OutboxRunConfig + ":" + OutboxUpdate + ":" + change.Config.ConfigFilePath
+	return EnqueueOutbox(db, OutboxRunConfig, OutboxUpdate, key, change)
+}
+
+// EnqueueIntegrityAlert queues the flagging of the backend file of alert. Only
+// the latest pending alert of a file is kept.
+func EnqueueIntegrityAlert(db Execer, alert IntegrityAlert) error {
+	key := OutboxAlert + ":" + OutboxUpdate + ":" + alert.FilePath
+	return EnqueueOutbox(db, OutboxAlert, OutboxUpdate, key, alert)
+}
+
+func queryOutbox(db *sql.DB, dead bool, afterId int64, limit int) ([]OutboxEntry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := db.Query("SELECT id, kind, operation, dedupe_key, payload, attempts, last_error, created_at, dead FROM outbox WHERE dead = ? AND id > ? ORDER BY id LIMIT ?", dead, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		var payload string
+		err = rows.Scan(&entry.Id, &entry.Kind, &entry.Operation, &entry.DedupeKey, &payload, &entry.Attempts, &entry.LastError, &entry.CreatedAt, &entry.Dead)
+		if err != nil {
+			return nil, err
+		}
+		entry.Payload = []byte(payload)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// PendingOutbox returns up to limit pending entries in the order they were
+// enqueued.
+func PendingOutbox(db *sql.DB, limit int) ([]OutboxEntry, error) {
+	return queryOutbox(db, false, 0, limit)
+}
+
+// DeadLetters returns up to limit entries that exceeded their replay attempts.
+func DeadLetters(db *sql.DB, limit int) ([]OutboxEntry, error) {
+	return queryOutbox(db, true, 0, limit)
+}
+
+// RequeueDeadLetter moves a dead-letter entry back to the end of the pending
+// queue with its attempt counter reset.
+func RequeueDeadLetter(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	var entry OutboxEntry
+	var payload string
+	err = tx.QueryRow("SELECT kind, operation, dedupe_key, payload FROM outbox WHERE id = ? AND dead = 1", id).Scan(&entry.Kind, &entry.Operation, &entry.DedupeKey, &payload)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	now := time.Now().Unix()
+	_, err = tx.Exec("DELETE FROM outbox WHERE id = ?", id)
+	if err == nil {
+		_, err = tx.Exec(`
+		 INSERT INTO outbox (kind, operation, dedupe_key, payload, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT (dedupe_key) WHERE dead = 0 DO NOTHING`,
+			entry.Kind, entry.Operation, entry.DedupeKey, payload, now, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteOutbox(db *sql.DB, id int64) error {
+	_, err := db.Exec("DELETE FROM outbox WHERE id = ?", id)
+	return err
+}
+
+func recordOutboxFailure(db *sql.DB, entry OutboxEntry, cause error, maxAttempts int) (bool, error) {
+	attempts := entry.Attempts + 1
+	dead := attempts >= maxAttempts
+	_, err := db.Exec("UPDATE outbox SET attempts = ?, last_error = ?, updated_at = ?, dead = ? WHERE id = ?", attempts, cause.Error(), time.Now().Unix(), dead, entry.Id)
+	return dead, err
+}
+
+// recordOutboxError keeps the last error of an entry that failed for a
+// transient reason, without counting the attempt.
+func recordOutboxError(db *sql.DB, entry OutboxEntry, cause error) error {
+	_, err := db.Exec("UPDATE outbox SET last_error = ?, updated_at = ? WHERE id = ?", cause.Error(), time.Now().Unix(), entry.Id)
+	return err
+}
+
+// isTransient reports whether err is caused by the backend being unreachable
+// or overloaded rather than by the request itself.
+func isTransient(err error) bool {
+	if errors.Is(err, errMalformedOutboxEntry) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.StatusCode)
+	}
+	return true
+}
+
+// OutboxReplayer drains the outbox against the backend.
+type OutboxReplayer struct {
+	DB          *sql.DB
+	Backend     *CatapultBackend
+	MaxAttempts int
+	BatchSize   int
+}
+
+func NewOutboxReplayer(db *sql.DB, backend *CatapultBackend) *OutboxReplayer {
+	return &OutboxReplayer{DB: db, Backend: backend, MaxAttempts: DefaultOutboxMaxAttempts, BatchSize: 100}
+}
+
+// Replay sends pending entries to the backend in the order they were enqueued
+// and returns how many were applied. Replay stops at the first transient
+// failure so that later entries are not applied ahead of earlier ones, and
+// such failures do not count as attempts; entries rejected by the backend are
+// retried on the next replay until MaxAttempts is reached and they are moved
+// to the dead-letter list. Until then, later entries for the same record are
+// held back so that they do not reach the backend ahead of the rejected one.
+func (r *OutboxReplayer) Replay(ctx context.Context) (int, error) {
+	applied := 0
+	var lastId int64
+	held := make(map[string]bool)
+	for {
+		entries, err := queryOutbox(r.DB, false, lastId, r.BatchSize)
+		if err != nil {
+			return applied, err
+		}
+		if len(entries) == 0 {
+			return applied, nil
+		}
+		for _, entry := range entries {
+			lastId = entry.Id
+			if held[outboxRecord(entry)] {
+				continue
+			}
+			err := r.apply(ctx, entry)
+			if err == nil {
+				if err := deleteOutbox(r.DB, entry.Id); err != nil {
+					return applied, err
+				}
+				applied++
+				continue
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return applied, ctxErr
+			}
+			if isTransient(err) {
+				// the backend is unavailable, not the entry at fault: an outage
+				// must not use up the attempts of the whole queue
+				if dbErr := recordOutboxError(r.DB, entry, err); dbErr != nil {
+					return applied, dbErr
+				}
+				return applied, err
+			}
+			dead, dbErr := recordOutboxFailure(r.DB, entry, err, r.MaxAttempts)
+			if dbErr != nil {
+				return applied, dbErr
+			}
+			if dead {
+				log.Printf("outbox entry %d (%s) moved to dead letters: %v", entry.Id, entry.DedupeKey, err)
+			} else {
+				held[outboxRecord(entry)] = true
+			}
+		}
+	}
+}
+
+// outboxRecord identifies the backend record an entry mutates: its dedupe key
+// without the operation, so that the creation, updates and move of a file
+// share it until the file has a remote id.
+func outboxRecord(entry OutboxEntry) string {
+	kind, rest, _ := strings.Cut(entry.DedupeKey, ":")
+	_, target, _ := strings.Cut(rest, ":")
+	return kind + ":" + target
+}
+
+func (r *OutboxReplayer) apply(ctx context.Context, entry OutboxEntry) error {
+	switch entry.Kind {
+	case OutboxFile:
+		if entry.Operation == OutboxMove {
+			var move FileMove
+			if err := json.Unmarshal(entry.Payload, &move); err != nil {
+				return fmt.Errorf("%w: %v", errMalformedOutboxEntry, err)
+			}
+			return r.applyMove(ctx, move)
+		}
+		var change FileChange
+		if err := json.Unmarshal(entry.Payload, &change); err != nil {
+			return fmt.Errorf("%w: %v", errMalformedOutboxEntry, err)
+		}
+		return r.applyFile(ctx, entry.Operation, change)
+	case OutboxExperiment:
+		var experiment Experiment
+		if err := json.Unmarshal(entry.Payload, &experiment); err != nil {
+			return fmt.Errorf("%w: %v", errMalformedOutboxEntry, err)
+		}
+		return r.applyExperiment(ctx, entry.Operation, experiment)
+	case OutboxRunConfig:
+		if entry.Operation == OutboxUpdate {
+			var change RunConfigChange
+			if err := json.Unmarshal(entry.Payload, &change); err != nil {
+				return fmt.Errorf("%w: %v", errMalformedOutboxEntry, err)
+			}
+			return r.applyRunConfigChange(ctx, change)
+		}
+		var config CatapultRunConfig
+		if err := json.Unmarshal(entry.Payload, &config); err != nil {
+			return fmt.Errorf("%w: %v", errMalformedOutboxEntry, err)
+		}
+		if entry.Operation == OutboxCreate {
+			_, err := r.Backend.CreateCatapultRunConfigContext(ctx, config)
+			return err
+		}
+	case OutboxAlert:
+		var alert IntegrityAlert
+		if err := json.Unmarshal(entry.Payload, &alert); err != nil {
+			return fmt.Errorf("%w: %v", errMalformedOutboxEntry, err)
+		}
+		if entry.Operation == OutboxUpdate {
+			return r.applyIntegrityAlert(ctx, alert)
+		}
+	}
+	return fmt.Errorf("%w: unsupported %s %s", errMalformedOutboxEntry, entry.Kind, entry.Operation)
+}
+
+// applyFile goes through the get-or-create endpoint instead of a plain POST so
+// that replaying an entry the backend already processed does not duplicate the
+// file. Fields the sentinel does not know about are kept from the backend copy.
+// Updates carry the readiness decided by the stability tracker, new files the
+// experiment they belong to.
+func (r *OutboxReplayer) applyFile(ctx context.Context, operation string, change FileChange) error {
+	file := change.File
+	if operation != OutboxCreate && operation != OutboxUpdate {
+		return fmt.Errorf("%w: unsupported file operation %s", errMalformedOutboxEntry, operation)
+	}
+	var remote File
+	var err error
+	if file.Id != 0 {
+		remote, err = r.Backend.GetFileByIdContext(ctx, file.Id)
+	} else {
+		remote, err = r.Backend.GetFileContext(ctx, file.FilePath)
+	}
+	if err != nil {
+		return err
+	}
+	file.Id = remote.Id
+	file.Processing = remote.Processing
+	if operation == OutboxCreate {
+		// a freshly scanned file is not ready yet as far as the sentinel knows,
+		// but must not withdraw a readiness the backend already recorded
+		file.ReadyForProcessing = file.ReadyForProcessing || remote.ReadyForProcessing
+	}
+	if change.ExperimentName != "" {
+		experiment, err := r.Backend.GetExperimentByNameContext(ctx, change.ExperimentName)
+		if err != nil {
+			return err
+		}
+		file.Experiment = experiment.Id
+	}
+	if file.Experiment == 0 {
+		file.Experiment = remote.Experiment
+	}
+	if file.FolderWatchingLocation == 0 {
+		file.FolderWatchingLocation = remote.FolderWatchingLocation
+	}
+	// files are only hashed once ready, other updates carry no checksum
+	if file.Checksum == "" && !change.ClearChecksum {
+		file.Checksum = remote.Checksum
+	}
+	// only new and moved files carry their metadata
+	if file.Metadata == nil {
+		file.Metadata = remote.Metadata
+	}
+	if !file.equal(remote) {
+		if _, err = r.Backend.UpdateFileContext(ctx, file); err != nil {
+			return err
+		}
+	}
+	return SetRemoteId(r.DB, file.FilePath, int64(file.Id))
+}
+
+// applyMove points the backend file at its new path and experiment. The file
+// is found by remote id, or by its old path when the move was detected before
+// the file was ever sent.
+func (r *OutboxReplayer) applyMove(ctx context.Context, move FileMove) error {
+	if move.File.Id == 0 {
+		// set by an earlier replay of this move or of the file creation
+		if local, err := GetFile(r.DB, move.File.FilePath); err == nil {
+			move.File.Id = int(local.RemoteId)
+		}
+	}
+	var remote File
+	var err error
+	if move.File.Id != 0 {
+		remote, err = r.Backend.GetFileByIdContext(ctx, move.File.Id)
+	} else {
+		remote, err = r.Backend.GetFileContext(ctx, move.From)
+	}
+	if err != nil {
+		return err
+	}
+	updated := remote
+	updated.FilePath = move.File.FilePath
+	updated.Size = move.File.Size
+	// the former location may have reported it deleted already
+	updated.Missing = false
+	if move.File.FolderWatchingLocation != 0 {
+		updated.FolderWatchingLocation = move.File.FolderWatchingLocation
+	}
+	if move.File.Metadata != nil {
+		updated.Metadata = move.File.Metadata
+	}
+	if move.ExperimentName != "" {
+		experiment, err := r.Backend.GetExperimentByNameContext(ctx, move.ExperimentName)
+		if err != nil {
+			return err
+		}
+		updated.Experiment = experiment.Id
+	}
+	if !updated.equal(remote) {
+		if _, err = r.Backend.UpdateFileContext(ctx, updated); err != nil {
+			return err
+		}
+	}
+	return SetRemoteId(r.DB, updated.FilePath, int64(updated.Id))
+}
+
+// applyIntegrityAlert records the status of alert in the metadata of the
+// backend file, found by remote id or by path, keeping its other fields.
+func (r *OutboxReplayer) applyIntegrityAlert(ctx context.Context, alert IntegrityAlert) error {
+	var remote File
+	var err error
+	if alert.File != 0 {
+		remote, err = r.Backend.GetFileByIdContext(ctx, alert.File)
+	} else {
+		remote, err = r.Backend.GetFileContext(ctx, alert.FilePath)
+	}
+	if err != nil {
+		return err
+	}
+	flagged := remote
+	flagged.Metadata = maps.Clone(remote.Metadata)
+	if flagged.Metadata == nil {
+		flagged.Metadata = make(map[string]string)
+	}
+	flagged.Metadata[MetadataIntegrityStatus] = alert.Status
+	flagged.Metadata[MetadataIntegrityDetectedAt] = strconv.FormatInt(alert.DetectedAt, 10)
+	if flagged.equal(remote) {
+		return nil
+	}
+	_, err = r.Backend.UpdateFileContext(ctx, flagged)
+	return err
+}
+
+func (r *OutboxReplayer) applyExperiment(ctx context.Context, operation string, experiment Experiment) error {
+	switch operation {
+	case OutboxCreate:
+		remote, err := r.Backend.GetExperimentByNameContext(ctx, experiment.ExperimentName)
+		if err != nil {
+			return err
+		}
+		if experiment.Vendor == "" {
+			experiment.Vendor = remote.Vendor
+		}
+		if experiment.SampleCount == 0 {
+			experiment.SampleCount = remote.SampleCount
+		}
+		experiment.Id = remote.Id
+		if experiment == remote {
+			return nil
+		}
+		_, err = r.Backend.UpdateExperimentContext(ctx, experiment)
+		return err
+	case OutboxUpdate:
+		_, err := r.Backend.UpdateExperimentContext(ctx, experiment)
+		return err
+	}
+	return fmt.Errorf("%w: unsupported experiment operation %s", errMalformedOutboxEntry, operation)
+}
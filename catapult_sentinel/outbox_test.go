@@ -0,0 +1,200 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+// newOutboxTestServer serves the file endpoints used by the replayer and
+// records every updated file.
+func newOutboxTestServer(t *testing.T, updateStatus int) (*httptest.Server, *[]File) {
+	var mu sync.Mutex
+	updated := []File{}
+	ids := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/files/get_exact_path/":
+			var body struct {
+				FilePath string `json:"file_path"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if _, ok := ids[body.FilePath]; !ok {
+				ids[body.FilePath] = len(ids) + 1
+			}
+			json.NewEncoder(w).Encode(File{FilePath: body.FilePath, Id: ids[body.FilePath]})
+		case strings.HasPrefix(r.URL.Path, "/api/files/") && r.Method == "PUT":
+			if updateStatus != http.StatusOK {
+				w.WriteHeader(updateStatus)
+				w.Write([]byte(`{"size": ["Ensure this value is greater than or equal to 0."]}`))
+				return
+			}
+			var file File
+			json.NewDecoder(r.Body).Decode(&file)
+			updated = append(updated, file)
+			json.NewEncoder(w).Encode(file)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, &updated
+}
+
+func TestEnqueueOutboxDedupe(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	EnqueueFile(db, OutboxCreate, File{FilePath: "a.raw", Size: 1})
+	EnqueueFile(db, OutboxCreate, File{FilePath: "b.raw", Size: 1})
+	EnqueueFile(db, OutboxCreate, File{FilePath: "a.raw", Size: 2})
+
+	entries, err := PendingOutbox(db, 10)
+	if err != nil {
+		t.Fatalf("PendingOutbox() error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("PendingOutbox() = %d entries, want 2", len(entries))
+	}
+	var first File
+	json.Unmarshal(entries[0].Payload, &first)
+	if first.FilePath != "a.raw" || first.Size != 2 {
+		t.Errorf("PendingOutbox()[0] = %+v, want a.raw with the latest size first", first)
+	}
+}
+
+func TestOutboxReplayer_Replay(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	InsertFile(db, LocalFile{Path: "a.raw", Size: 10})
+	EnqueueFile(db, OutboxCreate, File{FilePath: "a.raw", Size: 10, FolderWatchingLocation: 1})
+	EnqueueFile(db, OutboxCreate, File{FilePath: "b.raw", Size: 20, FolderWatchingLocation: 1})
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	backend := NewCatapultBackend(down.URL+"/", "token")
+	backend.Retry = nil
+	replayer := NewOutboxReplayer(db, backend)
+	applied, err := replayer.Replay(context.Background())
+	if err == nil || applied != 0 {
+		t.Fatalf("Replay() against a dead backend = %d, %v, want an error", applied, err)
+	}
+	// an unreachable backend is not the entries' fault
+	replayer.MaxAttempts = 1
+	replayer.Replay(context.Background())
+	entries, _ := PendingOutbox(db, 10)
+	if len(entries) != 2 || entries[0].Attempts != 0 || entries[0].LastError == "" || entries[1].LastError != "" {
+		t.Fatalf("PendingOutbox() after failed replays = %+v", entries)
+	}
+
+	server, updated := newOutboxTestServer(t, http.StatusOK)
+	defer server.Close()
+	backend.Url = server.URL + "/"
+	applied, err = replayer.Replay(context.Background())
+	if err != nil || applied != 2 {
+		t.Fatalf("Replay() = %d, %v, want 2 applied", applied, err)
+	}
+	if len(*updated) != 2 || (*updated)[0].FilePath != "a.raw" || (*updated)[1].FilePath != "b.raw" {
+		t.Errorf("Replay() applied %+v, want a.raw then b.raw", *updated)
+	}
+	entries, _ = PendingOutbox(db, 10)
+	if len(entries) != 0 {
+		t.Errorf("PendingOutbox() after replay = %d entries, want 0", len(entries))
+	}
+	local, _ := GetFile(db, "a.raw")
+	if local.RemoteId != 1 {
+		t.Errorf("GetFile() RemoteId = %d, want 1", local.RemoteId)
+	}
+}
+
+func TestOutboxReplayer_DeadLetter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	EnqueueFile(db, OutboxCreate, File{FilePath: "a.raw", Size: -1})
+	EnqueueFile(db, OutboxCreate, File{FilePath: "b.raw", Size: -1})
+
+	server, _ := newOutboxTestServer(t, http.StatusBadRequest)
+	defer server.Close()
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.URL+"/", "token"))
+	replayer.MaxAttempts = 2
+
+	for i := 0; i < 2; i++ {
+		if _, err := replayer.Replay(context.Background()); err != nil {
+			t.Fatalf("Replay() error: %v", err)
+		}
+	}
+	dead, _ := DeadLetters(db, 10)
+	if len(dead) != 2 || dead[0].Attempts != 2 || !strings.Contains(dead[0].LastError, "size") {
+		t.Fatalf("DeadLetters() = %+v, want 2 entries", dead)
+	}
+
+	if err := RequeueDeadLetter(db, dead[0].Id); err != nil {
+		t.Fatalf("RequeueDeadLetter() error: %v", err)
+	}
+	pending, _ := PendingOutbox(db, 10)
+	if len(pending) != 1 || pending[0].Attempts != 0 {
+		t.Errorf("PendingOutbox() after requeue = %+v", pending)
+	}
+}
+
+func TestOutboxReplayer_HoldsRecordAfterRejection(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	server.AddFault(catapulttest.Fault{Path: "/api/files/get_exact_path/", Times: 1, Status: http.StatusBadRequest, Body: `{"size": ["invalid"]}`})
+	EnqueueFile(db, OutboxCreate, File{FilePath: "a.raw", Size: 10})
+	EnqueueFile(db, OutboxCreate, File{FilePath: "b.raw", Size: 20})
+	EnqueueFile(db, OutboxUpdate, File{FilePath: "a.raw", Size: 30})
+
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), ""))
+	if applied, err := replayer.Replay(context.Background()); err != nil || applied != 1 {
+		t.Fatalf("Replay() = %d, %v, want only b.raw applied", applied, err)
+	}
+	pending, _ := PendingOutbox(db, 10)
+	if len(pending) != 2 || pending[0].Attempts != 1 || pending[1].Attempts != 0 || pending[1].LastError != "" {
+		t.Fatalf("PendingOutbox() = %+v, want the update of a.raw held back", pending)
+	}
+
+	if applied, err := replayer.Replay(context.Background()); err != nil || applied != 2 {
+		t.Fatalf("second Replay() = %d, %v, want 2 applied", applied, err)
+	}
+	for _, record := range server.Records(catapulttest.Files) {
+		if record["file_path"] == "a.raw" && fmt.Sprint(record["size"]) != "30" {
+			t.Errorf("a.raw size = %v, want the update applied last", record["size"])
+		}
+	}
+}
+
+func TestOutboxReplayer_ClearChecksum(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	kept := server.Add(catapulttest.Files, catapulttest.Record{"file_path": "a.raw", "checksum": "sha256:aa"})
+	cleared := server.Add(catapulttest.Files, catapulttest.Record{"file_path": "b.raw", "checksum": "sha256:bb"})
+	InsertFile(db, LocalFile{Path: "a.raw"})
+	InsertFile(db, LocalFile{Path: "b.raw"})
+	EnqueueFileChange(db, OutboxUpdate, FileChange{File: File{FilePath: "a.raw", Id: kept, Missing: true}})
+	EnqueueFileChange(db, OutboxUpdate, FileChange{File: File{FilePath: "b.raw", Id: cleared, Size: 20}, ClearChecksum: true})
+
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), ""))
+	if applied, err := replayer.Replay(context.Background()); err != nil || applied != 2 {
+		t.Fatalf("Replay() = %d, %v, want 2 applied", applied, err)
+	}
+	if record, _ := server.Get(catapulttest.Files, kept); record["checksum"] != "sha256:aa" {
+		t.Errorf("checksum without clear = %v, want sha256:aa kept", record["checksum"])
+	}
+	if record, _ := server.Get(catapulttest.Files, cleared); record["checksum"] != "" {
+		t.Errorf("checksum after clear = %v, want none", record["checksum"])
+	}
+}
@@ -0,0 +1,194 @@
+package catapult_sentinel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Page is one page of a paginated Django REST Framework list response.
+type Page[T any] struct {
+	Results  []T    `json:"results"`
+	Next     string `json:"next"`
+	Previous string `json:"previous"`
+	Count    int    `json:"count"`
+}
+
+// Pager walks a list endpoint page by page, following the next links returned
+// by the backend. Endpoints without pagination, which answer with a plain JSON
+// list, are treated as a single page.
+type Pager[T any] struct {
+	backend *CatapultBackend
+	next    string
+	seen    map[string]bool
+	// Count is the total number of records reported by the last page fetched.
+	Count int
+}
+
+func newPager[T any](c *CatapultBackend, endpoint string, params url.Values) *Pager[T] {
+	next := c.Url + endpoint
+	if encoded := params.Encode(); encoded != "" {
+		next += "?" + encoded
+	}
+	return &Pager[T]{backend: c, next: next, seen: make(map[string]bool)}
+}
+
+// Done reports whether every page has been fetched.
+func (p *Pager[T]) Done() bool {
+	return p.next == ""
+}
+
+// NextPage fetches the next page. It returns a nil slice once Done is true.
+func (p *Pager[T]) NextPage(ctx context.Context) ([]T, error) {
+	if p.next == "" {
+		return nil, nil
+	}
+	pageUrl, err := url.Parse(p.next)
+	if err != nil {
+		return nil, err
+	}
+	if !pageUrl.IsAbs() {
+		base, err := url.Parse(p.backend.Url)
+		if err != nil {
+			return nil, err
+		}
+		pageUrl = base.ResolveReference(pageUrl)
+	}
+	if p.seen[pageUrl.String()] {
+		return nil, fmt.Errorf("catapult: pagination loop at %s", pageUrl)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pageUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+p.backend.Token)
+
+	resp, err := p.backend.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(req, resp)
+	}
+
+	var raw json.RawMessage
+	err = json.NewDecoder(resp.Body).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var results []T
+		if err := json.Unmarshal(raw, &results); err != nil {
+			return nil, err
+		}
+		p.next = ""
+		p.Count = len(results)
+		p.backend.Paths.local(results)
+		return results, nil
+	}
+
+	var page Page[T]
+	if err := json.Unmarshal(raw, &page); err != nil {
+		return nil, err
+	}
+	// only pages fetched count, so that a failed page can be fetched again
+	p.seen[pageUrl.String()] = true
+	p.next = page.Next
+	p.Count = page.Count
+	p.backend.Paths.local(page.Results)
+	return page.Results, nil
+}
+
+// All iterates over every record of every remaining page. Breaking out of the
+// loop stops fetching further pages. A failed fetch is yielded once as the
+// error with a zero value and ends the iteration.
+func (p *Pager[T]) All(ctx context.Context) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for !p.Done() {
+			results, err := p.NextPage(ctx)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range results {
+				if !yield(item, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Collect fetches every remaining page and returns all records.
+func (p *Pager[T]) Collect(ctx context.Context) ([]T, error) {
+	var all []T
+	for item, err := range p.All(ctx) {
+		if err != nil {
+			return all, err
+		}
+		all = append(all, item)
+	}
+	return all, nil
+}
+
+// FileFilter narrows ListFiles. Zero values are not sent to the backend.
+type FileFilter struct {
+	FolderWatchingLocation int
+	Experiment             int
+	ReadyForProcessing     *bool
+}
+
+// ExperimentFilter narrows ListExperiments. Zero values are not sent to the
+// backend.
+type ExperimentFilter struct {
+	ExperimentName string
+	Vendor         string
+}
+
+// IterCatapultRunConfig is the paginated counterpart of
+// FilterCatapultRunConfig.
+func (c *CatapultBackend) IterCatapultRunConfig(prefix string, experimentId int) *Pager[CatapultRunConfig] {
+	params := url.Values{}
+	if prefix != "" {
+		params.Add("prefix", c.Paths.ToRemote(prefix))
+	}
+	if experimentId != 0 {
+		params.Add("experiment", strconv.Itoa(experimentId))
+	}
+	return newPager[CatapultRunConfig](c, "api/catapultrunconfig/", params)
+}
+
+func (c *CatapultBackend) ListFiles(filter FileFilter) *Pager[File] {
+	params := url.Values{}
+	if filter.FolderWatchingLocation != 0 {
+		params.Add("folder_watching_location", strconv.Itoa(filter.FolderWatchingLocation))
+	}
+	if filter.Experiment != 0 {
+		params.Add("experiment", strconv.Itoa(filter.Experiment))
+	}
+	if filter.ReadyForProcessing != nil {
+		params.Add("ready_for_processing", strconv.FormatBool(*filter.ReadyForProcessing))
+	}
+	return newPager[File](c, "api/files/", params)
+}
+
+func (c *CatapultBackend) ListExperiments(filter ExperimentFilter) *Pager[Experiment] {
+	params := url.Values{}
+	if filter.ExperimentName != "" {
+		params.Add("experiment_name", c.Paths.ExperimentToRemote(filter.ExperimentName))
+	}
+	if filter.Vendor != "" {
+		params.Add("vendor", filter.Vendor)
+	}
+	return newPager[Experiment](c, "api/experiments/", params)
+}
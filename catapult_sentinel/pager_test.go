@@ -0,0 +1,118 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPager_FollowsNext(t *testing.T) {
+	var requests atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Query().Get("experiment") != "2" {
+			t.Errorf("experiment param = %q, want 2", r.URL.Query().Get("experiment"))
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		resp := Page[CatapultRunConfig]{Count: 5}
+		for i := 0; i < 2 && (page-1)*2+i < 5; i++ {
+			resp.Results = append(resp.Results, CatapultRunConfig{Id: (page-1)*2 + i + 1})
+		}
+		if page < 3 {
+			resp.Next = server.URL + "/api/catapultrunconfig/?experiment=2&page=" + strconv.Itoa(page+1)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	configs, err := c.IterCatapultRunConfig("", 2).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect() error: %v", err)
+	}
+	if len(configs) != 5 || configs[4].Id != 5 {
+		t.Fatalf("Collect() = %+v, want 5 configs", configs)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("Collect() made %d requests, want 3", requests.Load())
+	}
+
+	requests.Store(0)
+	pager := c.IterCatapultRunConfig("", 2)
+	for config, err := range pager.All(context.Background()) {
+		if err != nil {
+			t.Fatalf("All() error: %v", err)
+		}
+		if config.Id == 1 {
+			break
+		}
+	}
+	if requests.Load() != 1 || pager.Done() {
+		t.Errorf("early stop made %d requests, want 1", requests.Load())
+	}
+}
+
+func TestPager_PlainList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/files/" || r.URL.Query().Get("ready_for_processing") != "true" || r.URL.Query().Get("folder_watching_location") != "1" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		json.NewEncoder(w).Encode([]File{{Id: 1}, {Id: 2}})
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	ready := true
+	files, err := c.ListFiles(FileFilter{FolderWatchingLocation: 1, ReadyForProcessing: &ready}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect() error: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Collect() = %+v, want 2 files", files)
+	}
+}
+
+func TestPager_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"detail": "Invalid token."}`))
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	_, err := c.ListExperiments(ExperimentFilter{}).Collect(context.Background())
+	if !IsUnauthorized(err) {
+		t.Errorf("Collect() error = %v, want unauthorized", err)
+	}
+}
+
+func TestPager_RetryPage(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(Page[Experiment]{Count: 1, Results: []Experiment{{Id: 1}}})
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	c.Retry = nil
+	pager := c.ListExperiments(ExperimentFilter{})
+	if _, err := pager.NextPage(context.Background()); err == nil {
+		t.Fatalf("NextPage() during outage succeeded")
+	}
+	experiments, err := pager.NextPage(context.Background())
+	if err != nil || len(experiments) != 1 || !pager.Done() {
+		t.Errorf("NextPage() retry = %+v, %v, want the page", experiments, err)
+	}
+}
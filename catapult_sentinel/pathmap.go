@@ -0,0 +1,208 @@
+package catapult_sentinel
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PathMapping maps the paths under a backend prefix to the paths under a local
+// prefix, such as D:\watch_folder on the backend to /mnt/instruments/astral on
+// a Linux host.
+type PathMapping struct {
+	Remote string
+	Local  string
+	// FoldCase matches the remote prefix regardless of case, as Windows does.
+	// Local prefixes are matched regardless of case when they are Windows
+	// paths themselves.
+	FoldCase bool
+}
+
+// PathMapper translates the paths exchanged with the backend between their
+// backend form and their form on this host. Each side of a mapping uses the
+// separator of its prefix, a backslash for a Windows prefix and a slash
+// otherwise, and separators are converted along with the prefix. The longest
+// matching prefix wins and paths matching no mapping are left alone. A nil
+// mapper leaves every path alone.
+type PathMapper struct {
+	Mappings []PathMapping
+}
+
+// ParsePathMappings parses mappings written remote=local and separated by
+// semicolons, such as "D:\watch_folder=/mnt/instruments/astral". Mappings of a
+// Windows remote prefix fold case unless followed by |exact, others only fold
+// case when followed by |fold.
+func ParsePathMappings(spec string) (*PathMapper, error) {
+	mapper := &PathMapper{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		entry, option, _ := strings.Cut(entry, "|")
+		remote, local, ok := strings.Cut(entry, "=")
+		remote, local = strings.TrimSpace(remote), strings.TrimSpace(local)
+		if !ok || remote == "" || local == "" {
+			return nil, fmt.Errorf("path mapping %q: want remote=local", entry)
+		}
+		mapping := PathMapping{Remote: remote, Local: local, FoldCase: pathSeparator(remote) == '\\'}
+		switch strings.TrimSpace(option) {
+		case "":
+		case "fold":
+			mapping.FoldCase = true
+		case "exact":
+			mapping.FoldCase = false
+		default:
+			return nil, fmt.Errorf("path mapping %q: unknown option %q", entry, option)
+		}
+		mapper.Mappings = append(mapper.Mappings, mapping)
+	}
+	return mapper, nil
+}
+
+// pathSeparator returns the separator used by a path prefix: a backslash for
+// drive letters, UNC paths and paths holding only backslashes.
+func pathSeparator(prefix string) byte {
+	switch {
+	case len(prefix) >= 2 && prefix[1] == ':':
+		return '\\'
+	case strings.Contains(prefix, `\`) && !strings.Contains(prefix, "/"):
+		return '\\'
+	}
+	return '/'
+}
+
+// ToLocal translates a backend path to its path on this host.
+func (m *PathMapper) ToLocal(remote string) string {
+	if m == nil {
+		return remote
+	}
+	return translatePath(remote, m.Mappings, func(mapping PathMapping) (string, string, bool) {
+		return mapping.Remote, mapping.Local, mapping.FoldCase
+	})
+}
+
+// ToRemote translates a path on this host to its backend path.
+func (m *PathMapper) ToRemote(local string) string {
+	if m == nil {
+		return local
+	}
+	return translatePath(local, m.Mappings, func(mapping PathMapping) (string, string, bool) {
+		return mapping.Local, mapping.Remote, pathSeparator(mapping.Local) == '\\'
+	})
+}
+
+// translatePath replaces the longest prefix of path matching one of the
+// mappings, oriented by sides, and converts the separators of the rest.
+func translatePath(path string, mappings []PathMapping, sides func(PathMapping) (from, to string, fold bool)) string {
+	found := false
+	var bestFrom, bestTo string
+	for _, mapping := range mappings {
+		from, to, fold := sides(mapping)
+		if hasPathPrefix(path, from, fold) && (!found || len(from) > len(bestFrom)) {
+			found, bestFrom, bestTo = true, from, to
+		}
+	}
+	if !found {
+		return path
+	}
+
+	fromSep, toSep := pathSeparator(bestFrom), pathSeparator(bestTo)
+	rest := strings.TrimLeft(path[len(strings.TrimRight(bestFrom, `/\`)):], `/\`)
+	if rest == "" {
+		return bestTo
+	}
+	if fromSep == '\\' {
+		// Windows accepts both separators
+		rest = strings.ReplaceAll(rest, "/", `\`)
+	}
+	rest = strings.ReplaceAll(rest, string(fromSep), string(toSep))
+	return strings.TrimRight(bestTo, `/\`) + string(toSep) + rest
+}
+
+// hasPathPrefix reports whether prefix is path or one of its parent
+// directories. A root prefix only matches absolute paths.
+func hasPathPrefix(path, prefix string, fold bool) bool {
+	prefix = strings.TrimRight(prefix, `/\`)
+	if prefix == "" {
+		return strings.HasPrefix(path, "/") || strings.HasPrefix(path, `\`)
+	}
+	if len(path) < len(prefix) {
+		return false
+	}
+	head := path[:len(prefix)]
+	if fold && !strings.EqualFold(head, prefix) || !fold && head != prefix {
+		return false
+	}
+	if len(path) == len(prefix) {
+		return true
+	}
+	sep := path[len(prefix)]
+	return sep == '/' || sep == '\\' && pathSeparator(prefix) == '\\'
+}
+
+// local rewrites the paths held by v, a pointer to a backend record or a
+// slice of records, from their backend form to their local form.
+func (m *PathMapper) local(v any) {
+	if m != nil {
+		rewritePaths(v, m.ToLocal)
+	}
+}
+
+// remote rewrites the paths held by v from their local form to their backend
+// form.
+func (m *PathMapper) remote(v any) {
+	if m != nil {
+		rewritePaths(v, m.ToRemote)
+	}
+}
+
+func rewritePaths(v any, translate func(string) string) {
+	switch v := v.(type) {
+	case []string:
+		for i := range v {
+			v[i] = translate(v[i])
+		}
+	case *File:
+		v.FilePath = translate(v.FilePath)
+	case []File:
+		for i := range v {
+			v[i].FilePath = translate(v[i].FilePath)
+		}
+	case *Experiment:
+		v.ExperimentName = translateExperimentName(v.ExperimentName, translate)
+	case []Experiment:
+		for i := range v {
+			v[i].ExperimentName = translateExperimentName(v[i].ExperimentName, translate)
+		}
+	case *FolderWatchingLocation:
+		v.FolderPath = translate(v.FolderPath)
+	case []FolderWatchingLocation:
+		for i := range v {
+			v[i].FolderPath = translate(v[i].FolderPath)
+		}
+	case *CatapultRunConfig:
+		v.ConfigFilePath = translate(v.ConfigFilePath)
+	case []CatapultRunConfig:
+		for i := range v {
+			v[i].ConfigFilePath = translate(v[i].ConfigFilePath)
+		}
+	}
+}
+
+// ExperimentToRemote translates an experiment name to its backend form.
+func (m *PathMapper) ExperimentToRemote(name string) string {
+	if m == nil {
+		return name
+	}
+	return translateExperimentName(name, m.ToRemote)
+}
+
+// translateExperimentName translates the names of the legacy experiment
+// strategy, which are absolute paths. The names of the other strategies are
+// scoped by location and the same on every host.
+func translateExperimentName(name string, translate func(string) string) string {
+	if !strings.HasPrefix(name, "/") && !strings.HasPrefix(name, `\`) && (len(name) < 2 || name[1] != ':') {
+		return name
+	}
+	return translate(name)
+}
@@ -0,0 +1,119 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func TestPathMapper(t *testing.T) {
+	mapper, err := ParsePathMappings(`D:\watch_folder=/mnt/instruments/astral; D:\watch_folder\MRC-Astral\archive=/mnt/archive; /data=E:\data|fold`)
+	if err != nil {
+		t.Fatalf("ParsePathMappings() error: %v", err)
+	}
+	toLocal := []struct {
+		remote string
+		want   string
+	}{
+		{`D:\watch_folder\MRC-Astral`, "/mnt/instruments/astral/MRC-Astral"},
+		{`d:\Watch_Folder\MRC-Astral\a.raw`, "/mnt/instruments/astral/MRC-Astral/a.raw"},
+		{`D:\watch_folder/MRC-Astral/a.raw`, "/mnt/instruments/astral/MRC-Astral/a.raw"},
+		{`D:\watch_folder`, "/mnt/instruments/astral"},
+		{`D:\watch_folder\MRC-Astral\archive\b.raw`, "/mnt/archive/b.raw"},
+		{`D:\watch_folder2\a.raw`, `D:\watch_folder2\a.raw`},
+		{`C:\other\a.raw`, `C:\other\a.raw`},
+		{"/DATA/exp1/a.raw", `E:\data\exp1\a.raw`},
+		{"/database/a.raw", "/database/a.raw"},
+	}
+	for _, tt := range toLocal {
+		if got := mapper.ToLocal(tt.remote); got != tt.want {
+			t.Errorf("ToLocal(%s) = %s, want %s", tt.remote, got, tt.want)
+		}
+	}
+
+	toRemote := []struct {
+		local string
+		want  string
+	}{
+		{"/mnt/instruments/astral/MRC-Astral/a.raw", `D:\watch_folder\MRC-Astral\a.raw`},
+		{"/mnt/Instruments/astral/a.raw", "/mnt/Instruments/astral/a.raw"},
+		{"/mnt/archive", `D:\watch_folder\MRC-Astral\archive`},
+		{`e:\DATA\exp1\a.raw`, "/data/exp1/a.raw"},
+	}
+	for _, tt := range toRemote {
+		if got := mapper.ToRemote(tt.local); got != tt.want {
+			t.Errorf("ToRemote(%s) = %s, want %s", tt.local, got, tt.want)
+		}
+	}
+
+	root, err := ParsePathMappings(`D:\=/`)
+	if err != nil {
+		t.Fatalf("ParsePathMappings() error: %v", err)
+	}
+	for _, tt := range []struct{ local, want string }{
+		{"/exp1/a.raw", `D:\exp1\a.raw`},
+		{"/", `D:\`},
+		{"exp1", "exp1"},
+	} {
+		if got := root.ToRemote(tt.local); got != tt.want {
+			t.Errorf("root ToRemote(%s) = %s, want %s", tt.local, got, tt.want)
+		}
+	}
+	if got := root.ToLocal(`D:\exp1\a.raw`); got != "/exp1/a.raw" {
+		t.Errorf("root ToLocal() = %s, want /exp1/a.raw", got)
+	}
+	for _, tt := range []struct{ name, want string }{
+		{"3/exp1", "3/exp1"},
+		{"/exp1", `D:\exp1`},
+	} {
+		if got := root.ExperimentToRemote(tt.name); got != tt.want {
+			t.Errorf("ExperimentToRemote(%s) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	var nilMapper *PathMapper
+	if got := nilMapper.ToLocal(`D:\a`); got != `D:\a` {
+		t.Errorf("nil ToLocal() = %s", got)
+	}
+	for _, spec := range []string{"D:\\data", "=/mnt", "D:\\a=/mnt|upper"} {
+		if _, err := ParsePathMappings(spec); err == nil {
+			t.Errorf("ParsePathMappings(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestCatapultBackend_Paths(t *testing.T) {
+	server := catapulttest.NewServer()
+	defer server.Close()
+	server.Add(catapulttest.FolderLocations, catapulttest.Record{"folder_path": `D:\watch_folder`, "extensions": ".raw"})
+	server.Add(catapulttest.Experiments, catapulttest.Record{"experiment_name": `D:\watch_folder\MRC-Astral`})
+
+	c := NewCatapultBackend(server.BaseUrl(), "")
+	c.Paths, _ = ParsePathMappings(`D:\watch_folder=/mnt/instruments/astral`)
+	ctx := context.Background()
+
+	locations, err := c.GetAllFolderWatchingLocationsContext(ctx)
+	if err != nil || len(locations) != 1 || locations[0].FolderPath != "/mnt/instruments/astral" {
+		t.Fatalf("GetAllFolderWatchingLocations() = %+v, %v", locations, err)
+	}
+	experiment, err := c.GetExperimentByNameContext(ctx, "/mnt/instruments/astral/MRC-Astral")
+	if err != nil || experiment.ExperimentName != "/mnt/instruments/astral/MRC-Astral" {
+		t.Fatalf("GetExperimentByName() = %+v, %v", experiment, err)
+	}
+	if experiments := server.Records(catapulttest.Experiments); len(experiments) != 1 {
+		t.Errorf("backend experiments = %v, want the existing one found", experiments)
+	}
+
+	file, err := c.CreateFileContext(ctx, File{FilePath: "/mnt/instruments/astral/MRC-Astral/a.raw", Experiment: experiment.Id})
+	if err != nil || file.FilePath != "/mnt/instruments/astral/MRC-Astral/a.raw" {
+		t.Fatalf("CreateFile() = %+v, %v", file, err)
+	}
+	if record, _ := server.Get(catapulttest.Files, file.Id); record["file_path"] != `D:\watch_folder\MRC-Astral\a.raw` {
+		t.Errorf("backend file path = %v", record["file_path"])
+	}
+	files, err := c.ListFiles(FileFilter{}).Collect(ctx)
+	if err != nil || len(files) != 1 || files[0].FilePath != file.FilePath {
+		t.Errorf("ListFiles() = %+v, %v", files, err)
+	}
+}
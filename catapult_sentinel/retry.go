@@ -0,0 +1,286 @@
+package catapult_sentinel
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("catapult: circuit breaker open, backend unavailable")
+
+// RetryPolicy controls how CatapultBackend retries a failed request.
+// Only idempotent requests (GET, PUT, DELETE) and requests carrying an
+// Idempotency-Key header are retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Jitter is the fraction of each delay that is randomised, between 0 and 1.
+	Jitter float64
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.5,
+	}
+}
+
+// Backoff returns the delay before the given retry, attempt starting at 1.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 && delay > 0 {
+		spread := time.Duration(float64(delay) * p.Jitter)
+		delay = delay - spread + time.Duration(rand.Int64N(int64(spread)+1))
+	}
+	return delay
+}
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker opens after Threshold consecutive failed attempts and rejects
+// every request until Cooldown has passed. A single probe request is then let
+// through; its outcome closes or re-opens the breaker.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown, now: time.Now}
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now == nil {
+		return time.Now()
+	}
+	return b.now()
+}
+
+// State returns the current breaker state, moving an open breaker to
+// half-open once its cooldown has elapsed.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+func (b *CircuitBreaker) advance() {
+	if b.state == BreakerOpen && b.clock().Sub(b.openedAt) >= b.Cooldown {
+		b.state = BreakerHalfOpen
+		b.probing = false
+	}
+}
+
+// Allow returns ErrCircuitOpen when a request should not be sent.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	switch b.state {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	b.state = BreakerClosed
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.clock()
+	}
+}
+
+// Cancel ends a request let through by Allow that was abandoned by its caller
+// before the backend answered. It counts neither as a success nor as a
+// failure, but releases the probe of a half-open breaker.
+func (b *CircuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// BreakerState reports the state of the backend circuit breaker. Watcher loops
+// use it to pause uploads while the backend is unreachable.
+func (c *CatapultBackend) BreakerState() BreakerState {
+	if c.Breaker == nil {
+		return BreakerClosed
+	}
+	return c.Breaker.State()
+}
+
+// CircuitOpen is a shorthand for BreakerState() == BreakerOpen.
+func (c *CatapultBackend) CircuitOpen() bool {
+	return c.BreakerState() == BreakerOpen
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// do sends req through the circuit breaker and retries it according to the
+// backend RetryPolicy. Waiting between attempts stops as soon as the request
+// context is done. The returned response is the last one received, its
+// status code still has to be checked by the caller.
+func (c *CatapultBackend) do(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if c.Retry != nil && c.Retry.MaxAttempts > 1 && isIdempotent(req) {
+		attempts = c.Retry.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if c.Breaker != nil {
+			if err := c.Breaker.Allow(); err != nil {
+				return nil, err
+			}
+		}
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				if c.Breaker != nil {
+					c.Breaker.Cancel()
+				}
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.Client.Do(req)
+		if err != nil && req.Context().Err() != nil {
+			// cancelled or timed out by the caller, not a backend failure
+			if c.Breaker != nil {
+				c.Breaker.Cancel()
+			}
+			return nil, err
+		}
+		failed := err != nil || isRetryableStatus(resp.StatusCode)
+		if c.Breaker != nil {
+			if failed {
+				c.Breaker.Failure()
+			} else {
+				c.Breaker.Success()
+			}
+		}
+		if !failed || attempt >= attempts || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		delay := c.Retry.Backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if retryAfter > c.Retry.MaxDelay {
+					return resp, err
+				}
+				if retryAfter > delay {
+					delay = retryAfter
+				}
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
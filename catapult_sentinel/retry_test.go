@@ -0,0 +1,211 @@
+package catapult_sentinel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+}
+
+func TestCatapultBackend_RetryIdempotent(t *testing.T) {
+	var calls atomic.Int32
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"id": 1, "experiment_name": "exp"}`))
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	c.Retry = testRetryPolicy()
+	got, err := c.GetExperimentByName("exp")
+	if err != nil {
+		t.Fatalf("GetExperimentByName() error = %v", err)
+	}
+	if got.Id != 1 || calls.Load() != 3 {
+		t.Errorf("GetExperimentByName() = %v after %d calls, want id 1 after 3 calls", got, calls.Load())
+	}
+	if keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Errorf("Idempotency-Key not stable across retries: %v", keys)
+	}
+}
+
+func TestCatapultBackend_NoRetryWithoutKey(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	c.Retry = testRetryPolicy()
+	req, _ := http.NewRequest("POST", server.URL+"/api/files/", bytes.NewBufferString("{}"))
+	resp, err := c.do(req)
+	if err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 1 {
+		t.Errorf("do() sent %d requests, want 1", calls.Load())
+	}
+}
+
+func TestCatapultBackend_RetryAfterTooLong(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	c.Retry = testRetryPolicy()
+	_, err := c.GetFileById(1)
+	if err == nil || calls.Load() != 1 {
+		t.Errorf("GetFileById() error = %v after %d calls, want error after 1 call", err, calls.Load())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	if b.State() != BreakerClosed {
+		t.Fatalf("State() = %v, want closed", b.State())
+	}
+	b.Failure()
+	if b.State() != BreakerOpen || b.Allow() != ErrCircuitOpen {
+		t.Fatalf("State() = %v, want open", b.State())
+	}
+
+	now = now.Add(time.Minute)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("State() = %v, want half-open", b.State())
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() probe error = %v", err)
+	}
+	if err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("Allow() second probe error = %v, want ErrCircuitOpen", err)
+	}
+	b.Failure()
+	if b.State() != BreakerOpen {
+		t.Fatalf("State() = %v, want open after failed probe", b.State())
+	}
+
+	now = now.Add(time.Minute)
+	b.Allow()
+	b.Success()
+	if b.State() != BreakerClosed {
+		t.Fatalf("State() = %v, want closed after successful probe", b.State())
+	}
+}
+
+func TestCatapultBackend_CircuitOpen(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	c.Retry = testRetryPolicy()
+	c.Breaker = NewCircuitBreaker(3, time.Hour)
+	c.GetFileById(1)
+	if !c.CircuitOpen() {
+		t.Fatalf("CircuitOpen() = false after %d failures", calls.Load())
+	}
+	_, err := c.GetFileById(1)
+	if err != ErrCircuitOpen || calls.Load() != 3 {
+		t.Errorf("GetFileById() error = %v after %d calls, want ErrCircuitOpen after 3", err, calls.Load())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"Wed, 14 Feb 2024 12:00:10 GMT", 10 * time.Second, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestCatapultBackend_ContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := NewCatapultBackend(server.URL+"/", "token")
+	c.Retry = testRetryPolicy()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.GetFilesContext(ctx, []string{"a.raw"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetFilesContext() error = %v, want deadline exceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("GetFilesContext() returned after %v", time.Since(start))
+	}
+	if c.BreakerState() != BreakerClosed {
+		t.Errorf("BreakerState() = %v, cancelled calls must not trip the breaker", c.BreakerState())
+	}
+}
+
+func TestCatapultBackend_CancelledProbe(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	now := time.Unix(0, 0)
+	c := NewCatapultBackend(server.URL+"/", "token")
+	c.Retry = nil
+	c.Breaker = NewCircuitBreaker(1, time.Minute)
+	c.Breaker.now = func() time.Time { return now }
+	c.Breaker.Failure()
+	now = now.Add(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetFileByIdContext(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetFileByIdContext() error = %v, want deadline exceeded", err)
+	}
+	if err := c.Breaker.Allow(); err != nil {
+		t.Errorf("Allow() after a cancelled probe = %v, want the probe released", err)
+	}
+}
@@ -0,0 +1,478 @@
+package catapult_sentinel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RunConfigVersion is the latest version of the cat.yml schema. Files without
+// a version are read as version 1.
+const RunConfigVersion = 1
+
+// RunConfigSpec is the typed content of a cat.yml run configuration.
+type RunConfigSpec struct {
+	Version  int  `yaml:"version" json:"version"`
+	CatReady bool `yaml:"cat_ready" json:"cat_ready"`
+	// SearchEngine is required once CatReady is set.
+	SearchEngine SearchEngineSpec `yaml:"search_engine" json:"search_engine"`
+	// Fasta lists the protein databases searched, relative to the directory
+	// of the cat.yml file unless absolute.
+	Fasta []string `yaml:"fasta" json:"fasta"`
+	// SpectralLibrary is the spectral library searched, relative to the
+	// directory of the cat.yml file unless absolute.
+	SpectralLibrary string `yaml:"spectral_library" json:"spectral_library"`
+	// Parameters are passed to the search engine as is.
+	Parameters map[string]interface{} `yaml:"parameters" json:"parameters"`
+	Samples    []RunConfigSample      `yaml:"samples" json:"samples"`
+}
+
+type SearchEngineSpec struct {
+	Name    string `yaml:"name" json:"name"`
+	Version string `yaml:"version" json:"version"`
+}
+
+// RunConfigSample is a raw file of the run with its experimental design.
+type RunConfigSample struct {
+	File      string `yaml:"file" json:"file"`
+	Name      string `yaml:"name" json:"name"`
+	Condition string `yaml:"condition" json:"condition"`
+	Replicate int    `yaml:"replicate" json:"replicate"`
+	Fraction  int    `yaml:"fraction" json:"fraction"`
+}
+
+// SearchEngines lists the search engine names accepted in cat.yml files.
+var SearchEngines = []string{"alphadia", "diann", "fragpipe", "maxquant", "msfragger", "spectronaut"}
+
+// FastaExtensions lists the extensions accepted for FASTA files.
+var FastaExtensions = []string{".fasta", ".fa", ".faa", ".fas"}
+
+// RunConfigError is a problem found in a cat.yml file. Line and Column are
+// 1-based, 0 when unknown. Field is the path of the offending value, such as
+// samples[1].replicate.
+type RunConfigError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e RunConfigError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.File)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, ":%d", e.Line)
+		if e.Column > 0 {
+			fmt.Fprintf(&b, ":%d", e.Column)
+		}
+	}
+	b.WriteString(": ")
+	if e.Field != "" {
+		b.WriteString(e.Field + ": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// RunConfigErrors holds every problem found in a cat.yml file, in file order.
+type RunConfigErrors []RunConfigError
+
+func (e RunConfigErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// LoadRunConfig reads and validates the cat.yml file at path.
+func LoadRunConfig(path string) (RunConfigSpec, map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RunConfigSpec{}, nil, err
+	}
+	return ParseRunConfig(path, data)
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+): (.*)`)
+
+// ParseRunConfig decodes and validates a cat.yml file named name. It returns
+// the typed spec and the raw content, which the backend keeps as is. A
+// RunConfigErrors error lists every problem found, with the spec and content
+// decoded as far as possible.
+func ParseRunConfig(name string, data []byte) (RunConfigSpec, map[string]interface{}, error) {
+	var spec RunConfigSpec
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		runErr := RunConfigError{File: name, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+			runErr.Line, _ = strconv.Atoi(match[1])
+			runErr.Message = match[2]
+		}
+		return spec, nil, RunConfigErrors{runErr}
+	}
+	content, ok := normalizeYAML(raw).(map[string]interface{})
+	if raw != nil && !ok {
+		return spec, nil, RunConfigErrors{{File: name, Line: 1, Column: 1, Message: "want a mapping at the top level"}}
+	}
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+
+	v := &runConfigValidator{file: name, positions: yamlPositions(data)}
+	v.walk(content, reflect.TypeOf(spec), "")
+	if len(v.errs) == 0 {
+		// the walk checked every type, decoding cannot fail
+		if err := yaml.Unmarshal(data, &spec); err != nil {
+			v.errs = append(v.errs, RunConfigError{File: name, Message: err.Error()})
+		}
+		// yaml decodes nested mappings with interface{} keys
+		spec.Parameters, _ = content["parameters"].(map[string]interface{})
+		v.check(spec)
+	}
+	if spec.Version == 0 {
+		spec.Version = 1
+	}
+	if len(v.errs) > 0 {
+		sort.SliceStable(v.errs, func(i, j int) bool {
+			return v.errs[i].Line < v.errs[j].Line || v.errs[i].Line == v.errs[j].Line && v.errs[i].Column < v.errs[j].Column
+		})
+		return spec, content, v.errs
+	}
+	return spec, content, nil
+}
+
+// normalizeYAML turns the maps decoded by yaml into JSON friendly
+// map[string]interface{}.
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeYAML(v[i])
+		}
+		return v
+	}
+	return v
+}
+
+type runConfigValidator struct {
+	file      string
+	positions map[string]yamlPosition
+	errs      RunConfigErrors
+}
+
+// errorf records a problem with the value at field, positioned at the value
+// itself or at its nearest enclosing value found in the file.
+func (v *runConfigValidator) errorf(field string, format string, args ...interface{}) {
+	v.errorAt(field, field, format, args...)
+}
+
+// errorAt records a problem with the value at field, positioned at the value
+// at, or its nearest enclosing value, for values missing from the file.
+func (v *runConfigValidator) errorAt(at string, field string, format string, args ...interface{}) {
+	err := RunConfigError{File: v.file, Field: field, Message: fmt.Sprintf(format, args...)}
+	for path := at; ; path = parentField(path) {
+		if pos, ok := v.positions[path]; ok {
+			err.Line, err.Column = pos.line, pos.column
+			break
+		}
+		if path == "" {
+			break
+		}
+	}
+	v.errs = append(v.errs, err)
+}
+
+// parentField returns the field holding field, such as samples[1] for
+// samples[1].file and samples for samples[1].
+func parentField(field string) string {
+	i := strings.LastIndexAny(field, ".[")
+	if i < 0 {
+		return ""
+	}
+	return field[:i]
+}
+
+func joinField(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// walk checks that value can be decoded into a value of type t, reporting
+// unknown keys and mismatched types.
+func (v *runConfigValidator) walk(value interface{}, t reflect.Type, field string) {
+	if value == nil {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+	case reflect.String:
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			v.errorf(field, "want a string, got %s", yamlKind(value))
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			v.errorf(field, "want true or false, got %s", yamlKind(value))
+		}
+	case reflect.Int:
+		if _, ok := value.(int); !ok {
+			v.errorf(field, "want an integer, got %s", yamlKind(value))
+		}
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			v.errorf(field, "want a list, got %s", yamlKind(value))
+			return
+		}
+		for i, item := range items {
+			v.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", field, i))
+		}
+	case reflect.Map:
+		if _, ok := value.(map[string]interface{}); !ok {
+			v.errorf(field, "want a mapping, got %s", yamlKind(value))
+		}
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			v.errorf(field, "want a mapping, got %s", yamlKind(value))
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		var names []string
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			fields[name] = t.Field(i).Type
+			names = append(names, name)
+		}
+		for key, item := range m {
+			ft, ok := fields[key]
+			if !ok {
+				v.errorf(joinField(field, key), "unknown field, want one of %s", strings.Join(names, ", "))
+				continue
+			}
+			v.walk(item, ft, joinField(field, key))
+		}
+	}
+}
+
+func yamlKind(value interface{}) string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return "a mapping"
+	case []interface{}:
+		return "a list"
+	case string:
+		return strconv.Quote(value)
+	}
+	return fmt.Sprint(value)
+}
+
+// check validates the decoded spec.
+func (v *runConfigValidator) check(spec RunConfigSpec) {
+	if spec.Version < 0 || spec.Version > RunConfigVersion {
+		v.errorf("version", "unsupported version %d, the latest is %d", spec.Version, RunConfigVersion)
+	}
+	if engine := spec.SearchEngine.Name; engine != "" {
+		known := false
+		for _, name := range SearchEngines {
+			known = known || strings.EqualFold(engine, name)
+		}
+		if !known {
+			v.errorf("search_engine.name", "unknown search engine %q, want one of %s", engine, strings.Join(SearchEngines, ", "))
+		}
+	} else if spec.CatReady {
+		at := "search_engine"
+		if _, ok := v.positions[at]; !ok {
+			at = "cat_ready"
+		}
+		v.errorAt(at, "search_engine.name", "required when cat_ready is true")
+	}
+	for i, fasta := range spec.Fasta {
+		field := fmt.Sprintf("fasta[%d]", i)
+		if fasta == "" {
+			v.errorf(field, "empty path")
+			continue
+		}
+		known := false
+		for _, ext := range FastaExtensions {
+			known = known || strings.EqualFold(filepath.Ext(fasta), ext)
+		}
+		if !known {
+			v.errorf(field, "%s is not a FASTA file, want one of %s", fasta, strings.Join(FastaExtensions, ", "))
+		}
+	}
+	files := make(map[string]int)
+	for i, sample := range spec.Samples {
+		field := fmt.Sprintf("samples[%d]", i)
+		if sample.File == "" {
+			v.errorf(field+".file", "required")
+		} else if first, ok := files[sample.File]; ok {
+			v.errorf(field+".file", "%s already listed by samples[%d]", sample.File, first)
+		} else {
+			files[sample.File] = i
+		}
+		if sample.Replicate < 0 {
+			v.errorf(field+".replicate", "negative replicate %d", sample.Replicate)
+		}
+		if sample.Fraction < 0 {
+			v.errorf(field+".fraction", "negative fraction %d", sample.Fraction)
+		}
+	}
+}
+
+type yamlPosition struct {
+	line, column int
+}
+
+// yamlPositions returns the position of the keys and list items of a block
+// style YAML document, keyed by their field path. Values written in flow style
+// or as block scalars are skipped; the validator then reports the position of
+// their enclosing key.
+func yamlPositions(data []byte) map[string]yamlPosition {
+	type frame struct {
+		indent int
+		field  string
+		item   bool
+	}
+	positions := make(map[string]yamlPosition)
+	items := make(map[string]int)
+	var stack []frame
+	parent := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		return stack[len(stack)-1].field
+	}
+	skipIndent := -1
+	flowDepth := 0
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		if flowDepth > 0 {
+			flowDepth += flowBalance(content)
+			continue
+		}
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		if skipIndent >= 0 {
+			if indent > skipIndent {
+				continue
+			}
+			skipIndent = -1
+		}
+		if content == "---" || content == "..." {
+			continue
+		}
+
+		column := indent
+		for strings.HasPrefix(content, "- ") || content == "-" {
+			for len(stack) > 0 && (stack[len(stack)-1].indent > column || stack[len(stack)-1].indent == column && stack[len(stack)-1].item) {
+				stack = stack[:len(stack)-1]
+			}
+			list := parent()
+			field := fmt.Sprintf("%s[%d]", list, items[list])
+			items[list]++
+			positions[field] = yamlPosition{n + 1, column + 1}
+			stack = append(stack, frame{column, field, true})
+			rest := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+			column += len(content) - len(rest)
+			content = rest
+		}
+		if content == "" {
+			continue
+		}
+
+		key, value, ok := splitYAMLKey(content)
+		if !ok {
+			flowDepth = flowBalance(content)
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= column {
+			stack = stack[:len(stack)-1]
+		}
+		field := joinField(parent(), key)
+		positions[field] = yamlPosition{n + 1, column + 1}
+		stack = append(stack, frame{column, field, false})
+		switch {
+		case strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"):
+			skipIndent = column
+		case strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{"):
+			flowDepth = flowBalance(value)
+		}
+	}
+	return positions
+}
+
+// splitYAMLKey splits a "key: value" line, unquoting the key.
+func splitYAMLKey(content string) (key string, value string, ok bool) {
+	if content[0] == '"' || content[0] == '\'' {
+		end := strings.IndexByte(content[1:], content[0])
+		if end < 0 || !strings.HasPrefix(content[end+2:], ":") {
+			return "", "", false
+		}
+		key, content = content[1:end+1], content[end+2:]
+	} else {
+		i := strings.Index(content, ":")
+		for i >= 0 && i+1 < len(content) && content[i+1] != ' ' && content[i+1] != '\t' {
+			next := strings.Index(content[i+1:], ":")
+			if next < 0 {
+				i = -1
+				break
+			}
+			i += next + 1
+		}
+		if i < 0 || strings.HasPrefix(content, "[") || strings.HasPrefix(content, "{") {
+			return "", "", false
+		}
+		key, content = strings.TrimSpace(content[:i]), content[i:]
+	}
+	value = strings.TrimSpace(strings.TrimPrefix(content, ":"))
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return key, value, true
+}
+
+// flowBalance returns the number of flow collections a line opens minus the
+// number it closes, ignoring quoted text.
+func flowBalance(content string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || content[i-1] == ' '):
+			return depth
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth
+}
@@ -0,0 +1,110 @@
+package catapult_sentinel
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const validRunConfig = `# HeLa search
+version: 1
+cat_ready: true
+search_engine:
+  name: DIANN
+  version: "1.8.1"
+fasta:
+  - human.fasta
+  - contaminants.fa
+spectral_library: predicted.speclib
+parameters:
+  threads: 16
+  mass_acc:
+    ms1: 10
+samples:
+- file: HeLa_01.raw
+  condition: control
+  replicate: 1
+- file: HeLa_02.raw
+  condition: control
+  replicate: 2
+`
+
+func TestParseRunConfig(t *testing.T) {
+	spec, content, err := ParseRunConfig("run.cat.yml", []byte(validRunConfig))
+	if err != nil {
+		t.Fatalf("ParseRunConfig() error: %v", err)
+	}
+	if !spec.CatReady || spec.SearchEngine.Name != "DIANN" || spec.SearchEngine.Version != "1.8.1" || len(spec.Fasta) != 2 ||
+		spec.SpectralLibrary != "predicted.speclib" || len(spec.Samples) != 2 || spec.Samples[1].Replicate != 2 {
+		t.Errorf("ParseRunConfig() spec = %+v", spec)
+	}
+	if content["cat_ready"] != true {
+		t.Errorf("ParseRunConfig() content = %v", content)
+	}
+	// both forms are sent to the backend as JSON
+	if _, err := json.Marshal(CatapultRunConfig{Content: content, Spec: &spec}); err != nil {
+		t.Errorf("json.Marshal() error: %v", err)
+	}
+
+	spec, _, err = ParseRunConfig("old.cat.yml", []byte("cat_ready: false\n"))
+	if err != nil || spec.Version != 1 {
+		t.Errorf("ParseRunConfig() without version = %+v, %v", spec, err)
+	}
+}
+
+func TestParseRunConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []RunConfigError
+	}{
+		{
+			"unknown fields",
+			"cat_ready: true\nsearch_engine:\n  name: diann\n  verison: 1.8\nsampels: []\n",
+			[]RunConfigError{
+				{Line: 4, Column: 3, Field: "search_engine.verison"},
+				{Line: 5, Column: 1, Field: "sampels"},
+			},
+		},
+		{
+			"types",
+			"cat_ready: yes please\nsamples:\n- file: a.raw\n  replicate: two\n- file:\n    name: b.raw\n",
+			[]RunConfigError{
+				{Line: 1, Column: 1, Field: "cat_ready"},
+				{Line: 4, Column: 3, Field: "samples[0].replicate"},
+				{Line: 5, Column: 3, Field: "samples[1].file"},
+			},
+		},
+		{
+			"values",
+			"version: 3\ncat_ready: true\nfasta: [human.fasta, human.txt]\nsamples:\n  - file: a.raw\n  - name: b\n  - file: a.raw\n",
+			[]RunConfigError{
+				{Line: 1, Column: 1, Field: "version"},
+				{Line: 2, Column: 1, Field: "search_engine.name"},
+				{Line: 3, Column: 1, Field: "fasta[1]"},
+				{Line: 6, Column: 3, Field: "samples[1].file"},
+				{Line: 7, Column: 5, Field: "samples[2].file"},
+			},
+		},
+		{
+			"syntax",
+			"cat_ready: true\nsearch_engine:\n  name: diann\n bad: indent\n",
+			// yaml reports the line of the mapping it was parsing
+			[]RunConfigError{{Line: 3}},
+		},
+	}
+	for _, tt := range tests {
+		_, _, err := ParseRunConfig("run.cat.yml", []byte(tt.config))
+		var errs RunConfigErrors
+		if !errors.As(err, &errs) || len(errs) != len(tt.want) {
+			t.Errorf("%s: ParseRunConfig() error = %v, want %d errors", tt.name, err, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			got := errs[i]
+			if got.Line != want.Line || got.Column != want.Column || got.Field != want.Field || got.File != "run.cat.yml" {
+				t.Errorf("%s: error %d = %v, want %d:%d %s", tt.name, i, got, want.Line, want.Column, want.Field)
+			}
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ScrubOK         = "ok"
+	ScrubCorrupt    = "corrupt"
+	ScrubUnreadable = "unreadable"
+	// ScrubSkipped is recorded for files modified since they were hashed; the
+	// next scan picks them up as changed.
+	ScrubSkipped = "skipped"
+)
+
+const (
+	// DefaultScrubBytesPerSecond is lower than the hashing budget of new files
+	// since scrubbing competes with acquisitions for the whole catalogue.
+	DefaultScrubBytesPerSecond = 20 << 20
+	DefaultScrubBatchSize      = 100
+	DefaultScrubMaxRunTime     = 5 * time.Minute
+)
+
+// ScrubResult is one verification of a stored checksum.
+type ScrubResult struct {
+	Path       string `json:"path"`
+	ScrubbedAt int64  `json:"scrubbed_at"`
+	Status     string `json:"status"`
+	Expected   string `json:"expected"`
+	Actual     string `json:"actual"`
+	Error      string `json:"error"`
+}
+
+// Metadata keys flagging a backend file that failed an integrity check.
+const (
+	MetadataIntegrityStatus     = "integrity_status"
+	MetadataIntegrityDetectedAt = "integrity_detected_at"
+)
+
+// IntegrityAlert reports a file whose content no longer matches its checksum
+// although it was not modified, or that can no longer be read. The backend has
+// no endpoint for alerts, so they are reported by flagging the metadata of the
+// backend file, see EnqueueIntegrityAlert.
+type IntegrityAlert struct {
+	FilePath         string `json:"file_path"`
+	File             int    `json:"file"`
+	Status           string `json:"status"`
+	ExpectedChecksum string `json:"expected_checksum"`
+	ActualChecksum   string `json:"actual_checksum"`
+	Error            string `json:"error"`
+	DetectedAt       int64  `json:"detected_at"`
+}
+
+// ScrubReport summarizes one scrubber run.
+type ScrubReport struct {
+	Checked int
+	// Bytes is the size of the files hashed.
+	Bytes  int64
+	Alerts []IntegrityAlert
+}
+
+func InsertScrubResult(db Execer, result ScrubResult) error {
+	_, err := db.Exec("INSERT INTO scrub_history (path, scrubbed_at, status, expected, actual, error) VALUES (?, ?, ?, ?, ?, ?)",
+		result.Path, result.ScrubbedAt, result.Status, result.Expected, result.Actual, result.Error)
+	if err != nil {
+		return err
+	}
+	return markScrubbed(db, result.Path, result.ScrubbedAt)
+}
+
+// markScrubbed moves path behind the files verified before scrubbedAt in the
+// scrub candidates.
+func markScrubbed(db Execer, path string, scrubbedAt int64) error {
+	_, err := db.Exec("UPDATE files SET scrubbed_at = ? WHERE path = ?", scrubbedAt, path)
+	return err
+}
+
+// GetScrubHistory returns the verifications of path, the most recent first.
+func GetScrubHistory(db *sql.DB, path string) ([]ScrubResult, error) {
+	rows, err := db.Query("SELECT path, scrubbed_at, status, expected, actual, error FROM scrub_history WHERE path = ? ORDER BY id DESC", path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []ScrubResult
+	for rows.Next() {
+		var result ScrubResult
+		if err := rows.Scan(&result.Path, &result.ScrubbedAt, &result.Status, &result.Expected, &result.Actual, &result.Error); err != nil {
+			return nil, err
+		}
+		history = append(history, result)
+	}
+	return history, rows.Err()
+}
+
+// listScrubCandidates returns up to limit hashed files, those verified the
+// longest time ago first.
+func listScrubCandidates(db *sql.DB, limit int) ([]LocalFile, error) {
+	rows, err := db.Query("SELECT path, size, is_folder, last_modified, remote_id, checksum, device, inode FROM files WHERE checksum != '' ORDER BY scrubbed_at, path LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []LocalFile
+	for rows.Next() {
+		var file LocalFile
+		if err := rows.Scan(&file.Path, &file.Size, &file.IsFolder, &file.LastModified, &file.RemoteId, &file.Checksum, &file.Device, &file.Inode); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// ScrubWindow is a daily time range, as offsets from local midnight. A window
+// whose End is before its Start runs over midnight.
+type ScrubWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseScrubWindows parses comma separated "HH:MM-HH:MM" ranges.
+func ParseScrubWindows(s string) ([]ScrubWindow, error) {
+	var windows []ScrubWindow
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("scrub window %q is not HH:MM-HH:MM", part)
+		}
+		var window ScrubWindow
+		var err error
+		if window.Start, err = parseClock(start); err == nil {
+			window.End, err = parseClock(end)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("scrub window %q: %w", part, err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// remaining returns how long the window stays open after t, 0 when t is
+// outside the window.
+func (w ScrubWindow) remaining(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	switch {
+	case w.Start == w.End:
+		return 0
+	case w.Start < w.End:
+		if offset >= w.Start && offset < w.End {
+			return w.End - offset
+		}
+	case offset >= w.Start:
+		return 24*time.Hour - offset + w.End
+	case offset < w.End:
+		return w.End - offset
+	}
+	return 0
+}
+
+// Scrubber periodically re-hashes stored files and compares the result with
+// the checksum recorded when they became ready. Each run verifies the files
+// checked the longest time ago, reads at most Checksums.Limiter bytes per
+// second and only runs inside Windows, or at any time when there are none.
+type Scrubber struct {
+	DB        *sql.DB
+	Checksums *Checksummer
+	Vendors   *VendorRegistry
+	Windows   []ScrubWindow
+	// BatchSize is the number of files verified per run and MaxRunTime bounds
+	// the length of a run.
+	BatchSize  int
+	MaxRunTime time.Duration
+	// Outbox queues the integrity alerts in the transaction recording their
+	// scrub result.
+	Outbox bool
+
+	now func() time.Time
+}
+
+func NewScrubber(db *sql.DB, bytesPerSecond int64) *Scrubber {
+	return &Scrubber{
+		DB:         db,
+		Checksums:  NewChecksummer(ChecksumSHA256, bytesPerSecond),
+		Vendors:    DefaultVendorRegistry(),
+		BatchSize:  DefaultScrubBatchSize,
+		MaxRunTime: DefaultScrubMaxRunTime,
+		now:        time.Now,
+	}
+}
+
+func (s *Scrubber) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+// window returns how long scrubbing may run from now, 0 when outside every
+// window.
+func (s *Scrubber) window(now time.Time) time.Duration {
+	limit := s.MaxRunTime
+	if limit <= 0 {
+		limit = DefaultScrubMaxRunTime
+	}
+	if len(s.Windows) == 0 {
+		return limit
+	}
+	var open time.Duration
+	for _, w := range s.Windows {
+		open = max(open, w.remaining(now))
+	}
+	return min(open, limit)
+}
+
+// Run verifies the next batch of files. Files that are gone are left to the
+// scanner, without a result, but still wait for their next turn like the files
+// verified, so that the missing files of an unmounted location do not keep the
+// rest of the catalogue from being scrubbed. Files modified since they were
+// hashed are only recorded as skipped. A run interrupted by the end of its
+// window returns what it verified so far without error.
+func (s *Scrubber) Run(ctx context.Context) (ScrubReport, error) {
+	var report ScrubReport
+	open := s.window(s.clock())
+	if open <= 0 {
+		return report, nil
+	}
+	runCtx, cancel := context.WithTimeout(ctx, open)
+	defer cancel()
+
+	batch := s.BatchSize
+	if batch <= 0 {
+		batch = DefaultScrubBatchSize
+	}
+	files, err := listScrubCandidates(s.DB, batch)
+	if err != nil {
+		return report, err
+	}
+	for _, file := range files {
+		result, ok := s.verify(runCtx, file)
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		if runCtx.Err() != nil {
+			return report, nil
+		}
+		if !ok {
+			if err := markScrubbed(s.DB, result.Path, result.ScrubbedAt); err != nil {
+				return report, err
+			}
+			continue
+		}
+		var alert *IntegrityAlert
+		if result.Status == ScrubCorrupt || result.Status == ScrubUnreadable {
+			alert = &IntegrityAlert{
+				FilePath:         file.Path,
+				File:             int(file.RemoteId),
+				Status:           result.Status,
+				ExpectedChecksum: result.Expected,
+				ActualChecksum:   result.Actual,
+				Error:            result.Error,
+				DetectedAt:       result.ScrubbedAt,
+			}
+		}
+		if err := s.record(result, alert); err != nil {
+			return report, err
+		}
+		report.Checked++
+		if result.Status == ScrubOK || result.Status == ScrubCorrupt {
+			report.Bytes += file.Size
+		}
+		if alert != nil {
+			report.Alerts = append(report.Alerts, *alert)
+		}
+	}
+	return report, nil
+}
+
+// record stores result and, when Outbox is set, queues alert in a single
+// transaction.
+func (s *Scrubber) record(result ScrubResult, alert *IntegrityAlert) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := InsertScrubResult(tx, result); err != nil {
+		return err
+	}
+	if alert != nil && s.Outbox {
+		if err := EnqueueIntegrityAlert(tx, *alert); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// verify hashes file with the algorithm its checksum was made with. It
+// returns false for files that no longer exist.
+func (s *Scrubber) verify(ctx context.Context, file LocalFile) (ScrubResult, bool) {
+	result := ScrubResult{Path: file.Path, ScrubbedAt: s.clock().Unix(), Expected: file.Checksum}
+	size, lastModified, err := s.stat(ctx, file.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return result, false
+	}
+	if err != nil {
+		result.Status = ScrubUnreadable
+		result.Error = err.Error()
+		return result, true
+	}
+	if size != file.Size || lastModified != file.LastModified {
+		result.Status = ScrubSkipped
+		return result, true
+	}
+
+	algorithm, _, _ := strings.Cut(file.Checksum, ":")
+	checksummer := *s.Checksums
+	checksummer.Algorithm = algorithm
+	actual, err := checksummer.SumAcquisition(ctx, file.Path, s.Vendors.CompanionPaths(file.Path))
+	switch {
+	case err != nil:
+		result.Status = ScrubUnreadable
+		result.Error = err.Error()
+	case actual != file.Checksum:
+		result.Status = ScrubCorrupt
+		result.Actual = actual
+	default:
+		result.Status = ScrubOK
+		result.Actual = actual
+	}
+	return result, true
+}
+
+// stat returns the size and modification time of the acquisition at path the
+// way the scanner records them, companion files included.
+func (s *Scrubber) stat(ctx context.Context, path string) (int64, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, lastModified := info.Size(), info.ModTime().Unix()
+	if info.IsDir() {
+		stat, err := walkFolderStat(ctx, defaultWalker, path, info)
+		if err != nil {
+			return 0, 0, err
+		}
+		size, lastModified = stat.Size, stat.LastModified
+	}
+	companions := make(map[string]os.FileInfo)
+	for _, companion := range s.Vendors.CompanionPaths(path) {
+		if info, err := os.Stat(companion); err == nil {
+			companions[companion] = info
+		}
+	}
+	size, lastModified = addCompanions(size, lastModified, companions)
+	return size, lastModified, nil
+}
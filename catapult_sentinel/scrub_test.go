@@ -0,0 +1,211 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func TestParseScrubWindows(t *testing.T) {
+	windows, err := ParseScrubWindows("22:00-06:00, 12:00-13:30")
+	if err != nil || len(windows) != 2 {
+		t.Fatalf("ParseScrubWindows() = %v, %v", windows, err)
+	}
+	tests := []struct {
+		window ScrubWindow
+		clock  string
+		want   time.Duration
+	}{
+		{windows[0], "23:00", 7 * time.Hour},
+		{windows[0], "05:00", time.Hour},
+		{windows[0], "06:00", 0},
+		{windows[0], "12:00", 0},
+		{windows[1], "12:45", 45 * time.Minute},
+		{windows[1], "11:59", 0},
+	}
+	for _, tt := range tests {
+		clock, _ := time.Parse("15:04", tt.clock)
+		now := time.Date(2024, 3, 1, clock.Hour(), clock.Minute(), 0, 0, time.Local)
+		if got := tt.window.remaining(now); got != tt.want {
+			t.Errorf("%+v remaining at %s = %v, want %v", tt.window, tt.clock, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"22:00", "25:00-01:00", "a-b"} {
+		if _, err := ParseScrubWindows(bad); err == nil {
+			t.Errorf("ParseScrubWindows(%q) succeeded", bad)
+		}
+	}
+}
+
+// hashedFile writes a file and records it as hashed, the way the scanner does
+// once the file is ready.
+func hashedFile(t *testing.T, scrubber *Scrubber, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	checksum, err := scrubber.Checksums.Sum(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Sum() error: %v", err)
+	}
+	file := LocalFile{Path: path, Size: info.Size(), LastModified: info.ModTime().Unix(), RemoteId: 7, Checksum: checksum}
+	if err := InsertFile(scrubber.DB, file); err != nil {
+		t.Fatalf("InsertFile() error: %v", err)
+	}
+}
+
+func TestScrubber_Run(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	scrubber := NewScrubber(db, 0)
+	for _, name := range []string{"ok.raw", "corrupt.raw", "modified.raw", "deleted.raw"} {
+		hashedFile(t, scrubber, filepath.Join(root, name), "acquisition")
+	}
+
+	// bit rot: same size and mtime, different content
+	corrupt := filepath.Join(root, "corrupt.raw")
+	info, _ := os.Stat(corrupt)
+	os.WriteFile(corrupt, []byte("acquisitioN"), 0o644)
+	os.Chtimes(corrupt, info.ModTime(), info.ModTime())
+
+	modified := filepath.Join(root, "modified.raw")
+	later := time.Now().Add(time.Hour)
+	os.WriteFile(modified, []byte("another acquisition"), 0o644)
+	os.Chtimes(modified, later, later)
+	os.Remove(filepath.Join(root, "deleted.raw"))
+
+	report, err := scrubber.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if report.Checked != 3 || report.Bytes != 22 {
+		t.Errorf("Run() checked %d files and %d bytes, want 3 and 22", report.Checked, report.Bytes)
+	}
+	if len(report.Alerts) != 1 || report.Alerts[0].FilePath != corrupt || report.Alerts[0].Status != ScrubCorrupt || report.Alerts[0].File != 7 {
+		t.Fatalf("Run() alerts = %+v, want corrupt.raw", report.Alerts)
+	}
+
+	want := map[string]string{"ok.raw": ScrubOK, "corrupt.raw": ScrubCorrupt, "modified.raw": ScrubSkipped}
+	for name, status := range want {
+		history, err := GetScrubHistory(db, filepath.Join(root, name))
+		if err != nil || len(history) != 1 || history[0].Status != status {
+			t.Errorf("GetScrubHistory(%s) = %+v, %v, want %s", name, history, err, status)
+		}
+	}
+	if history, _ := GetScrubHistory(db, filepath.Join(root, "deleted.raw")); len(history) != 0 {
+		t.Errorf("deleted file scrubbed: %+v", history)
+	}
+}
+
+func TestScrubber_AlertFlagsBackendFile(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	root := t.TempDir()
+	corrupt := filepath.Join(root, "sample_01.raw")
+	id := server.Add(catapulttest.Files, catapulttest.Record{"file_path": corrupt, "metadata": map[string]string{"sample": "01"}})
+	scrubber := NewScrubber(db, 0)
+	scrubber.Outbox = true
+	hashedFile(t, scrubber, corrupt, "acquisition")
+	SetRemoteId(db, corrupt, int64(id))
+	info, _ := os.Stat(corrupt)
+	os.WriteFile(corrupt, []byte("acquisitioN"), 0o644)
+	os.Chtimes(corrupt, info.ModTime(), info.ModTime())
+
+	report, err := scrubber.Run(context.Background())
+	if err != nil || len(report.Alerts) != 1 {
+		t.Fatalf("Run() = %+v, %v, want one alert", report, err)
+	}
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), ""))
+	if applied, err := replayer.Replay(context.Background()); err != nil || applied != 1 {
+		t.Fatalf("Replay() = %d, %v, want the alert applied", applied, err)
+	}
+	record, _ := server.Get(catapulttest.Files, id)
+	metadata := fmt.Sprint(record["metadata"])
+	want := fmt.Sprint(map[string]interface{}{"sample": "01", MetadataIntegrityStatus: ScrubCorrupt, MetadataIntegrityDetectedAt: fmt.Sprint(report.Alerts[0].DetectedAt)})
+	if metadata != want {
+		t.Errorf("backend metadata = %s, want %s", metadata, want)
+	}
+}
+
+func TestScrubber_OldestFirst(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	scrubber := NewScrubber(db, 0)
+	scrubber.BatchSize = 2
+	for _, name := range []string{"a.raw", "b.raw", "c.raw"} {
+		hashedFile(t, scrubber, filepath.Join(root, name), name)
+	}
+
+	now := time.Now()
+	for run, want := range [][]string{{"a.raw", "b.raw"}, {"c.raw", "a.raw"}} {
+		scrubber.now = func() time.Time { return now.Add(time.Duration(run) * time.Hour) }
+		if _, err := scrubber.Run(context.Background()); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		for _, name := range want {
+			history, _ := GetScrubHistory(db, filepath.Join(root, name))
+			if len(history) == 0 || history[0].ScrubbedAt != scrubber.clock().Unix() {
+				t.Errorf("run %d did not verify %s: %+v", run, name, history)
+			}
+		}
+	}
+}
+
+func TestScrubber_MissingFiles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	scrubber := NewScrubber(db, 0)
+	scrubber.BatchSize = 2
+	for _, name := range []string{"a.raw", "b.raw", "c.raw"} {
+		hashedFile(t, scrubber, filepath.Join(root, name), name)
+	}
+	// a.raw and b.raw are on a share that went away
+	os.Remove(filepath.Join(root, "a.raw"))
+	os.Remove(filepath.Join(root, "b.raw"))
+
+	now := time.Now()
+	for run := 0; run < 2; run++ {
+		scrubber.now = func() time.Time { return now.Add(time.Duration(run) * time.Hour) }
+		if _, err := scrubber.Run(context.Background()); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+	}
+	if history, _ := GetScrubHistory(db, filepath.Join(root, "c.raw")); len(history) != 1 || history[0].Status != ScrubOK {
+		t.Errorf("c.raw history = %+v, want it verified behind the missing files", history)
+	}
+	if history, _ := GetScrubHistory(db, filepath.Join(root, "a.raw")); len(history) != 0 {
+		t.Errorf("a.raw history = %+v, want no result for a missing file", history)
+	}
+}
+
+func TestScrubber_Window(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	scrubber := NewScrubber(db, 0)
+	hashedFile(t, scrubber, filepath.Join(t.TempDir(), "a.raw"), "a")
+	scrubber.Windows = []ScrubWindow{{Start: 22 * time.Hour, End: 6 * time.Hour}}
+
+	scrubber.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local) }
+	if report, err := scrubber.Run(context.Background()); err != nil || report.Checked != 0 {
+		t.Errorf("Run() outside the window = %+v, %v", report, err)
+	}
+	scrubber.now = func() time.Time { return time.Date(2024, 3, 1, 23, 0, 0, 0, time.Local) }
+	if report, err := scrubber.Run(context.Background()); err != nil || report.Checked != 1 {
+		t.Errorf("Run() inside the window = %+v, %v", report, err)
+	}
+}
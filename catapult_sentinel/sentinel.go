@@ -1,94 +1,770 @@
 package catapult_sentinel
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
+	"sync"
+	"time"
 )
 
 type Task struct {
 	NewFile     []File
 	ChangedFile []File
+	// DeletedFile lists files that have been missing from the location for
+	// longer than the scanner grace period. Id is the remote id when known.
+	DeletedFile []File
+	// ReadyFile lists files whose size and modification time just became
+	// stable, with ReadyForProcessing set.
+	ReadyFile []File
+	// MovedFile lists known files found under a new path, which keep their
+	// remote record.
+	MovedFile []FileMove
+	// Experiments lists experiments whose dominant vendor changed, with
+	// ExperimentName and Vendor set.
+	Experiments []Experiment
+	// Mismatched lists new and moved files whose name does not match the
+	// filename template of their location.
+	Mismatched []TemplateMismatch
+	Health     LocationHealth
+}
+
+// DefaultDeletionGracePeriod is how long a file has to stay missing before it is
+// reported as deleted, so that files briefly moved away or hidden by a slow
+// share are not reported.
+const DefaultDeletionGracePeriod = 10 * time.Minute
+
+// Scanner compares a location on disk against the local database.
+type Scanner struct {
+	DB                  *sql.DB
+	DeletionGracePeriod time.Duration
+	// MarkerFile, when set, must exist at the root of every location for the
+	// location to be considered mounted.
+	MarkerFile       string
+	MaxMissingRatio  float64
+	MinKnownFiles    int
+	ConfirmDropScans int
+	// Stability, when set, decides when files become ready for processing.
+	Stability *StabilityTracker
+	// Vendors classifies acquisitions and groups their companion files.
+	Vendors *VendorRegistry
+	// Checksums, when set, hashes files once they become ready, and new files
+	// that may be moved ones, in HashPending.
+	Checksums *Checksummer
+	// WalkWorkers is the number of directories of a location listed at once,
+	// unless LocationWalkWorkers, keyed by location id, says otherwise.
+	WalkWorkers         int
+	LocationWalkWorkers map[int]int
+	// Outbox, when set, makes scans queue the backend mutations they find in
+	// the transaction recording them, so that a crash or a failed enqueue
+	// cannot record a change locally while losing its mutation. Tasks still
+	// list the mutations, for logging.
+	Outbox bool
+
+	mu      sync.Mutex
+	drops   map[int]dropState
+	walkers map[int]*Walker
+	ignores map[int]*IgnoreMatcher
+	// experiments holds the experiment resolver of each location.
+	experiments map[int]*ExperimentResolver
+	now         func() time.Time
+}
+
+func NewScanner(db *sql.DB) *Scanner {
+	return &Scanner{
+		DB:                  db,
+		DeletionGracePeriod: DefaultDeletionGracePeriod,
+		MaxMissingRatio:     DefaultMaxMissingRatio,
+		MinKnownFiles:       DefaultMinKnownFiles,
+		ConfirmDropScans:    DefaultConfirmDropScans,
+		Stability:           NewStabilityTracker(db),
+		Vendors:             DefaultVendorRegistry(),
+		Checksums:           NewChecksummer(ChecksumSHA256, DefaultChecksumBytesPerSecond),
+		WalkWorkers:         DefaultWalkWorkers,
+		LocationWalkWorkers: make(map[int]int),
+		drops:               make(map[int]dropState),
+		now:                 time.Now,
+	}
+}
+
+func (s *Scanner) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
 }
 
 func GetFolderSize(folderPath string) int64 {
+	return GetFolderSizeContext(context.Background(), folderPath)
+}
+
+// GetFolderSizeContext returns the total size of the files below folderPath.
+// The walk is abandoned as soon as ctx is done.
+func GetFolderSizeContext(ctx context.Context, folderPath string) int64 {
 	var totalSize int64
-	err := filepath.Walk(folderPath, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		totalSize += info.Size()
-		return nil
-	})
+	entries, err := defaultWalker.Walk(ctx, folderPath, nil, nil)
 	if err != nil {
 		log.Println(err)
 	}
+	for _, entry := range entries {
+		if entry.Err != nil {
+			log.Println(entry.Err)
+			continue
+		}
+		totalSize += entry.Info.Size()
+	}
 	return totalSize
 }
 
 func ScanFolder(location FolderWatchingLocation, db *sql.DB) (Task, error) {
+	return ScanFolderContext(context.Background(), location, db)
+}
 
-	currentFiles := make(map[string]os.FileInfo)
-	err := filepath.Walk(location.FolderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// ScanFolderContext walks the location and compares it against the local
+// database. The walk is abandoned as soon as ctx is done.
+func ScanFolderContext(ctx context.Context, location FolderWatchingLocation, db *sql.DB) (Task, error) {
+	return NewScanner(db).Scan(ctx, location)
+}
+
+// Scan walks the location and compares it against the local database. When the
+// location looks unmounted or unreadable the returned task is marked degraded
+// and carries no deletions. Directories unchanged since the previous scan are
+// not listed again, see walkDir.
+func (s *Scanner) Scan(ctx context.Context, location FolderWatchingLocation) (Task, error) {
+	return s.scan(ctx, location, true)
+}
+
+// FullScan is Scan listing every directory and comparing every file.
+func (s *Scanner) FullScan(ctx context.Context, location FolderWatchingLocation) (Task, error) {
+	return s.scan(ctx, location, false)
+}
+
+func (s *Scanner) scan(ctx context.Context, location FolderWatchingLocation, incremental bool) (Task, error) {
+	db := s.DB
+	if s.Vendors == nil {
+		s.Vendors = defaultVendors
+	}
+
+	if health := s.checkRoot(location); health.Degraded {
+		task := newTask()
+		task.Health = health
+		return task, nil
+	}
+
+	known, err := ListFilesUnder(db, location.FolderPath)
+	if err != nil {
+		return Task{}, err
+	}
+	storedDirs, err := ListDirectoriesUnder(db, location.FolderPath)
+	if err != nil {
+		return Task{}, err
+	}
+	unstable, err := ListUnstable(db, location.FolderPath)
+	if err != nil {
+		return Task{}, err
+	}
+	walk := newWalkState(location, s.Vendors, incremental, s.clock(), known, storedDirs, unstable)
+	if err := s.walkLocation(ctx, walk); err != nil {
+		log.Println(err)
+		return Task{}, err
+	}
+	currentFiles := walk.files
+	unreadable := walk.unreadable
+
+	knownByPath := make(map[string]LocalFile, len(known))
+	vanished := []LocalFile{}
+	for _, file := range known {
+		knownByPath[file.Path] = file
+		if _, ok := currentFiles[file.Path]; !ok {
+			vanished = append(vanished, file)
 		}
-		if !info.IsDir() && (!strings.Contains(info.Name(), location.IgnoreTerm) || strings.HasSuffix(info.Name(), ".cat.yml")) {
-			currentFiles[path] = info
+	}
+	companions := s.Vendors.groupCompanions(currentFiles)
+	task := newTask()
+	if err := s.compare(ctx, location, currentFiles, companions, knownByPath, vanished, &task); err != nil {
+		return Task{}, err
+	}
+	// only once the files are recorded, so that a failed scan lists the
+	// directories again
+	if err := SaveDirectories(db, location.FolderPath, walk.dirs, len(unreadable) == 0); err != nil {
+		return Task{}, err
+	}
+	if len(task.MovedFile) > 0 {
+		movedFrom := make(map[string]bool, len(task.MovedFile))
+		for _, move := range task.MovedFile {
+			movedFrom[move.From] = true
 		}
-		if info.IsDir() && filepath.Ext(info.Name()) == ".d" {
-			currentFiles[path] = info
+		kept := known[:0]
+		for _, file := range known {
+			if !movedFrom[file.Path] {
+				kept = append(kept, file)
+			}
 		}
-		return nil
-	})
+		known = kept
+	}
+
+	known, err = s.dropAcquisitionContent(known, currentFiles, companions)
 	if err != nil {
-		log.Println(err)
 		return Task{}, err
 	}
-	task := Task{
+	missing := 0
+	for _, file := range known {
+		if _, ok := currentFiles[file.Path]; !ok {
+			missing++
+		}
+	}
+	if len(unreadable) > 0 {
+		task.Health = degraded("%d unreadable entries under %s, first %s", len(unreadable), location.FolderPath, unreadable[0])
+	} else {
+		task.Health = s.checkDrop(location, len(known), missing, len(currentFiles))
+	}
+	if task.Health.Degraded {
+		return task, nil
+	}
+	task.Experiments, err = s.experimentVendors(location, currentFiles)
+	if err != nil {
+		return Task{}, err
+	}
+
+	deleted, err := s.detectDeleted(location, known, currentFiles)
+	if err != nil {
+		return Task{}, err
+	}
+	task.DeletedFile = deleted
+	return task, nil
+}
+
+// ScanPaths compares only the given paths against the local database, along
+// with the files whose stability is still being tracked, since those change
+// state without any event. Paths inside a directory acquisition or naming a
+// companion file stand for their acquisition. Missing paths are left to the
+// next full Scan, which alone can tell a deletion from an unmounted share.
+func (s *Scanner) ScanPaths(ctx context.Context, location FolderWatchingLocation, paths []string) (Task, error) {
+	if s.Vendors == nil {
+		s.Vendors = defaultVendors
+	}
+	if health := s.checkRoot(location); health.Degraded {
+		task := newTask()
+		task.Health = health
+		return task, nil
+	}
+
+	unstable, err := ListUnstable(s.DB, location.FolderPath)
+	if err != nil {
+		return Task{}, err
+	}
+	ignores := s.ignoreMatcher(location)
+	ignores.Reset()
+	s.experimentResolver(location).Reset()
+	currentFiles := make(map[string]os.FileInfo)
+	for _, path := range append(append([]string{}, paths...), unstable...) {
+		path = s.acquisitionOf(location, path)
+		if _, ok := currentFiles[path]; ok {
+			continue
+		}
+		for _, candidate := range append([]string{path}, s.Vendors.CompanionPaths(path)...) {
+			info, err := os.Stat(candidate)
+			if err != nil || !s.tracked(location, candidate, info) || ignores.Ignored(candidate, info.IsDir()) {
+				continue
+			}
+			currentFiles[candidate] = info
+		}
+	}
+	companions := s.Vendors.groupCompanions(currentFiles)
+	task := newTask()
+	if err := s.compare(ctx, location, currentFiles, companions, nil, nil, &task); err != nil {
+		return Task{}, err
+	}
+	return task, nil
+}
+
+// acquisitionOf returns the acquisition path stands for: the outermost
+// directory acquisition holding it, the acquisition it is a companion of, or
+// path itself.
+func (s *Scanner) acquisitionOf(location FolderWatchingLocation, path string) string {
+	acquisition := path
+	for dir := filepath.Dir(path); len(dir) > len(location.FolderPath); dir = filepath.Dir(dir) {
+		if _, ok := s.Vendors.Classify(dir, true); ok {
+			acquisition = dir
+		}
+	}
+	if acquisition != path {
+		return acquisition
+	}
+	if primary, ok := s.Vendors.CompanionOf(path); ok {
+		return primary
+	}
+	return path
+}
+
+func newTask() Task {
+	return Task{
 		NewFile:     []File{},
 		ChangedFile: []File{},
+		DeletedFile: []File{},
+		ReadyFile:   []File{},
+		MovedFile:   []FileMove{},
+		Experiments: []Experiment{},
 	}
+}
 
+// tracked reports whether the walk records path as a file of the location: a
+// file or directory acquisition matching the location extensions, or a run
+// config. The directories holding path are assumed not to be ignored.
+func (s *Scanner) tracked(location FolderWatchingLocation, path string, info os.FileInfo) bool {
+	if s.MarkerFile != "" && path == filepath.Join(location.FolderPath, s.MarkerFile) {
+		return false
+	}
+	if path == filepath.Join(location.FolderPath, DropConfirmFile) {
+		return false
+	}
+	if info.Name() == IgnoreFileName || s.ignoreMatcher(location).match(path, info.IsDir()) {
+		return false
+	}
+	if !info.IsDir() && IsRunConfig(info.Name()) {
+		return true
+	}
+	// companion files follow the rules of their acquisition
+	name := path
+	if primary, ok := s.Vendors.CompanionOf(path); ok {
+		name = primary
+	}
+	if !LocationExtensions(location).Match(filepath.Base(name)) {
+		return false
+	}
+	if info.IsDir() {
+		_, ok := s.Vendors.Classify(path, true)
+		return ok
+	}
+	return true
+}
+
+// compare records currentFiles in the local database and adds new, changed
+// and newly ready files to task. Files standing for unchanged known entries are
+// skipped. known holds the stored files, it is looked up when nil. New files
+// are matched against missing, the known files the walk did not find, to tell
+// moves from new files. Every write happens in a single transaction, along
+// with the outbox entries when Outbox is set.
+func (s *Scanner) compare(ctx context.Context, location FolderWatchingLocation, currentFiles map[string]os.FileInfo, companions map[string]map[string]os.FileInfo, known map[string]LocalFile, missing []LocalFile, task *Task) error {
+	type observation struct {
+		path          string
+		isFolder      bool
+		size          int64
+		lastModified  int64
+		device, inode int64
+	}
+	paths := make([]string, 0, len(currentFiles))
 	for path, info := range currentFiles {
-		var localFile LocalFile
-		exists, _ := CheckFileExists(db, path)
+		if _, ok := info.(knownFile); !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	observations := make([]observation, 0, len(paths))
+	for _, path := range paths {
+		info := currentFiles[path]
+		size, lastModified, err := s.acquisitionStat(ctx, location, path, info)
+		// a cancelled walk leaves the size of a directory acquisition partial
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			// looked at again by the next scan
+			log.Printf("cannot stat acquisition %s: %v", path, err)
+			continue
+		}
+		size, lastModified = addCompanions(size, lastModified, companions[path])
+		device, inode := fileIdentity(info)
+		observations = append(observations, observation{path, info.IsDir(), size, lastModified, device, inode})
+	}
+
+	var err error
+	if known == nil {
+		if known, err = GetFiles(s.DB, paths); err != nil {
+			return err
+		}
+	}
+	var candidates []moveCandidate
+	for _, o := range observations {
+		if _, ok := known[o.path]; !ok {
+			candidates = append(candidates, moveCandidate{o.path, o.isFolder, o.size, o.lastModified, o.device, o.inode})
+		}
+	}
+	moves, deferred, err := s.matchMoves(ctx, location, candidates, missing)
+	if err != nil {
+		return err
+	}
+	stabilities := map[string]Stability{}
+	if s.Stability != nil {
+		lookup := paths
+		for _, from := range moves {
+			lookup = append(lookup, from.Path)
+		}
+		if stabilities, err = GetStabilities(s.DB, lookup); err != nil {
+			return err
+		}
+		for to, from := range moves {
+			if stability, ok := stabilities[from.Path]; ok {
+				stability.Path = to
+				stabilities[to] = stability
+			}
+		}
+	}
+
+	now := s.clock()
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	insert, err := tx.Prepare("INSERT INTO files (path, size, is_folder, last_modified, remote_id, device, inode) VALUES (?, ?, ?, ?, 0, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	update, err := tx.Prepare("UPDATE files SET size = ?, last_modified = ?, checksum = '', checksum_pending = 0, device = ?, inode = ? WHERE path = ?")
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+	identify, err := tx.Prepare("UPDATE files SET device = ?, inode = ? WHERE path = ?")
+	if err != nil {
+		return err
+	}
+	defer identify.Close()
+	describe, err := tx.Prepare("UPDATE files SET metadata = ? WHERE path = ?")
+	if err != nil {
+		return err
+	}
+	defer describe.Close()
+	pend, err := tx.Prepare("UPDATE files SET checksum_pending = 1 WHERE path = ?")
+	if err != nil {
+		return err
+	}
+	defer pend.Close()
+	settle, err := tx.Prepare("DELETE FROM move_candidates WHERE path = ?")
+	if err != nil {
+		return err
+	}
+	defer settle.Close()
+	// metadata parses the name of a new or moved acquisition and stores its
+	// fields
+	template := LocationTemplate(location)
+	metadata := func(path string) (map[string]string, error) {
+		if template == nil || IsRunConfig(filepath.Base(path)) {
+			return nil, nil
+		}
+		fields, err := template.Parse(filepath.Base(path))
+		if err != nil {
+			task.Mismatched = append(task.Mismatched, TemplateMismatch{FilePath: path, Error: err.Error()})
+		}
+		encoded, err := encodeMetadata(fields)
+		if err != nil {
+			return nil, err
+		}
+		_, err = describe.Exec(encoded, path)
+		return fields, err
+	}
+
+	// queue records a file mutation in the outbox along with the scan
+	queue := func(operation string, change FileChange) error {
+		if !s.Outbox {
+			return nil
+		}
+		return EnqueueFileChange(tx, operation, change)
+	}
+
+	for _, o := range observations {
+		if deferred[o.path] {
+			// waits for HashPending to tell a move from a new file
+			continue
+		}
+		localFile, exists := known[o.path]
+		changed := false
+		if from, moved := moves[o.path]; moved {
+			if err := moveFile(tx, from, o.path, o.device, o.inode); err != nil {
+				return err
+			}
+			fields, err := metadata(o.path)
+			if err != nil {
+				return err
+			}
+			move := FileMove{
+				From: from.Path,
+				File: File{
+					FilePath:               o.path,
+					FolderWatchingLocation: location.Id,
+					Size:                   o.size,
+					Id:                     int(from.RemoteId),
+					Metadata:               fields,
+				},
+				ExperimentName: s.experimentResolver(location).Name(o.path),
+			}
+			if s.Outbox {
+				if err := EnqueueFileMove(tx, move); err != nil {
+					return err
+				}
+			}
+			task.MovedFile = append(task.MovedFile, move)
+			localFile, exists = from, true
+			localFile.Path, localFile.Device, localFile.Inode = o.path, o.device, o.inode
+		}
 		if !exists {
-			localFile = LocalFile{
-				IsFolder:     info.IsDir(),
-				Size:         info.Size(),
-				LastModified: info.ModTime().Unix(),
-				RemoteId:     0,
-				Path:         path,
-			}
-			err := InsertFile(db, localFile)
+			if _, err := insert.Exec(o.path, o.size, o.isFolder, o.lastModified, o.device, o.inode); err != nil {
+				return err
+			}
+			if _, err := settle.Exec(o.path); err != nil {
+				return err
+			}
+			fields, err := metadata(o.path)
 			if err != nil {
-				log.Println(err)
+				return err
 			}
-
-			task.NewFile = append(task.NewFile, File{
-				FilePath:               localFile.Path,
+			file := File{
+				FilePath:               o.path,
 				FolderWatchingLocation: location.Id,
-				Size:                   localFile.Size,
-			})
-		} else {
-			localFile, err = GetFile(db, path)
+				Size:                   o.size,
+				Metadata:               fields,
+			}
+			change := FileChange{File: file, ExperimentName: s.experimentResolver(location).Name(o.path)}
+			if err := queue(OutboxCreate, change); err != nil {
+				return err
+			}
+			task.NewFile = append(task.NewFile, file)
+		} else if localFile.Size != o.size || localFile.LastModified != o.lastModified {
+			if _, err := update.Exec(o.size, o.lastModified, o.device, o.inode, o.path); err != nil {
+				return err
+			}
+			file := File{
+				FilePath:               o.path,
+				FolderWatchingLocation: location.Id,
+				Size:                   o.size,
+				Id:                     int(localFile.RemoteId),
+			}
+			// the stored checksum was cleared along with the new content
+			changed = true
+			if err := queue(OutboxUpdate, FileChange{File: file, ClearChecksum: true}); err != nil {
+				return err
+			}
+			task.ChangedFile = append(task.ChangedFile, file)
+		} else if localFile.Device != o.device || localFile.Inode != o.inode {
+			// rows written before identities were recorded, or files replaced
+			// by a rename over them
+			if _, err := identify.Exec(o.device, o.inode, o.path); err != nil {
+				return err
+			}
+		}
+
+		if s.Stability != nil {
+			ready, err := s.Stability.observe(tx, stabilities[o.path], o.path, o.size, o.lastModified, now)
 			if err != nil {
-				log.Println(err)
+				return err
 			}
-			if localFile.Size != info.Size() || localFile.LastModified != info.ModTime().Unix() {
-				task.ChangedFile = append(task.ChangedFile, File{
-					FilePath:               localFile.Path,
+			if ready {
+				file := File{
+					FilePath:               o.path,
 					FolderWatchingLocation: location.Id,
-					Size:                   localFile.Size,
-				})
+					Size:                   o.size,
+					Id:                     int(localFile.RemoteId),
+					ReadyForProcessing:     true,
+				}
+				// the update replaces a pending one of changed content, so a file
+				// not hashed since must still clear the backend checksum
+				unhashed := changed || localFile.Checksum == ""
+				if err := queue(OutboxUpdate, FileChange{File: file, ClearChecksum: unhashed}); err != nil {
+					return err
+				}
+				// hashed by HashPending, away from the scan
+				if s.Checksums != nil {
+					if _, err := pend.Exec(o.path); err != nil {
+						return err
+					}
+				}
+				task.ReadyFile = append(task.ReadyFile, file)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// HashPending hashes the files of location that became ready since they were
+// last hashed, companion files included, and the new files deferred by the
+// scans as possible moves. It runs apart from the scans so that large
+// acquisitions do not hold up the walk. A checksum is recorded, and
+// queued along with the ready flag when Outbox is set, only when the file was
+// not seen changing meanwhile. Files that cannot be hashed stay pending for
+// the next call. It returns the files hashed.
+func (s *Scanner) HashPending(ctx context.Context, location FolderWatchingLocation) ([]File, error) {
+	if s.Checksums == nil {
+		return nil, nil
+	}
+	if err := s.hashMoveCandidates(ctx, location); err != nil {
+		return nil, err
+	}
+	pending, err := ListChecksumPending(s.DB, location.FolderPath)
+	if err != nil {
+		return nil, err
+	}
+	var hashed []File
+	for _, file := range pending {
+		checksum, err := s.Checksums.SumAcquisition(ctx, file.Path, s.Vendors.CompanionPaths(file.Path))
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return hashed, ctxErr
+		}
+		if err != nil {
+			log.Printf("cannot hash %s: %v", file.Path, err)
+			continue
+		}
+		ready := File{
+			FilePath:               file.Path,
+			FolderWatchingLocation: location.Id,
+			Size:                   file.Size,
+			Id:                     int(file.RemoteId),
+			ReadyForProcessing:     true,
+			Checksum:               checksum,
+		}
+		recorded, err := s.recordChecksum(file, ready)
+		if err != nil {
+			return hashed, err
+		}
+		if recorded {
+			hashed = append(hashed, ready)
+		}
+	}
+	return hashed, nil
+}
+
+// recordChecksum stores the checksum of ready, read from file, unless the
+// file changed since it was listed.
+func (s *Scanner) recordChecksum(file LocalFile, ready File) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("UPDATE files SET checksum = ?, checksum_pending = 0 WHERE path = ? AND checksum_pending = 1 AND size = ? AND last_modified = ?",
+		ready.Checksum, file.Path, file.Size, file.LastModified)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if s.Outbox {
+		if err := EnqueueFile(tx, OutboxUpdate, ready); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
 
+// detectDeleted diffs the files known under the location root against the
+// walk. A missing file gets a tombstone on first sight and is only reported
+// once it has been missing for the grace period; reported files are dropped
+// from the files table while their tombstone keeps the remote id. Files that
+// come back before being reported simply lose their tombstone.
+func (s *Scanner) detectDeleted(location FolderWatchingLocation, known []LocalFile, currentFiles map[string]os.FileInfo) ([]File, error) {
+	tombstones, err := GetTombstones(s.DB, location.FolderPath)
+	if err != nil {
+		return nil, err
+	}
+	for path := range tombstones {
+		if _, ok := currentFiles[path]; ok {
+			if err := DeleteTombstone(s.DB, path); err != nil {
+				return nil, err
 			}
+		}
+	}
 
+	now := s.clock().Unix()
+	deleted := []File{}
+	for _, file := range known {
+		if _, ok := currentFiles[file.Path]; ok {
+			continue
+		}
+		tombstone, ok := tombstones[file.Path]
+		if !ok || tombstone.Reported != 0 {
+			err = InsertTombstone(s.DB, Tombstone{
+				Path:         file.Path,
+				Size:         file.Size,
+				IsFolder:     file.IsFolder,
+				RemoteId:     file.RemoteId,
+				FirstMissing: now,
+				LastModified: file.LastModified,
+				Checksum:     file.Checksum,
+				Device:       file.Device,
+				Inode:        file.Inode,
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
 		}
+		if now-tombstone.FirstMissing < int64(s.DeletionGracePeriod/time.Second) {
+			continue
+		}
+		report := File{
+			FilePath:               file.Path,
+			FolderWatchingLocation: location.Id,
+			Size:                   file.Size,
+			Id:                     int(file.RemoteId),
+			Missing:                true,
+		}
+		if err := s.reportDeleted(report, now); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, report)
+	}
+	return deleted, nil
+}
 
+// reportDeleted marks the tombstone of file as reported and, when Outbox is
+// set, queues the update marking it missing on the backend, in a single
+// transaction.
+func (s *Scanner) reportDeleted(file File, now int64) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
 	}
-	return task, nil
+	defer tx.Rollback()
+	if err := reportTombstone(tx, file.FilePath, now); err != nil {
+		return err
+	}
+	if s.Outbox {
+		if err := EnqueueFile(tx, OutboxUpdate, file); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// dropAcquisitionContent forgets files recorded inside a directory acquisition
+// or as a companion of another file by older scans, which tracked every file on
+// its own. They are removed locally without being reported as deleted.
+func (s *Scanner) dropAcquisitionContent(known []LocalFile, currentFiles map[string]os.FileInfo, companions map[string]map[string]os.FileInfo) ([]LocalFile, error) {
+	kept := known[:0]
+	for _, file := range known {
+		primary, isCompanion := s.Vendors.CompanionOf(file.Path)
+		_, grouped := companions[primary][file.Path]
+		if !(isCompanion && grouped) && !insideAcquisition(file.Path, currentFiles) {
+			kept = append(kept, file)
+			continue
+		}
+		if err := DeleteFile(s.DB, file.Path); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+func insideAcquisition(path string, currentFiles map[string]os.FileInfo) bool {
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if info, ok := currentFiles[dir]; ok && info.IsDir() {
+			return true
+		}
+	}
+	return false
 }
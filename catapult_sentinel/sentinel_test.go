@@ -0,0 +1,224 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func writeTestFile(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error: %v", err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+}
+
+func TestScanFolder(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.raw"), 10)
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_02.raw"), 20)
+
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	task, err := ScanFolder(location, db)
+	if err != nil {
+		t.Fatalf("ScanFolder() error: %v", err)
+	}
+	if len(task.NewFile) != 2 || len(task.ChangedFile) != 0 {
+		t.Fatalf("ScanFolder() = %+v, want 2 new files", task)
+	}
+
+	task, err = ScanFolder(location, db)
+	if err != nil {
+		t.Fatalf("ScanFolder() error: %v", err)
+	}
+	if len(task.NewFile) != 0 {
+		t.Fatalf("ScanFolder() second scan = %+v, want no new files", task)
+	}
+}
+
+func TestScanFolderContextCancelled(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "sample_01.raw"), 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ScanFolderContext(ctx, FolderWatchingLocation{FolderPath: root, Extensions: "*", Id: 1}, db)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ScanFolderContext() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestScanFolderReplay(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.raw"), 10)
+
+	backend := NewCatapultBackend(server.BaseUrl(), "")
+	backend.Retry = nil
+	replayer := NewOutboxReplayer(db, backend)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	task, err := ScanFolder(location, db)
+	if err != nil {
+		t.Fatalf("ScanFolder() error: %v", err)
+	}
+	for _, file := range task.NewFile {
+		EnqueueFile(db, OutboxCreate, file)
+	}
+
+	server.AddFault(catapulttest.Fault{Times: 1, Status: http.StatusBadGateway})
+	if _, err := replayer.Replay(context.Background()); err == nil {
+		t.Fatalf("Replay() during outage succeeded")
+	}
+	if _, err := replayer.Replay(context.Background()); err != nil {
+		t.Fatalf("Replay() error: %v", err)
+	}
+	files := server.Records(catapulttest.Files)
+	if len(files) != 1 || files[0]["file_path"] != filepath.Join(root, "exp1", "sample_01.raw") || fmt.Sprint(files[0]["size"]) != "10" {
+		t.Fatalf("backend files = %v", files)
+	}
+}
+
+func TestScanner_Outbox(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	first := filepath.Join(root, "exp1", "sample_01.raw")
+	second := filepath.Join(root, "exp1", "sample_02.raw")
+	writeTestFile(t, first, 10)
+
+	scanner := NewScanner(db)
+	scanner.Outbox = true
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	// files returns the pending file entries
+	files := func() []OutboxEntry {
+		entries, _ := PendingOutbox(db, 10)
+		var files []OutboxEntry
+		for _, entry := range entries {
+			if entry.Kind == OutboxFile {
+				files = append(files, entry)
+			}
+		}
+		return files
+	}
+	if entries := files(); len(entries) != 1 || entries[0].Operation != OutboxCreate || !strings.Contains(string(entries[0].Payload), "sample_01") {
+		t.Fatalf("pending files = %+v, want the creation of %s", entries, first)
+	}
+
+	// a failed enqueue fails the scan and records nothing
+	writeTestFile(t, second, 10)
+	db.Exec("ALTER TABLE outbox RENAME TO outbox_away")
+	if _, err := scanner.Scan(context.Background(), location); err == nil {
+		t.Fatalf("Scan() with a failing outbox succeeded")
+	}
+	if exists, _ := CheckFileExists(db, second); exists {
+		t.Fatalf("%s recorded without its outbox entry", second)
+	}
+	db.Exec("ALTER TABLE outbox_away RENAME TO outbox")
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil || len(task.NewFile) != 1 || task.NewFile[0].FilePath != second {
+		t.Fatalf("Scan() after the failure = %+v, %v, want %s as new", task.NewFile, err, second)
+	}
+	if entries := files(); len(entries) != 2 {
+		t.Errorf("pending files = %d entries, want 2", len(entries))
+	}
+}
+
+func TestScanner_SiblingLocations(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	parent := t.TempDir()
+	a := FolderWatchingLocation{FolderPath: filepath.Join(parent, "a"), Extensions: "*", IgnoreTerm: "~", Id: 1}
+	ab := FolderWatchingLocation{FolderPath: filepath.Join(parent, "ab"), Extensions: "*", IgnoreTerm: "~", Id: 2}
+	writeTestFile(t, filepath.Join(a.FolderPath, "exp1", "sample_01.raw"), 10)
+	writeTestFile(t, filepath.Join(ab.FolderPath, "exp1", "sample_01.raw"), 10)
+
+	scanner := NewScanner(db)
+	scanner.DeletionGracePeriod = 0
+	for i := 0; i < 2; i++ {
+		for _, location := range []FolderWatchingLocation{a, ab} {
+			task, err := scanner.Scan(context.Background(), location)
+			if err != nil || len(task.DeletedFile) != 0 || task.Health.Degraded {
+				t.Fatalf("Scan(%s) = %+v, %v, want no deleted files", location.FolderPath, task, err)
+			}
+		}
+	}
+	if files, _ := ListFilesUnder(db, a.FolderPath); len(files) != 1 {
+		t.Errorf("ListFilesUnder(%s) = %+v, want only its own file", a.FolderPath, files)
+	}
+}
+
+func TestScanner_DeletedFile(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	path := filepath.Join(root, "exp1", "sample_01.raw")
+	writeTestFile(t, path, 10)
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_02.raw"), 10)
+
+	now := time.Unix(1700000000, 0)
+	scanner := NewScanner(db)
+	scanner.now = func() time.Time { return now }
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	if _, err := scanner.Scan(context.Background(), location); err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	SetRemoteId(db, path, 7)
+	os.Remove(path)
+
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil || len(task.DeletedFile) != 0 {
+		t.Fatalf("Scan() within grace period = %+v, %v, want no deleted files", task.DeletedFile, err)
+	}
+	tombstones, _ := GetTombstones(db, root)
+	if tombstones[path].FirstMissing != now.Unix() {
+		t.Fatalf("GetTombstones() = %+v, want a tombstone for %s", tombstones, path)
+	}
+
+	now = now.Add(DefaultDeletionGracePeriod)
+	task, err = scanner.Scan(context.Background(), location)
+	if err != nil || len(task.DeletedFile) != 1 {
+		t.Fatalf("Scan() after grace period = %+v, %v, want 1 deleted file", task.DeletedFile, err)
+	}
+	if got := task.DeletedFile[0]; got.FilePath != path || got.Id != 7 || !got.Missing {
+		t.Errorf("DeletedFile[0] = %+v", got)
+	}
+	if exists, _ := CheckFileExists(db, path); exists {
+		t.Errorf("CheckFileExists() = true after the deletion was reported")
+	}
+
+	task, _ = scanner.Scan(context.Background(), location)
+	if len(task.DeletedFile) != 0 {
+		t.Errorf("Scan() reported %s twice", path)
+	}
+
+	writeTestFile(t, path, 10)
+	task, _ = scanner.Scan(context.Background(), location)
+	if len(task.NewFile) != 1 {
+		t.Errorf("Scan() after the file came back = %+v, want it as a new file", task)
+	}
+	tombstones, _ = GetTombstones(db, root)
+	if _, ok := tombstones[path]; ok {
+		t.Errorf("tombstone kept after the file came back")
+	}
+}
@@ -0,0 +1,206 @@
+package catapult_sentinel
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// StabilityRule decides when an acquisition is considered complete. A file is
+// stable once its size and modification time have not changed for MinScans
+// consecutive scans and for at least MinDuration. A zero field is not checked.
+type StabilityRule struct {
+	MinScans    int
+	MinDuration time.Duration
+}
+
+var DefaultStabilityRule = StabilityRule{MinScans: 3, MinDuration: 5 * time.Minute}
+
+// DefaultStabilityHistory is the number of observations kept per file.
+const DefaultStabilityHistory = 50
+
+// Stability is the stored stability state of a file.
+type Stability struct {
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	LastModified   int64  `json:"last_modified"`
+	UnchangedScans int    `json:"unchanged_scans"`
+	UnchangedSince int64  `json:"unchanged_since"`
+	Ready          bool   `json:"ready"`
+}
+
+// StabilityTracker records the size and modification time of files on every
+// scan until they stop changing, so that acquisitions still being written are
+// not handed over for processing.
+type StabilityTracker struct {
+	DB *sql.DB
+	// Rules maps a lower case file suffix such as ".raw", ".d" or ".wiff.scan"
+	// to its rule; the longest matching suffix wins. Default applies otherwise.
+	Rules        map[string]StabilityRule
+	Default      StabilityRule
+	HistoryLimit int
+}
+
+func NewStabilityTracker(db *sql.DB) *StabilityTracker {
+	return &StabilityTracker{
+		DB:           db,
+		Rules:        make(map[string]StabilityRule),
+		Default:      DefaultStabilityRule,
+		HistoryLimit: DefaultStabilityHistory,
+	}
+}
+
+// RuleFor returns the rule applying to path.
+func (t *StabilityTracker) RuleFor(path string) StabilityRule {
+	name := strings.ToLower(path)
+	best := ""
+	rule := t.Default
+	for suffix, r := range t.Rules {
+		suffix = strings.ToLower(suffix)
+		if strings.HasSuffix(name, suffix) && len(suffix) > len(best) {
+			best = suffix
+			rule = r
+		}
+	}
+	return rule
+}
+
+func GetStability(db *sql.DB, path string) (Stability, error) {
+	var s Stability
+	err := db.QueryRow("SELECT path, size, last_modified, unchanged_scans, unchanged_since, ready FROM stability WHERE path = ?", path).Scan(&s.Path, &s.Size, &s.LastModified, &s.UnchangedScans, &s.UnchangedSince, &s.Ready)
+	if err != nil {
+		return Stability{}, err
+	}
+	return s, nil
+}
+
+// GetStabilities returns the stored stability of the tracked files among
+// paths, keyed by path.
+func GetStabilities(db *sql.DB, paths []string) (map[string]Stability, error) {
+	stabilities := make(map[string]Stability, len(paths))
+	for start := 0; start < len(paths); start += lookupBatch {
+		batch := paths[start:min(start+lookupBatch, len(paths))]
+		args := make([]interface{}, len(batch))
+		for i, path := range batch {
+			args[i] = path
+		}
+		rows, err := db.Query("SELECT path, size, last_modified, unchanged_scans, unchanged_since, ready FROM stability WHERE path IN (?"+strings.Repeat(", ?", len(batch)-1)+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var s Stability
+			if err := rows.Scan(&s.Path, &s.Size, &s.LastModified, &s.UnchangedScans, &s.UnchangedSince, &s.Ready); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			stabilities[s.Path] = s
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return stabilities, nil
+}
+
+// Observe records one scan of path. It returns true when this observation
+// makes the file stable; later unchanged observations return false. A file
+// that changes after being stable starts over.
+func (t *StabilityTracker) Observe(path string, size int64, lastModified int64, observedAt time.Time) (bool, error) {
+	current, err := GetStability(t.DB, path)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	tx, err := t.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	ready, err := t.observe(tx, current, path, size, lastModified, observedAt)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return ready, tx.Commit()
+}
+
+// observe is Observe within tx, given the stored state of path, which has an
+// empty Path when the file is not tracked yet.
+func (t *StabilityTracker) observe(tx *sql.Tx, current Stability, path string, size int64, lastModified int64, observedAt time.Time) (bool, error) {
+	now := observedAt.Unix()
+	unchanged := current.Path != "" && current.Size == size && current.LastModified == lastModified
+	if unchanged && current.Ready {
+		return false, nil
+	}
+
+	next := Stability{Path: path, Size: size, LastModified: lastModified, UnchangedScans: 0, UnchangedSince: now}
+	if unchanged {
+		next.UnchangedScans = current.UnchangedScans + 1
+		next.UnchangedSince = current.UnchangedSince
+	}
+	rule := t.RuleFor(path)
+	next.Ready = unchanged &&
+		next.UnchangedScans >= rule.MinScans &&
+		now-next.UnchangedSince >= int64(rule.MinDuration/time.Second)
+
+	_, err := tx.Exec("INSERT OR REPLACE INTO stability (path, size, last_modified, unchanged_scans, unchanged_since, ready) VALUES (?, ?, ?, ?, ?, ?)", next.Path, next.Size, next.LastModified, next.UnchangedScans, next.UnchangedSince, next.Ready)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO stability_history (path, observed_at, size, last_modified) VALUES (?, ?, ?, ?)", path, now, size, lastModified)
+	}
+	if err == nil && t.HistoryLimit > 0 {
+		_, err = tx.Exec(`
+		 DELETE FROM stability_history WHERE path = ? AND rowid NOT IN (
+		  SELECT rowid FROM stability_history WHERE path = ? ORDER BY observed_at DESC, rowid DESC LIMIT ?
+		 )`, path, path, t.HistoryLimit)
+	}
+	if err != nil {
+		return false, err
+	}
+	return next.Ready, nil
+}
+
+// ListUnstable returns the paths under root that are tracked but not ready.
+func ListUnstable(db *sql.DB, root string) ([]string, error) {
+	under, args := underRoot("path", root)
+	rows, err := db.Query("SELECT path FROM stability WHERE ready = 0 AND "+under, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// StabilityObservation is one recorded scan of a file.
+type StabilityObservation struct {
+	ObservedAt   int64 `json:"observed_at"`
+	Size         int64 `json:"size"`
+	LastModified int64 `json:"last_modified"`
+}
+
+// GetStabilityHistory returns the recorded observations of path, oldest first.
+func GetStabilityHistory(db *sql.DB, path string) ([]StabilityObservation, error) {
+	rows, err := db.Query("SELECT observed_at, size, last_modified FROM stability_history WHERE path = ? ORDER BY observed_at, rowid", path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []StabilityObservation
+	for rows.Next() {
+		var o StabilityObservation
+		if err := rows.Scan(&o.ObservedAt, &o.Size, &o.LastModified); err != nil {
+			return nil, err
+		}
+		history = append(history, o)
+	}
+	return history, rows.Err()
+}
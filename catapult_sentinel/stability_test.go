@@ -0,0 +1,114 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStabilityTracker_RuleFor(t *testing.T) {
+	tracker := NewStabilityTracker(nil)
+	tracker.Rules[".wiff"] = StabilityRule{MinScans: 1}
+	tracker.Rules[".wiff.scan"] = StabilityRule{MinScans: 5}
+	tracker.Rules[".D"] = StabilityRule{MinDuration: time.Hour}
+
+	tests := []struct {
+		path string
+		want StabilityRule
+	}{
+		{"exp/a.wiff", StabilityRule{MinScans: 1}},
+		{"exp/a.wiff.scan", StabilityRule{MinScans: 5}},
+		{"exp/a.d", StabilityRule{MinDuration: time.Hour}},
+		{"exp/a.raw", DefaultStabilityRule},
+	}
+	for _, tt := range tests {
+		if got := tracker.RuleFor(tt.path); got != tt.want {
+			t.Errorf("RuleFor(%s) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestStabilityTracker_Observe(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	tracker := NewStabilityTracker(db)
+	tracker.Default = StabilityRule{MinScans: 2, MinDuration: 2 * time.Minute}
+	tracker.HistoryLimit = 4
+
+	now := time.Unix(1700000000, 0)
+	observe := func(size int64) bool {
+		t.Helper()
+		ready, err := tracker.Observe("a.raw", size, 100, now)
+		if err != nil {
+			t.Fatalf("Observe() error: %v", err)
+		}
+		now = now.Add(time.Minute)
+		return ready
+	}
+
+	for _, step := range []struct {
+		size int64
+		want bool
+	}{
+		{10, false}, // first sight
+		{20, false}, // still growing
+		{20, false}, // 1 unchanged scan, 1 minute
+		{20, true},  // 2 unchanged scans, 2 minutes
+		{20, false}, // already reported
+		{30, false}, // rewritten, starts over
+	} {
+		if got := observe(step.size); got != step.want {
+			t.Fatalf("Observe(%d) = %v, want %v", step.size, got, step.want)
+		}
+	}
+
+	stability, _ := GetStability(db, "a.raw")
+	if stability.Ready || stability.Size != 30 {
+		t.Errorf("GetStability() = %+v, want not ready at size 30", stability)
+	}
+	history, _ := GetStabilityHistory(db, "a.raw")
+	if len(history) != 4 || history[0].Size != 20 || history[3].Size != 30 {
+		t.Errorf("GetStabilityHistory() = %+v, want the last 4 recorded observations", history)
+	}
+}
+
+func TestScanner_ReadyFile(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	path := filepath.Join(root, "exp1", "sample_01.raw")
+	writeTestFile(t, path, 10)
+
+	now := time.Unix(1700000000, 0)
+	scanner := NewScanner(db)
+	scanner.Stability.Default = StabilityRule{MinScans: 2}
+	scanner.now = func() time.Time { return now }
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+
+	scan := func() Task {
+		t.Helper()
+		task, err := scanner.Scan(context.Background(), location)
+		if err != nil {
+			t.Fatalf("Scan() error: %v", err)
+		}
+		now = now.Add(time.Minute)
+		return task
+	}
+
+	scan()
+	os.WriteFile(path, make([]byte, 20), 0o644)
+	os.Chtimes(path, now, now)
+	task := scan()
+	if len(task.ChangedFile) != 1 || task.ChangedFile[0].Size != 20 {
+		t.Fatalf("Scan() after growth = %+v, want a change to size 20", task.ChangedFile)
+	}
+	if task = scan(); len(task.ChangedFile) != 0 || len(task.ReadyFile) != 0 {
+		t.Fatalf("Scan() = %+v, want no change and not ready yet", task)
+	}
+	task = scan()
+	if len(task.ReadyFile) != 1 || !task.ReadyFile[0].ReadyForProcessing || task.ReadyFile[0].Size != 20 {
+		t.Fatalf("Scan() = %+v, want the file ready", task.ReadyFile)
+	}
+}
@@ -0,0 +1,227 @@
+package catapult_sentinel
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FilenameTemplate extracts sample metadata from file names following a
+// naming convention, such as {sample}_{method}_{column}_{date:20060102}_{replicate}
+// for 1000ngHeLa_180SPD_ES906_20240214_01.raw. Text outside braces must appear
+// as is. A field holds at least one character and as few as the rest of the
+// template allows, so only the last field may hold the separator. A field
+// followed by a Go time layout only matches dates in that layout and is
+// stored as 2006-01-02, or 2006-01-02T15:04:05 when the layout holds a time.
+type FilenameTemplate struct {
+	spec    string
+	re      *regexp.Regexp
+	layouts map[string]string
+}
+
+var (
+	templateField = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	metadataRef   = regexp.MustCompile(`\{[A-Za-z_][A-Za-z0-9_]*\}`)
+)
+
+// ParseFilenameTemplate compiles a filename template.
+func ParseFilenameTemplate(spec string) (*FilenameTemplate, error) {
+	t := &FilenameTemplate{spec: spec, layouts: make(map[string]string)}
+	var b strings.Builder
+	b.WriteString("^")
+	rest := spec
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			b.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		b.WriteString(regexp.QuoteMeta(rest[:open]))
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("filename template %q: unclosed {", spec)
+		}
+		field, layout, hasLayout := strings.Cut(rest[open+1:open+end], ":")
+		if !templateField.MatchString(field) {
+			return nil, fmt.Errorf("filename template %q: invalid field name %q", spec, field)
+		}
+		if _, ok := t.layouts[field]; ok {
+			return nil, fmt.Errorf("filename template %q: field %s appears twice", spec, field)
+		}
+		t.layouts[field] = layout
+		if hasLayout {
+			if layout == "" {
+				return nil, fmt.Errorf("filename template %q: empty layout for field %s", spec, field)
+			}
+			b.WriteString("(?P<" + field + ">" + layoutToRegexp(layout) + ")")
+		} else {
+			b.WriteString("(?P<" + field + ">.+?)")
+		}
+		rest = rest[open+end+1:]
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("filename template %q: %w", spec, err)
+	}
+	t.re = re
+	return t, nil
+}
+
+// layoutToRegexp translates a time layout into a regular expression matching
+// the same number of digits and any run of letters.
+func layoutToRegexp(layout string) string {
+	var b strings.Builder
+	for i := 0; i < len(layout); {
+		j := i + 1
+		switch c := layout[i]; {
+		case c >= '0' && c <= '9':
+			for j < len(layout) && layout[j] >= '0' && layout[j] <= '9' {
+				j++
+			}
+			fmt.Fprintf(&b, `\d{%d}`, j-i)
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			for j < len(layout) && (layout[j] >= 'A' && layout[j] <= 'Z' || layout[j] >= 'a' && layout[j] <= 'z') {
+				j++
+			}
+			b.WriteString("[A-Za-z]+")
+		default:
+			b.WriteString(regexp.QuoteMeta(layout[i:j]))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// String returns the template as written.
+func (t *FilenameTemplate) String() string {
+	return t.spec
+}
+
+// Parse returns the fields of name, a file or folder name. The template is
+// matched against the name without its extension, then against the whole
+// name, so templates need not spell the extension out but may.
+func (t *FilenameTemplate) Parse(name string) (map[string]string, error) {
+	match := t.re.FindStringSubmatch(strings.TrimSuffix(name, filepath.Ext(name)))
+	if match == nil {
+		match = t.re.FindStringSubmatch(name)
+	}
+	if match == nil {
+		return nil, fmt.Errorf("%s does not match %s", name, t.spec)
+	}
+	metadata := make(map[string]string)
+	for i, field := range t.re.SubexpNames() {
+		if field == "" {
+			continue
+		}
+		value := match[i]
+		if layout := t.layouts[field]; layout != "" {
+			date, err := time.Parse(layout, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: field %s: %v", name, field, err)
+			}
+			if date.Equal(date.Truncate(24 * time.Hour)) {
+				value = date.Format("2006-01-02")
+			} else {
+				value = date.Format("2006-01-02T15:04:05")
+			}
+		}
+		metadata[field] = value
+	}
+	return metadata, nil
+}
+
+// ExpandMetadata replaces the {field} references of format with the values of
+// metadata, as in "{sample}_{date}". A field missing from metadata is an
+// error.
+func ExpandMetadata(format string, metadata map[string]string) (string, error) {
+	var missing []string
+	expanded := metadataRef.ReplaceAllStringFunc(format, func(ref string) string {
+		field := ref[1 : len(ref)-1]
+		value, ok := metadata[field]
+		if !ok {
+			missing = append(missing, field)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("metadata has no field %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// TemplateMismatch reports a file whose name does not match the filename
+// template of its location.
+type TemplateMismatch struct {
+	FilePath string
+	Error    string
+}
+
+type cachedTemplate struct {
+	spec     string
+	template *FilenameTemplate
+}
+
+var (
+	templatesMu    sync.Mutex
+	templatesCache = make(map[int]cachedTemplate)
+)
+
+// LocationTemplate returns the parsed filename template of location, nil when
+// it has none or it does not compile. Templates are parsed once per location
+// and parsed again when FilenameTemplate changes; invalid ones are logged.
+func LocationTemplate(location FolderWatchingLocation) *FilenameTemplate {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	cached, ok := templatesCache[location.Id]
+	if ok && cached.spec == location.FilenameTemplate {
+		return cached.template
+	}
+	var template *FilenameTemplate
+	if location.FilenameTemplate != "" {
+		var err error
+		template, err = ParseFilenameTemplate(location.FilenameTemplate)
+		if err != nil {
+			log.Printf("location %s: %v", location.FolderPath, err)
+		}
+	}
+	templatesCache[location.Id] = cachedTemplate{spec: location.FilenameTemplate, template: template}
+	return template
+}
+
+// encodeMetadata returns metadata as stored in the files table.
+func encodeMetadata(metadata map[string]string) (string, error) {
+	if len(metadata) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(metadata)
+	return string(data), err
+}
+
+func SetFileMetadata(db *sql.DB, path string, metadata map[string]string) error {
+	encoded, err := encodeMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE files SET metadata = ? WHERE path = ?", encoded, path)
+	return err
+}
+
+// GetFileMetadata returns the metadata stored for path, nil when there is
+// none.
+func GetFileMetadata(db *sql.DB, path string) (map[string]string, error) {
+	var encoded string
+	err := db.QueryRow("SELECT metadata FROM files WHERE path = ?", path).Scan(&encoded)
+	if err != nil || encoded == "" {
+		return nil, err
+	}
+	var metadata map[string]string
+	err = json.Unmarshal([]byte(encoded), &metadata)
+	return metadata, err
+}
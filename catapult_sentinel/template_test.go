@@ -0,0 +1,130 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func TestFilenameTemplate_Parse(t *testing.T) {
+	tests := []struct {
+		template string
+		name     string
+		want     map[string]string
+	}{
+		{
+			"{sample}_{method}_{column}_{date:20060102}_{replicate}",
+			"1000ngHeLa_180SPD_ES906_20240214_01.raw",
+			map[string]string{"sample": "1000ngHeLa", "method": "180SPD", "column": "ES906", "date": "2024-02-14", "replicate": "01"},
+		},
+		{"{sample}_{replicate}", "HeLa_QC_01.d", map[string]string{"sample": "HeLa", "replicate": "QC_01"}},
+		{"{sample}.raw", "HeLa.raw", map[string]string{"sample": "HeLa"}},
+		{"{sample}-{date:2006-01-02T1504}", "HeLa-2024-02-14T0930.mzML", map[string]string{"sample": "HeLa", "date": "2024-02-14T09:30:00"}},
+		{"{sample}_{date:02Jan2006}", "HeLa_14Feb2024.raw", map[string]string{"sample": "HeLa", "date": "2024-02-14"}},
+		{"{sample}_{date:20060102}", "HeLa_20241399.raw", nil},
+		{"{sample}_{method}_{replicate}", "HeLa_01.raw", nil},
+		{"QC_{replicate}", "HeLa_01.raw", nil},
+	}
+	for _, tt := range tests {
+		template, err := ParseFilenameTemplate(tt.template)
+		if err != nil {
+			t.Errorf("ParseFilenameTemplate(%s) error: %v", tt.template, err)
+			continue
+		}
+		got, err := template.Parse(tt.name)
+		if (err != nil) != (tt.want == nil) || !maps.Equal(got, tt.want) {
+			t.Errorf("%s Parse(%s) = %v, %v, want %v", tt.template, tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"{sample", "{1st}", "{a}_{a}", "{date:}"} {
+		if _, err := ParseFilenameTemplate(spec); err == nil {
+			t.Errorf("ParseFilenameTemplate(%s) succeeded", spec)
+		}
+	}
+}
+
+func TestExpandMetadata(t *testing.T) {
+	metadata := map[string]string{"sample": "HeLa", "date": "2024-02-14"}
+	if got, err := ExpandMetadata("{sample} {date}", metadata); err != nil || got != "HeLa 2024-02-14" {
+		t.Errorf("ExpandMetadata() = %q, %v", got, err)
+	}
+	if _, err := ExpandMetadata("{sample}_{column}", metadata); err == nil {
+		t.Errorf("ExpandMetadata() with a missing field succeeded")
+	}
+}
+
+func TestScanner_FilenameMetadata(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	parsed := filepath.Join(root, "exp1", "HeLa_ES906_20240214_01.raw")
+	unparsed := filepath.Join(root, "exp1", "blank.raw")
+	writeTestFile(t, parsed, 10)
+	writeTestFile(t, unparsed, 10)
+	writeTestFile(t, filepath.Join(root, "exp1", "run.cat.yml"), 1)
+
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 51, FilenameTemplate: "{sample}_{column}_{date:20060102}_{replicate}"}
+	task, err := NewScanner(db).Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	want := map[string]string{"sample": "HeLa", "column": "ES906", "date": "2024-02-14", "replicate": "01"}
+	for _, file := range task.NewFile {
+		if file.FilePath == parsed && !maps.Equal(file.Metadata, want) || file.FilePath == unparsed && file.Metadata != nil {
+			t.Errorf("new file %s metadata = %v", file.FilePath, file.Metadata)
+		}
+	}
+	if len(task.Mismatched) != 1 || task.Mismatched[0].FilePath != unparsed {
+		t.Errorf("Scan() mismatched = %+v, want %s", task.Mismatched, unparsed)
+	}
+	if stored, err := GetFileMetadata(db, parsed); err != nil || !maps.Equal(stored, want) {
+		t.Errorf("GetFileMetadata() = %v, %v", stored, err)
+	}
+
+	location.ExperimentStrategy = ExperimentStrategyMetadata
+	location.ExperimentPattern = "{sample}_{date}"
+	resolver, err := NewExperimentResolver(location)
+	if err != nil {
+		t.Fatalf("NewExperimentResolver() error: %v", err)
+	}
+	if got := resolver.Resolve(parsed); got != "HeLa_2024-02-14" {
+		t.Errorf("Resolve(%s) = %q", parsed, got)
+	}
+	if got := resolver.Resolve(unparsed); got != "exp1" {
+		t.Errorf("Resolve(%s) = %q, want the parent fallback", unparsed, got)
+	}
+}
+
+func TestOutboxReplayer_FileMetadata(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+
+	file := File{FilePath: "/data/exp1/HeLa_01.raw", Size: 10, Metadata: map[string]string{"sample": "HeLa", "replicate": "01"}}
+	InsertFile(db, LocalFile{Path: file.FilePath, Size: 10})
+	EnqueueFile(db, OutboxCreate, file)
+	replayer := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), ""))
+	if applied, err := replayer.Replay(context.Background()); err != nil || applied != 1 {
+		t.Fatalf("Replay() = %d, %v", applied, err)
+	}
+	files := server.Records(catapulttest.Files)
+	if len(files) != 1 || fmt.Sprint(files[0]["metadata"]) != "map[replicate:01 sample:HeLa]" {
+		t.Fatalf("backend files = %v", files)
+	}
+
+	// an update without metadata keeps the backend copy
+	EnqueueFile(db, OutboxUpdate, File{FilePath: file.FilePath, Size: 20})
+	if _, err := replayer.Replay(context.Background()); err != nil {
+		t.Fatalf("Replay() error: %v", err)
+	}
+	files = server.Records(catapulttest.Files)
+	if fmt.Sprint(files[0]["size"]) != "20" || fmt.Sprint(files[0]["metadata"]) != "map[replicate:01 sample:HeLa]" {
+		t.Errorf("backend file after update = %v", files[0])
+	}
+}
@@ -0,0 +1,253 @@
+package catapult_sentinel
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	VendorThermo = "thermo"
+	VendorBruker = "bruker"
+	VendorSciex  = "sciex"
+	VendorWaters = "waters"
+)
+
+// AcquisitionFormat describes how one vendor format is laid out on disk.
+type AcquisitionFormat struct {
+	Vendor string
+	// Suffix is matched case-insensitively against the end of the name.
+	Suffix string
+	// Directory is true when the acquisition is a folder, whose content is
+	// never walked.
+	Directory bool
+	// Companions are suffixes appended to the acquisition path naming files
+	// that belong to it, such as ".scan" for Sciex ".wiff.scan".
+	Companions []string
+}
+
+// VendorRegistry classifies paths into vendor acquisition formats. Formats can
+// be registered at any time; when several match, the longest suffix wins.
+type VendorRegistry struct {
+	mu      sync.RWMutex
+	formats []AcquisitionFormat
+}
+
+func NewVendorRegistry(formats ...AcquisitionFormat) *VendorRegistry {
+	r := &VendorRegistry{}
+	for _, format := range formats {
+		r.Register(format)
+	}
+	return r
+}
+
+// DefaultVendorRegistry returns a registry knowing the Thermo, Bruker, Sciex
+// and Waters formats.
+func DefaultVendorRegistry() *VendorRegistry {
+	return NewVendorRegistry(
+		AcquisitionFormat{Vendor: VendorThermo, Suffix: ".raw"},
+		AcquisitionFormat{Vendor: VendorWaters, Suffix: ".raw", Directory: true},
+		AcquisitionFormat{Vendor: VendorBruker, Suffix: ".d", Directory: true},
+		AcquisitionFormat{Vendor: VendorSciex, Suffix: ".wiff", Companions: []string{".scan"}},
+		AcquisitionFormat{Vendor: VendorSciex, Suffix: ".wiff2", Companions: []string{".scan"}},
+	)
+}
+
+var defaultVendors = DefaultVendorRegistry()
+
+func (r *VendorRegistry) Register(format AcquisitionFormat) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.formats = append(r.formats, format)
+}
+
+// Classify returns the format of the acquisition at path, if any.
+func (r *VendorRegistry) Classify(path string, isDir bool) (AcquisitionFormat, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name := strings.ToLower(filepath.Base(path))
+	var best AcquisitionFormat
+	found := false
+	for _, format := range r.formats {
+		suffix := strings.ToLower(format.Suffix)
+		if format.Directory != isDir || !strings.HasSuffix(name, suffix) || len(name) == len(suffix) {
+			continue
+		}
+		if !found || len(suffix) > len(best.Suffix) {
+			best = format
+			found = true
+		}
+	}
+	return best, found
+}
+
+// CompanionOf returns the acquisition path a companion file belongs to.
+func (r *VendorRegistry) CompanionOf(path string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name := strings.ToLower(path)
+	for _, format := range r.formats {
+		for _, companion := range format.Companions {
+			suffix := strings.ToLower(format.Suffix + companion)
+			if strings.HasSuffix(name, suffix) && len(filepath.Base(name)) > len(suffix) {
+				return path[:len(path)-len(companion)], true
+			}
+		}
+	}
+	return "", false
+}
+
+// CompanionPaths returns the paths companion files of the acquisition at path
+// would have.
+func (r *VendorRegistry) CompanionPaths(path string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var paths []string
+	name := strings.ToLower(filepath.Base(path))
+	for _, format := range r.formats {
+		suffix := strings.ToLower(format.Suffix)
+		if format.Directory || !strings.HasSuffix(name, suffix) || len(name) == len(suffix) {
+			continue
+		}
+		for _, companion := range format.Companions {
+			paths = append(paths, path+companion)
+		}
+	}
+	return paths
+}
+
+// IsDirectoryAcquisition reports whether a directory with this name is a single
+// acquisition stored as a folder, such as a Bruker .d folder, rather than a
+// folder holding acquisitions.
+func IsDirectoryAcquisition(name string) bool {
+	_, ok := defaultVendors.Classify(name, true)
+	return ok
+}
+
+// groupCompanions removes companion files from files and returns them keyed by
+// the acquisition they belong to. Companions whose acquisition is not there
+// yet are dropped as well, they are picked up with it once it shows up.
+func (r *VendorRegistry) groupCompanions(files map[string]os.FileInfo) map[string]map[string]os.FileInfo {
+	companions := make(map[string]map[string]os.FileInfo)
+	for path, info := range files {
+		if info.IsDir() {
+			continue
+		}
+		primary, ok := r.CompanionOf(path)
+		if !ok {
+			continue
+		}
+		delete(files, path)
+		if _, ok := files[primary]; !ok {
+			continue
+		}
+		if companions[primary] == nil {
+			companions[primary] = make(map[string]os.FileInfo)
+		}
+		companions[primary][path] = info
+	}
+	return companions
+}
+
+// DominantVendor returns the vendor of most acquisitions in files, breaking
+// ties by name. It returns an empty string when no file is recognised.
+func (r *VendorRegistry) DominantVendor(files map[string]os.FileInfo) string {
+	counts := make(map[string]int)
+	for path, info := range files {
+		if format, ok := r.Classify(path, info.IsDir()); ok {
+			counts[format.Vendor]++
+		}
+	}
+	vendors := make([]string, 0, len(counts))
+	for vendor := range counts {
+		vendors = append(vendors, vendor)
+	}
+	sort.Slice(vendors, func(i, j int) bool {
+		if counts[vendors[i]] != counts[vendors[j]] {
+			return counts[vendors[i]] > counts[vendors[j]]
+		}
+		return vendors[i] < vendors[j]
+	})
+	if len(vendors) == 0 {
+		return ""
+	}
+	return vendors[0]
+}
+
+func GetExperimentVendor(db *sql.DB, name string) (string, error) {
+	var vendor string
+	err := db.QueryRow("SELECT vendor FROM experiment_vendors WHERE name = ?", name).Scan(&vendor)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return vendor, err
+}
+
+func SetExperimentVendor(db Execer, name string, vendor string) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO experiment_vendors (name, vendor) VALUES (?, ?)", name, vendor)
+	return err
+}
+
+// experimentVendors returns the experiments whose dominant vendor differs from
+// the one last reported. Acquisitions belong to the experiment named by the
+// experiment strategy of the location.
+func (s *Scanner) experimentVendors(location FolderWatchingLocation, currentFiles map[string]os.FileInfo) ([]Experiment, error) {
+	resolver := s.experimentResolver(location)
+	byExperiment := make(map[string]map[string]os.FileInfo)
+	for path, info := range currentFiles {
+		name := resolver.Name(path)
+		if name == "" {
+			continue
+		}
+		if byExperiment[name] == nil {
+			byExperiment[name] = make(map[string]os.FileInfo)
+		}
+		byExperiment[name][path] = info
+	}
+
+	experiments := []Experiment{}
+	for name, files := range byExperiment {
+		vendor := s.Vendors.DominantVendor(files)
+		if vendor == "" {
+			continue
+		}
+		known, err := GetExperimentVendor(s.DB, name)
+		if err != nil {
+			return nil, err
+		}
+		if known == vendor {
+			continue
+		}
+		experiment := Experiment{ExperimentName: name, Vendor: vendor}
+		if err := s.recordExperimentVendor(experiment); err != nil {
+			return nil, err
+		}
+		experiments = append(experiments, experiment)
+	}
+	sort.Slice(experiments, func(i, j int) bool {
+		return experiments[i].ExperimentName < experiments[j].ExperimentName
+	})
+	return experiments, nil
+}
+
+// recordExperimentVendor stores the vendor of experiment and, when Outbox is
+// set, queues the experiment in a single transaction.
+func (s *Scanner) recordExperimentVendor(experiment Experiment) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := SetExperimentVendor(tx, experiment.ExperimentName, experiment.Vendor); err != nil {
+		return err
+	}
+	if s.Outbox {
+		if err := EnqueueExperiment(tx, OutboxCreate, experiment); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
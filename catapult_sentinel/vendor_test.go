@@ -0,0 +1,128 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/noatgnu/catapultSentinel/catapult_sentinel/catapulttest"
+)
+
+func TestVendorRegistry_Classify(t *testing.T) {
+	registry := DefaultVendorRegistry()
+	tests := []struct {
+		path   string
+		isDir  bool
+		vendor string
+		ok     bool
+	}{
+		{"exp/sample_01.raw", false, VendorThermo, true},
+		{"exp/sample_01.RAW", true, VendorWaters, true},
+		{"exp/sample_01.d", true, VendorBruker, true},
+		{"exp/sample_01.d", false, "", false},
+		{"exp/sample_01.wiff", false, VendorSciex, true},
+		{"exp/sample_01.wiff2", false, VendorSciex, true},
+		{"exp/sample_01.wiff.scan", false, "", false},
+		{"exp/.raw", false, "", false},
+		{"exp/run.cat.yml", false, "", false},
+	}
+	for _, tt := range tests {
+		format, ok := registry.Classify(tt.path, tt.isDir)
+		if ok != tt.ok || format.Vendor != tt.vendor {
+			t.Errorf("Classify(%q, %v) = %q, %v, want %q, %v", tt.path, tt.isDir, format.Vendor, ok, tt.vendor, tt.ok)
+		}
+	}
+
+	primary, ok := registry.CompanionOf("exp/sample_01.WIFF.scan")
+	if !ok || primary != "exp/sample_01.WIFF" {
+		t.Errorf("CompanionOf() = %q, %v, want exp/sample_01.WIFF", primary, ok)
+	}
+	if _, ok := registry.CompanionOf("exp/sample_01.scan"); ok {
+		t.Errorf("CompanionOf() matched a file without acquisition suffix")
+	}
+}
+
+func TestScanner_VendorGrouping(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	exp := filepath.Join(root, "exp1")
+	writeTestFile(t, filepath.Join(exp, "sample_01.wiff"), 10)
+	writeTestFile(t, filepath.Join(exp, "sample_01.wiff.scan"), 90)
+	writeTestFile(t, filepath.Join(exp, "sample_02.wiff"), 5)
+	writeTestFile(t, filepath.Join(exp, "orphan.wiff.scan"), 7)
+	writeTestFile(t, filepath.Join(exp, "blank.raw"), 3)
+	writeTestFile(t, filepath.Join(exp, "waters.raw", "_FUNC001.DAT"), 4)
+
+	scanner := NewScanner(db)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	task, err := scanner.Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, file := range task.NewFile {
+		sizes[filepath.Base(file.FilePath)] = file.Size
+	}
+	want := map[string]int64{"sample_01.wiff": 100, "sample_02.wiff": 5, "blank.raw": 3, "waters.raw": 4}
+	if len(sizes) != len(want) {
+		t.Fatalf("Scan() new files = %v, want %v", sizes, want)
+	}
+	for name, size := range want {
+		if sizes[name] != size {
+			t.Errorf("size of %s = %d, want %d", name, sizes[name], size)
+		}
+	}
+	if len(task.Experiments) != 1 || task.Experiments[0].ExperimentName != exp || task.Experiments[0].Vendor != VendorSciex {
+		t.Fatalf("Scan() experiments = %+v, want %s from %s", task.Experiments, VendorSciex, exp)
+	}
+
+	task, err = scanner.Scan(context.Background(), location)
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if len(task.Experiments) != 0 {
+		t.Errorf("Scan() second scan experiments = %+v, want none", task.Experiments)
+	}
+}
+
+func TestScanner_RegisteredFormat(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", "run.acq", "data.bin"), 12)
+	writeTestFile(t, filepath.Join(root, "exp1", "run.acq", "meta.bin"), 3)
+
+	scanner := NewScanner(db)
+	scanner.Vendors.Register(AcquisitionFormat{Vendor: "acme", Suffix: ".acq", Directory: true})
+	task, err := scanner.Scan(context.Background(), FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1})
+	if err != nil {
+		t.Fatalf("Scan() error: %v", err)
+	}
+	if len(task.NewFile) != 1 || task.NewFile[0].Size != 15 {
+		t.Fatalf("Scan() new files = %+v, want run.acq with size 15", task.NewFile)
+	}
+	if len(task.Experiments) != 1 || task.Experiments[0].Vendor != "acme" {
+		t.Errorf("Scan() experiments = %+v, want acme", task.Experiments)
+	}
+}
+
+func TestOutboxReplayer_ExperimentVendor(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := catapulttest.NewServer()
+	defer server.Close()
+	id := server.Add(catapulttest.Experiments, catapulttest.Record{"experiment_name": "exp1", "sample_count": 12})
+
+	if err := EnqueueExperiment(db, OutboxCreate, Experiment{ExperimentName: "exp1", Vendor: VendorBruker}); err != nil {
+		t.Fatalf("EnqueueExperiment() error: %v", err)
+	}
+	if _, err := NewOutboxReplayer(db, NewCatapultBackend(server.BaseUrl(), "")).Replay(context.Background()); err != nil {
+		t.Fatalf("Replay() error: %v", err)
+	}
+	record, _ := server.Get(catapulttest.Experiments, id)
+	if record["vendor"] != VendorBruker || fmt.Sprint(record["sample_count"]) != "12" {
+		t.Errorf("stored experiment = %v, want bruker with sample_count 12", record)
+	}
+}
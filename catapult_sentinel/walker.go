@@ -0,0 +1,184 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultWalkWorkers is the number of directories a Walker lists at once.
+const DefaultWalkWorkers = 8
+
+// WalkEntry is one entry found by a Walker. Err is set when the entry could not
+// be read or, for a directory, listed.
+type WalkEntry struct {
+	Path string
+	Info os.FileInfo
+	Err  error
+}
+
+// ListFunc returns the entries of the directory dir.
+type ListFunc func(dir string, info os.FileInfo) ([]WalkEntry, error)
+
+// Walker walks directory trees listing several directories concurrently,
+// which hides most of the latency of network shares. Workers bounds the
+// directories being listed at once across every walk sharing the Walker, so a
+// single Walker per location keeps the load on its disk bounded.
+type Walker struct {
+	Workers int
+
+	once sync.Once
+	sem  chan struct{}
+}
+
+func NewWalker(workers int) *Walker {
+	return &Walker{Workers: workers}
+}
+
+func (w *Walker) acquire(ctx context.Context) error {
+	w.once.Do(func() {
+		workers := w.Workers
+		if workers <= 0 {
+			workers = DefaultWalkWorkers
+		}
+		w.sem = make(chan struct{}, workers)
+	})
+	select {
+	case w.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Walker) release() {
+	<-w.sem
+}
+
+// ReadDir lists dir from disk. Entries that vanish while being listed are left
+// out.
+func ReadDir(dir string, _ os.FileInfo) ([]WalkEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	walkEntries := make([]WalkEntry, 0, len(entries))
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		walkEntries = append(walkEntries, WalkEntry{Path: path, Info: info, Err: err})
+	}
+	return walkEntries, nil
+}
+
+type walkNode struct {
+	entry    WalkEntry
+	children []*walkNode
+}
+
+// Walk returns root and every entry below it in lexical order, each directory
+// followed by its content, whatever order the directories were listed in.
+// list defaults to ReadDir. A directory is entered when descend, which
+// defaults to entering all directories, returns true. Only an error on root
+// or the cancellation of ctx fails the walk; directories that cannot be
+// listed are returned with Err set.
+func (w *Walker) Walk(ctx context.Context, root string, list ListFunc, descend func(WalkEntry) bool) ([]WalkEntry, error) {
+	if list == nil {
+		list = ReadDir
+	}
+	if descend == nil {
+		descend = func(entry WalkEntry) bool { return entry.Info.IsDir() }
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	top := &walkNode{entry: WalkEntry{Path: root, Info: info}}
+	if !info.IsDir() {
+		return []WalkEntry{top.entry}, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var walkErr error
+	var visit func(node *walkNode)
+	visit = func(node *walkNode) {
+		defer wg.Done()
+		if err := w.acquire(ctx); err != nil {
+			return
+		}
+		entries, err := list(node.entry.Path, node.entry.Info)
+		w.release()
+		if err != nil {
+			if node == top {
+				mu.Lock()
+				walkErr = err
+				mu.Unlock()
+				cancel()
+				return
+			}
+			node.entry.Err = err
+			return
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+		node.children = make([]*walkNode, len(entries))
+		for i, entry := range entries {
+			child := &walkNode{entry: entry}
+			node.children[i] = child
+			if entry.Err == nil && entry.Info.IsDir() && descend(entry) {
+				wg.Add(1)
+				go visit(child)
+			}
+		}
+	}
+	wg.Add(1)
+	go visit(top)
+	wg.Wait()
+
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var entries []WalkEntry
+	var flatten func(node *walkNode)
+	flatten = func(node *walkNode) {
+		entries = append(entries, node.entry)
+		for _, child := range node.children {
+			flatten(child)
+		}
+	}
+	flatten(top)
+	return entries, nil
+}
+
+var defaultWalker = NewWalker(DefaultWalkWorkers)
+
+// walker returns the Walker shared by every walk of location.
+func (s *Scanner) walker(location FolderWatchingLocation) *Walker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.walkers == nil {
+		s.walkers = make(map[int]*Walker)
+	}
+	walker, ok := s.walkers[location.Id]
+	if !ok {
+		workers := s.WalkWorkers
+		if limit, ok := s.LocationWalkWorkers[location.Id]; ok {
+			workers = limit
+		}
+		walker = NewWalker(workers)
+		s.walkers[location.Id] = walker
+	}
+	return walker
+}
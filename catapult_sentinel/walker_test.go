@@ -0,0 +1,116 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWalker_Order(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"b/2.raw", "b/1.raw", "a/c/3.raw", "a/4.raw", "z.raw", "a.d/inner.tdf"} {
+		writeTestFile(t, filepath.Join(root, name), 1)
+	}
+	var want []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		want = append(want, path)
+		if info.IsDir() && info.Name() == "a.d" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	descend := func(entry WalkEntry) bool { return filepath.Ext(entry.Path) != ".d" }
+	for i := 0; i < 10; i++ {
+		entries, err := NewWalker(4).Walk(context.Background(), root, nil, descend)
+		if err != nil {
+			t.Fatalf("Walk() error: %v", err)
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("Walk() returned %d entries, want %d", len(entries), len(want))
+		}
+		for j, entry := range entries {
+			if entry.Path != want[j] {
+				t.Fatalf("Walk() entry %d = %s, want %s", j, entry.Path, want[j])
+			}
+		}
+	}
+}
+
+func TestWalker_BoundedConcurrency(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 20; i++ {
+		writeTestFile(t, filepath.Join(root, fmt.Sprintf("dir%02d", i), "sample.raw"), 1)
+	}
+
+	var running, peak int32
+	list := func(dir string, info os.FileInfo) ([]WalkEntry, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return ReadDir(dir, info)
+	}
+	entries, err := NewWalker(3).Walk(context.Background(), root, list, nil)
+	if err != nil {
+		t.Fatalf("Walk() error: %v", err)
+	}
+	if len(entries) != 41 {
+		t.Errorf("Walk() returned %d entries, want 41", len(entries))
+	}
+	if peak > 3 || peak < 2 {
+		t.Errorf("peak concurrent listings = %d, want between 2 and 3", peak)
+	}
+}
+
+func TestWalker_Errors(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "good", "a.raw"), 1)
+	writeTestFile(t, filepath.Join(root, "bad", "b.raw"), 1)
+	failure := errors.New("listing failed")
+	list := func(dir string, info os.FileInfo) ([]WalkEntry, error) {
+		if filepath.Base(dir) == "bad" {
+			return nil, failure
+		}
+		return ReadDir(dir, info)
+	}
+
+	entries, err := NewWalker(2).Walk(context.Background(), root, list, nil)
+	if err != nil {
+		t.Fatalf("Walk() error: %v", err)
+	}
+	if len(entries) != 4 || entries[1].Path != filepath.Join(root, "bad") || !errors.Is(entries[1].Err, failure) {
+		t.Errorf("Walk() = %+v, want bad with its listing error", entries)
+	}
+
+	if _, err := NewWalker(2).Walk(context.Background(), filepath.Join(root, "missing"), nil, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Walk() on a missing root = %v, want not exist", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewWalker(2).Walk(ctx, root, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Walk() with cancelled context = %v, want canceled", err)
+	}
+}
+
+func TestScanner_LocationWalker(t *testing.T) {
+	scanner := NewScanner(nil)
+	scanner.LocationWalkWorkers[2] = 1
+	first := scanner.walker(FolderWatchingLocation{Id: 1})
+	if first != scanner.walker(FolderWatchingLocation{Id: 1}) || first.Workers != DefaultWalkWorkers {
+		t.Errorf("walker() for location 1 = %+v, want one shared walker with %d workers", first, DefaultWalkWorkers)
+	}
+	if limited := scanner.walker(FolderWatchingLocation{Id: 2}); limited.Workers != 1 {
+		t.Errorf("walker() for location 2 has %d workers, want 1", limited.Workers)
+	}
+}
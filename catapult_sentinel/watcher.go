@@ -0,0 +1,233 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultReconcileInterval is how often a watched location is walked in full
+// to catch events the kernel dropped or never sent.
+const DefaultReconcileInterval = 30 * time.Minute
+
+var (
+	// ErrWatchUnsupported is returned when file system events are not
+	// available on this platform.
+	ErrWatchUnsupported = errors.New("catapult: file system events not supported")
+	// ErrWatchLimit is returned when the kernel refuses more watches.
+	ErrWatchLimit = errors.New("catapult: file system watch limit reached")
+)
+
+// fsEvent is one change reported by the platform watcher.
+type fsEvent struct {
+	Path    string
+	IsDir   bool
+	Created bool
+	// Overflow is set when events were dropped and the tree has to be walked.
+	Overflow bool
+	Err      error
+}
+
+// fsWatcher watches single directories, not trees.
+type fsWatcher interface {
+	Add(dir string) error
+	Events() <-chan fsEvent
+	Close() error
+}
+
+// Watcher turns file system events of a location into tasks. Each Poll scans
+// the paths changed since the previous one, and runs a FullScan on the first
+// Poll, after dropped events and every ReconcileInterval. Network folders,
+// platforms without events and trees exceeding the watch limit are walked
+// with Scan on every other Poll.
+type Watcher struct {
+	Scanner           *Scanner
+	Location          FolderWatchingLocation
+	ReconcileInterval time.Duration
+
+	mu       sync.Mutex
+	fs       fsWatcher
+	dirty    map[string]struct{}
+	full     bool
+	polling  bool
+	lastFull time.Time
+}
+
+func NewWatcher(scanner *Scanner, location FolderWatchingLocation) *Watcher {
+	return &Watcher{
+		Scanner:           scanner,
+		Location:          location,
+		ReconcileInterval: DefaultReconcileInterval,
+		dirty:             make(map[string]struct{}),
+		full:              true,
+	}
+}
+
+// Start sets up the watches. It never fails: when events cannot be used the
+// watcher polls instead.
+func (w *Watcher) Start() {
+	if w.Location.NetworkFolder {
+		w.fallback(errors.New("network folder"))
+		return
+	}
+	watcher, err := newFSWatcher()
+	if err != nil {
+		w.fallback(err)
+		return
+	}
+	w.mu.Lock()
+	w.fs = watcher
+	w.mu.Unlock()
+	if err := w.watchTree(w.Location.FolderPath, false); err != nil {
+		w.fallback(err)
+		return
+	}
+	go w.run(watcher)
+}
+
+// Polling reports whether the watcher walks the location on every Poll.
+func (w *Watcher) Polling() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.polling
+}
+
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	watcher := w.fs
+	w.fs = nil
+	w.mu.Unlock()
+	if watcher == nil {
+		return nil
+	}
+	return watcher.Close()
+}
+
+// fallback stops using events for the rest of the watcher life.
+func (w *Watcher) fallback(reason error) {
+	log.Printf("watching %s by polling: %v", w.Location.FolderPath, reason)
+	w.Close()
+	w.mu.Lock()
+	w.polling = true
+	w.mu.Unlock()
+}
+
+// watchTree adds a watch on dir and every directory below it, including the
+// content of directory acquisitions whose files grow in place. Ignored
+// directories are skipped with their content, so that large ignored trees do
+// not use up the watch limit. When markDirty is set the files found are queued
+// as well, since they may have been written before the watch existed.
+func (w *Watcher) watchTree(dir string, markDirty bool) error {
+	ignores := w.Scanner.ignoreMatcher(w.Location)
+	if ignores.Ignored(dir, true) {
+		return nil
+	}
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// unreadable directories are reported by the next full scan
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if path != dir && ignores.match(path, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			if markDirty {
+				w.markDirty(path)
+			}
+			return nil
+		}
+		w.mu.Lock()
+		watcher := w.fs
+		w.mu.Unlock()
+		if watcher == nil {
+			return filepath.SkipAll
+		}
+		if err := watcher.Add(path); err != nil {
+			if errors.Is(err, ErrWatchLimit) || path == dir {
+				return err
+			}
+			return nil
+		}
+		if markDirty {
+			w.markDirty(path)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) markDirty(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirty[path] = struct{}{}
+}
+
+func (w *Watcher) run(watcher fsWatcher) {
+	for event := range watcher.Events() {
+		switch {
+		case event.Err != nil:
+			w.fallback(event.Err)
+			return
+		case event.Overflow:
+			w.mu.Lock()
+			w.full = true
+			w.mu.Unlock()
+		case event.IsDir && event.Created:
+			if err := w.watchTree(event.Path, true); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				w.fallback(err)
+				return
+			}
+		default:
+			w.markDirty(event.Path)
+		}
+	}
+}
+
+// Poll returns the task for the changes since the previous Poll.
+func (w *Watcher) Poll(ctx context.Context) (Task, error) {
+	w.mu.Lock()
+	now := w.Scanner.clock()
+	full := w.full || now.Sub(w.lastFull) >= w.ReconcileInterval
+	polling := w.polling
+	paths := make([]string, 0, len(w.dirty))
+	for path := range w.dirty {
+		paths = append(paths, path)
+	}
+	w.dirty = make(map[string]struct{})
+	// directories created while events were dropped, or no longer ignored,
+	// have no watch yet
+	rewatch := full && !w.polling && w.fs != nil && !w.lastFull.IsZero()
+	if full {
+		w.full = false
+		w.lastFull = now
+	}
+	w.mu.Unlock()
+
+	switch {
+	case !full && polling:
+		return w.Scanner.Scan(ctx, w.Location)
+	case !full:
+		return w.Scanner.ScanPaths(ctx, w.Location, paths)
+	}
+	if rewatch {
+		if err := w.watchTree(w.Location.FolderPath, false); err != nil {
+			w.fallback(err)
+		}
+	}
+	task, err := w.Scanner.FullScan(ctx, w.Location)
+	if err != nil {
+		w.mu.Lock()
+		w.full = true
+		w.mu.Unlock()
+	}
+	return task, err
+}
@@ -0,0 +1,130 @@
+//go:build linux
+
+package catapult_sentinel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// inotify is the Linux fsWatcher. The descriptor is non-blocking and wrapped in
+// an os.File so reads go through the runtime poller and Close unblocks them.
+type inotify struct {
+	fd     int
+	file   *os.File
+	mu     sync.Mutex
+	dirs   map[int]string
+	events chan fsEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newFSWatcher() (fsWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotify{
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int]string),
+		events: make(chan fsEvent, 1024),
+		done:   make(chan struct{}),
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotify) Add(dir string) error {
+	wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyMask|unix.IN_ONLYDIR)
+	if errors.Is(err, unix.ENOSPC) {
+		return ErrWatchLimit
+	}
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	w.mu.Lock()
+	w.dirs[wd] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *inotify) Events() <-chan fsEvent {
+	return w.events
+}
+
+func (w *inotify) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err
+}
+
+func (w *inotify) send(event fsEvent) bool {
+	select {
+	case w.events <- event:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *inotify) read() {
+	defer close(w.events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.send(fsEvent{Err: err})
+			}
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[offset:])))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			length := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			name := string(bytes.TrimRight(buf[offset+unix.SizeofInotifyEvent:offset+unix.SizeofInotifyEvent+length], "\x00"))
+			offset += unix.SizeofInotifyEvent + length
+
+			if mask&unix.IN_Q_OVERFLOW != 0 {
+				if !w.send(fsEvent{Overflow: true}) {
+					return
+				}
+				continue
+			}
+			w.mu.Lock()
+			dir, ok := w.dirs[wd]
+			if mask&unix.IN_IGNORED != 0 {
+				delete(w.dirs, wd)
+			}
+			w.mu.Unlock()
+			if !ok || mask&unix.IN_IGNORED != 0 {
+				continue
+			}
+			path := dir
+			if name != "" {
+				path = filepath.Join(dir, name)
+			}
+			event := fsEvent{
+				Path:    path,
+				IsDir:   mask&unix.IN_ISDIR != 0,
+				Created: mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0,
+			}
+			if !w.send(event) {
+				return
+			}
+		}
+	}
+}
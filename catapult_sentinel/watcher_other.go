@@ -0,0 +1,7 @@
+//go:build !linux
+
+package catapult_sentinel
+
+func newFSWatcher() (fsWatcher, error) {
+	return nil, ErrWatchUnsupported
+}
@@ -0,0 +1,155 @@
+package catapult_sentinel
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestScanner_ScanPaths(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.raw"), 10)
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_02.wiff"), 10)
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_02.wiff.scan"), 5)
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_03.d", "analysis.tdf"), 7)
+	writeTestFile(t, filepath.Join(root, "exp1", "untouched.raw"), 10)
+
+	scanner := NewScanner(db)
+	location := FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1}
+	task, err := scanner.ScanPaths(context.Background(), location, []string{
+		filepath.Join(root, "exp1", "sample_01.raw"),
+		filepath.Join(root, "exp1", "sample_02.wiff.scan"),
+		filepath.Join(root, "exp1", "sample_03.d", "analysis.tdf"),
+		filepath.Join(root, "exp1", "gone.raw"),
+		filepath.Join(root, "exp1"),
+	})
+	if err != nil {
+		t.Fatalf("ScanPaths() error: %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, file := range task.NewFile {
+		sizes[filepath.Base(file.FilePath)] = file.Size
+	}
+	want := map[string]int64{"sample_01.raw": 10, "sample_02.wiff": 15, "sample_03.d": 7}
+	if len(sizes) != len(want) {
+		t.Fatalf("ScanPaths() new files = %v, want %v", sizes, want)
+	}
+	for name, size := range want {
+		if sizes[name] != size {
+			t.Errorf("size of %s = %d, want %d", name, sizes[name], size)
+		}
+	}
+
+	// files still being tracked for stability are observed without events
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.raw"), 20)
+	task, err = scanner.ScanPaths(context.Background(), location, nil)
+	if err != nil {
+		t.Fatalf("ScanPaths() error: %v", err)
+	}
+	if len(task.ChangedFile) != 1 || task.ChangedFile[0].Size != 20 {
+		t.Errorf("ScanPaths() changed = %+v, want sample_01.raw with size 20", task.ChangedFile)
+	}
+}
+
+func TestWatcher_NetworkFolderPolls(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "sample_01.raw"), 10)
+
+	watcher := NewWatcher(NewScanner(db), FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1, NetworkFolder: true})
+	watcher.Start()
+	defer watcher.Close()
+	if !watcher.Polling() {
+		t.Fatalf("Polling() = false for a network folder")
+	}
+	task, err := watcher.Poll(context.Background())
+	if err != nil || len(task.NewFile) != 1 {
+		t.Fatalf("Poll() = %+v, %v, want 1 new file", task, err)
+	}
+}
+
+func TestWatcher_Events(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("file system events are only used on linux")
+	}
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.raw"), 10)
+
+	watcher := NewWatcher(NewScanner(db), FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1})
+	watcher.ReconcileInterval = time.Hour
+	watcher.Start()
+	defer watcher.Close()
+	if watcher.Polling() {
+		t.Fatalf("Polling() = true for a local folder")
+	}
+	task, err := watcher.Poll(context.Background())
+	if err != nil || len(task.NewFile) != 1 {
+		t.Fatalf("first Poll() = %+v, %v, want the initial walk", task, err)
+	}
+
+	created := filepath.Join(root, "exp2", "sample_02.raw")
+	writeTestFile(t, created, 10)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		task, err = watcher.Poll(context.Background())
+		if err != nil {
+			t.Fatalf("Poll() error: %v", err)
+		}
+		if len(task.NewFile) > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(task.NewFile) != 1 || task.NewFile[0].FilePath != created {
+		t.Errorf("Poll() new files = %+v, want %s", task.NewFile, created)
+	}
+}
+
+// recordingWatcher records the directories watched.
+type recordingWatcher struct {
+	dirs []string
+}
+
+func (r *recordingWatcher) Add(dir string) error {
+	r.dirs = append(r.dirs, dir)
+	return nil
+}
+
+func (r *recordingWatcher) Events() <-chan fsEvent { return nil }
+
+func (r *recordingWatcher) Close() error { return nil }
+
+func TestWatcher_IgnoredTree(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "exp1", "sample_01.raw"), 10)
+	writeTestFile(t, filepath.Join(root, "exp1", "tmp~", "deep", "sample_02.raw"), 10)
+	writeTestFile(t, filepath.Join(root, "scratch", "deep", "sample_03.raw"), 10)
+	os.WriteFile(filepath.Join(root, IgnoreFileName), []byte("scratch/\n"), 0o644)
+
+	recorder := &recordingWatcher{}
+	watcher := NewWatcher(NewScanner(db), FolderWatchingLocation{FolderPath: root, Extensions: "*", IgnoreTerm: "~", Id: 1})
+	watcher.fs = recorder
+	if err := watcher.watchTree(root, false); err != nil {
+		t.Fatalf("watchTree() error: %v", err)
+	}
+	if want := []string{root, filepath.Join(root, "exp1")}; !reflect.DeepEqual(recorder.dirs, want) {
+		t.Errorf("watchTree() watched %v, want %v", recorder.dirs, want)
+	}
+
+	// a directory created inside an ignored one is not watched either
+	recorder.dirs = nil
+	if err := watcher.watchTree(filepath.Join(root, "scratch", "deep"), true); err != nil || len(recorder.dirs) != 0 {
+		t.Errorf("watchTree() of an ignored directory watched %v, %v", recorder.dirs, err)
+	}
+}
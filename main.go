@@ -5,9 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/noatgnu/catapultSentinel/catapult_sentinel"
-	"gopkg.in/yaml.v2"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,31 +16,22 @@ var token *string
 var backendURL *string
 var catapultBackend *catapult_sentinel.CatapultBackend
 
-func loadConfigYaml(filePath string, folderWatchingLocation int) {
+func loadConfigYaml(filePath string, folderWatchingLocation int, experimentName string) {
 	fmt.Printf("loading config file %s\n", filePath)
-	configData := make(map[string]interface{})
-	file, err := os.Open(filePath)
+	spec, configData, err := catapult_sentinel.LoadRunConfig(filePath)
 	if err != nil {
-		log.Printf("Error loading yaml file %s", filePath)
+		log.Printf("Error loading yaml file %s:\n%v", filePath, err)
 		return
 	}
-	defer file.Close()
-	decoder := yaml.NewDecoder(file)
-	err = decoder.Decode(&configData)
-	if err != nil {
-		log.Printf("Error decoding yaml file %s", filePath)
-		return
-	}
-	if configData["cat_ready"] == true {
+	if spec.CatReady {
 		fmt.Printf("config file %s is ready\n", filePath)
-		parentFolder := filepath.Dir(filePath)
-
-		exp := catapultBackend.GetExperimentByName(parentFolder)
+		exp := catapultBackend.GetExperimentByName(experimentName)
 		config := catapult_sentinel.CatapultRunConfig{
 			ConfigFilePath:         filePath,
 			FolderWatchingLocation: folderWatchingLocation,
 			Experiment:             exp.Id,
 			Content:                configData,
+			Spec:                   &spec,
 		}
 		catapultBackend.CreateCatapultRunConfig(config)
 		fmt.Printf("config file %s loaded successfully\n", filePath)
@@ -65,23 +54,32 @@ func getFolderSize(folderPath string) int64 {
 }
 
 func initialScan(folderWatchingLocation catapult_sentinel.FolderWatchingLocation) {
-	err := filepath.Walk(folderWatchingLocation.FolderPath, func(path string, info os.FileInfo, err error) error {
+	ignores, err := catapult_sentinel.NewIgnoreMatcher(folderWatchingLocation)
+	if err != nil {
+		log.Println(err)
+	}
+	experiments, err := catapult_sentinel.NewExperimentResolver(folderWatchingLocation)
+	if err != nil {
+		log.Println(err)
+	}
+	err = filepath.Walk(folderWatchingLocation.FolderPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if strings.Contains(info.Name(), folderWatchingLocation.IgnoreTerm) {
+		if ignores.Ignored(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		extension := filepath.Ext(info.Name())
-		if strings.Contains(folderWatchingLocation.Extensions, extension) {
+		if catapult_sentinel.LocationExtensions(folderWatchingLocation).Match(info.Name()) {
 			if strings.HasSuffix(info.Name(), ".converted.mzML") {
 				fileSize := info.Size()
 				fileLocation := path
-				if strings.HasSuffix(info.Name(), ".d") {
-					fileLocation = filepath.Dir(path)
+				if catapult_sentinel.IsDirectoryAcquisition(info.Name()) {
 					fileSize = getFolderSize(fileLocation)
 				}
-				exp := catapultBackend.GetExperimentByName(filepath.Dir(fileLocation))
+				exp := catapultBackend.GetExperimentByName(experiments.Name(fileLocation))
 				file := catapult_sentinel.File{
 					FilePath:               strings.Replace(fileLocation, folderWatchingLocation.FolderPath, "", 1),
 					FolderWatchingLocation: folderWatchingLocation.Id,
@@ -91,10 +89,14 @@ func initialScan(folderWatchingLocation catapult_sentinel.FolderWatchingLocation
 				catapultBackend.GetFile(file.FilePath)
 				newFile := catapultBackend.CreateFile(file)
 
-			} else if strings.HasSuffix(info.Name(), ".cat.yml") || strings.HasSuffix(info.Name(), ".cat.yaml") {
-				loadConfigYaml(path, folderWatchingLocation.Id)
 			}
 		}
+		if !info.IsDir() && catapult_sentinel.IsRunConfig(info.Name()) {
+			loadConfigYaml(path, folderWatchingLocation.Id, experiments.Name(path))
+		}
+		if info.IsDir() && catapult_sentinel.IsDirectoryAcquisition(info.Name()) {
+			return filepath.SkipDir
+		}
 		return nil
 	})
 	if err != nil {
@@ -109,6 +111,15 @@ func watchFolder(folderWatchingLocation catapult_sentinel.FolderWatchingLocation
 	}
 	defer db.Close()
 
+	ignores, err := catapult_sentinel.NewIgnoreMatcher(folderWatchingLocation)
+	if err != nil {
+		log.Println(err)
+	}
+	experiments, err := catapult_sentinel.NewExperimentResolver(folderWatchingLocation)
+	if err != nil {
+		log.Println(err)
+	}
+
 	ticker := time.NewTicker(10 * time.Second) // Adjust the interval as needed
 	defer ticker.Stop()
 
@@ -116,15 +127,24 @@ func watchFolder(folderWatchingLocation catapult_sentinel.FolderWatchingLocation
 		select {
 		case <-ticker.C:
 			currentFiles := make(map[string]os.FileInfo)
+			ignores.Reset()
+			experiments.Reset()
 			err := filepath.Walk(folderWatchingLocation.FolderPath, func(path string, info os.FileInfo, err error) error {
 				if err != nil {
 					return err
 				}
-				if !info.IsDir() && !strings.Contains(info.Name(), folderWatchingLocation.IgnoreTerm) {
+				if ignores.Ignored(path, info.IsDir()) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !info.IsDir() && (catapult_sentinel.LocationExtensions(folderWatchingLocation).Match(info.Name()) || catapult_sentinel.IsRunConfig(info.Name())) {
 					currentFiles[path] = info
 				}
-				if info.IsDir() && filepath.Ext(info.Name()) == ".d" {
+				if info.IsDir() && catapult_sentinel.IsDirectoryAcquisition(info.Name()) {
 					currentFiles[path] = info
+					return filepath.SkipDir
 				}
 				return nil
 			})
@@ -138,8 +158,8 @@ func watchFolder(folderWatchingLocation catapult_sentinel.FolderWatchingLocation
 				var size int64
 				var isFolder bool
 
-				if strings.HasSuffix(info.Name(), ".d") {
-					isFolder = false
+				if catapult_sentinel.IsDirectoryAcquisition(info.Name()) {
+					isFolder = true
 					size = getFolderSize(path)
 					lastModified = info.ModTime()
 				} else {
@@ -152,7 +172,7 @@ func watchFolder(folderWatchingLocation catapult_sentinel.FolderWatchingLocation
 				err := db.QueryRow("SELECT last_modified FROM files WHERE path = ?", path).Scan(&dbLastModified)
 				if err == sql.ErrNoRows {
 					// New file detected
-					handleNewFile(path, info, folderWatchingLocation)
+					handleNewFile(path, info, folderWatchingLocation, experiments)
 					_, err = db.Exec("INSERT INTO files (path, last_modified, size, is_folder) VALUES (?, ?, ?, ?)", path, lastModified, size, isFolder)
 					if err != nil {
 						log.Println(err)
@@ -165,16 +185,16 @@ func watchFolder(folderWatchingLocation catapult_sentinel.FolderWatchingLocation
 	}
 }
 
-func handleNewFile(path string, info os.FileInfo, folderWatchingLocation catapult_sentinel.FolderWatchingLocation) catapult_sentinel.File {
-	extension := filepath.Ext(info.Name())
-	if strings.Contains(folderWatchingLocation.Extensions, extension) {
+func handleNewFile(path string, info os.FileInfo, folderWatchingLocation catapult_sentinel.FolderWatchingLocation, experiments *catapult_sentinel.ExperimentResolver) catapult_sentinel.File {
+	if catapult_sentinel.IsRunConfig(info.Name()) {
+		loadConfigYaml(path, folderWatchingLocation.Id, experiments.Name(path))
+	} else if catapult_sentinel.LocationExtensions(folderWatchingLocation).Match(info.Name()) {
 		fileSize := info.Size()
 		fileLocation := path
-		if strings.HasSuffix(info.Name(), ".d") {
-			fileLocation = filepath.Dir(path)
+		if catapult_sentinel.IsDirectoryAcquisition(info.Name()) {
 			fileSize = getFolderSize(fileLocation)
 		}
-		exp := catapultBackend.GetExperimentByName(filepath.Dir(fileLocation))
+		exp := catapultBackend.GetExperimentByName(experiments.Name(fileLocation))
 		file := catapult_sentinel.File{
 			FilePath:               strings.Replace(fileLocation, folderWatchingLocation.FolderPath, "", 1),
 			FolderWatchingLocation: folderWatchingLocation.Id,
@@ -182,8 +202,6 @@ func handleNewFile(path string, info os.FileInfo, folderWatchingLocation catapul
 			Experiment:             exp.Id,
 		}
 		return catapultBackend.CreateFile(file)
-	} else if strings.HasSuffix(info.Name(), ".cat.yml") || strings.HasSuffix(info.Name(), ".cat.yaml") {
-		loadConfigYaml(path, folderWatchingLocation.Id)
 	}
 	return catapult_sentinel.File{}
 }
@@ -193,11 +211,7 @@ func main() {
 	token = flag.String("token", "", "The token")
 	flag.Parse()
 
-	catapultBackend = &catapult_sentinel.CatapultBackend{
-		Url:    *backendURL,
-		Client: &http.Client{},
-		Token:  *token,
-	}
+	catapultBackend = catapult_sentinel.NewCatapultBackend(*backendURL, *token)
 
 	folderWatchingLocations := catapultBackend.GetAllFolderWatchingLocations()
 
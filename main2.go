@@ -1,53 +1,85 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"github.com/noatgnu/catapultSentinel/catapult_sentinel"
-	"gopkg.in/yaml.v2"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 )
 
 var token *string
 var backendURL *string
 var interval *time.Duration
+var reconcile *time.Duration
+var walkWorkers *int
+var checksum *string
+var checksumRate *int64
+var scrubInterval *time.Duration
+var scrubRate *int64
+var scrubWindows *string
+var pathMap *string
+var relinkExperiments *bool
+var dryRun *bool
 var catapultBackend *catapult_sentinel.CatapultBackend
 
-func loadConfigYaml(filePath string, folderWatchingLocation int) {
-	fmt.Printf("loading config file %s\n", filePath)
-	configData := make(map[string]interface{})
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("Error loading yaml file %s", filePath)
-		return
+// reviseRunConfigs records a revision of every cat.yml file among files. The
+// scanner queues the ones to send to the backend along with their revision.
+func reviseRunConfigs(scanner *catapult_sentinel.Scanner, folder catapult_sentinel.FolderWatchingLocation, files []catapult_sentinel.File) {
+	for _, file := range files {
+		if !catapult_sentinel.IsRunConfig(filepath.Base(file.FilePath)) {
+			continue
+		}
+		change, ok, err := scanner.ReviseRunConfig(folder, file.FilePath)
+		if err != nil {
+			log.Printf("config file %s not sent:\n%v", file.FilePath, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		log.Printf("config file %s changed, sending revision %d", file.FilePath, change.Config.Revision)
+		if change.Config.Runnable() {
+			log.Printf("config file %s is runnable", change.Config.ConfigFilePath)
+		}
 	}
-	defer file.Close()
-	decoder := yaml.NewDecoder(file)
-	err = decoder.Decode(&configData)
-	if err != nil {
-		log.Printf("Error decoding yaml file %s", filePath)
-		return
+}
+
+func enqueueRunConfigChange(db *sql.DB, change catapult_sentinel.RunConfigChange) {
+	if change.Config.Runnable() {
+		log.Printf("config file %s is runnable", change.Config.ConfigFilePath)
 	}
-	if configData["cat_ready"] == true {
-		fmt.Printf("config file %s is ready\n", filePath)
-		parentFolder := filepath.Dir(filePath)
-		exp := catapult_sentinel.Experiment{ExperimentName: parentFolder}
-		config := catapult_sentinel.CatapultRunConfig{
-			ConfigFilePath:         filePath,
-			FolderWatchingLocation: folderWatchingLocation,
-			Experiment:             exp.Id,
-			Content:                configData,
+	if err := catapult_sentinel.EnqueueRunConfigChange(db, change); err != nil {
+		log.Println(err)
+	}
+}
+
+// hashPending hashes the files that became ready on every tick until ctx is
+// done, apart from the scans so that they do not wait on large acquisitions.
+func hashPending(ctx context.Context, scanner *catapult_sentinel.Scanner, folders []catapult_sentinel.FolderWatchingLocation, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, folder := range folders {
+				hashed, err := scanner.HashPending(ctx, folder)
+				if err != nil {
+					log.Println(err)
+				}
+				for _, file := range hashed {
+					log.Printf("file %s hashed as %s", file.FilePath, file.Checksum)
+				}
+			}
 		}
-		// Send HTTP request to create CatapultRunConfig
-		configJson, _ := json.Marshal(config)
-		catapultBackend.Client.Post(fmt.Sprintf("%s/api/catapult_run_config/", catapultBackend.Url), "application/json", bytes.NewBuffer(configJson))
-		fmt.Printf("config file %s loaded successfully\n", filePath)
 	}
 }
 
@@ -55,13 +87,27 @@ func main() {
 	backendURL = flag.String("backend-url", "http://localhost:8080", "The backend URL")
 	token = flag.String("token", "", "The token")
 	interval = flag.Duration("interval", time.Minute, "The scan interval")
+	walkWorkers = flag.Int("walk-workers", catapult_sentinel.DefaultWalkWorkers, "The number of directories listed at once per folder")
+	reconcile = flag.Duration("reconcile", catapult_sentinel.DefaultReconcileInterval, "The interval between full walks of watched folders")
+	checksum = flag.String("checksum", catapult_sentinel.ChecksumSHA256, "The checksum of ready files: sha256, xxh64 or none")
+	checksumRate = flag.Int64("checksum-rate", catapult_sentinel.DefaultChecksumBytesPerSecond, "The bytes read per second while hashing, 0 for no limit")
+	scrubInterval = flag.Duration("scrub-interval", time.Hour, "The interval between integrity scrubs, 0 to disable")
+	scrubRate = flag.Int64("scrub-rate", catapult_sentinel.DefaultScrubBytesPerSecond, "The bytes read per second while scrubbing, 0 for no limit")
+	scrubWindows = flag.String("scrub-windows", "", "Comma separated HH:MM-HH:MM times of day when scrubbing may run, empty for any time")
+	pathMap = flag.String("path-map", "", `Semicolon separated remote=local path prefixes, such as D:\watch_folder=/mnt/instruments/astral; append |fold or |exact to set case folding`)
+	relinkExperiments = flag.Bool("relink-experiments", false, "Point the backend files of every location at the experiments named by its experiment strategy, then exit")
+	dryRun = flag.Bool("dry-run", false, "With -relink-experiments, only print the changes")
 	flag.Parse()
 
-	catapultBackend = &catapult_sentinel.CatapultBackend{
-		Url:    *backendURL,
-		Client: &http.Client{},
-		Token:  *token,
+	catapultBackend = catapult_sentinel.NewCatapultBackend(*backendURL, *token)
+	paths, err := catapult_sentinel.ParsePathMappings(*pathMap)
+	if err != nil {
+		log.Fatal(err)
 	}
+	catapultBackend.Paths = paths
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	db, err := catapult_sentinel.InitDB("fileinfo.db")
 	if err != nil {
@@ -69,37 +115,112 @@ func main() {
 	}
 	defer db.Close()
 
-	folderWatchingLocations, err := catapultBackend.GetAllFolderWatchingLocations()
+	folderWatchingLocations, err := catapultBackend.GetAllFolderWatchingLocationsContext(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *relinkExperiments {
+		for _, folder := range folderWatchingLocations {
+			links, err := catapult_sentinel.RelinkExperiments(ctx, db, catapultBackend, folder, *dryRun)
+			for _, link := range links {
+				fmt.Printf("%s: experiment %d -> %d (%s)\n", link.FilePath, link.From, link.To, link.ExperimentName)
+			}
+			if err != nil {
+				log.Fatalf("location %s: %v", folder.FolderPath, err)
+			}
+		}
+		return
+	}
+
+	scanner := catapult_sentinel.NewScanner(db)
+	scanner.WalkWorkers = *walkWorkers
+	scanner.Outbox = true
+	scanner.Checksums = nil
+	if *checksum != "none" {
+		scanner.Checksums = catapult_sentinel.NewChecksummer(*checksum, *checksumRate)
+	}
+	watchers := make([]*catapult_sentinel.Watcher, 0, len(folderWatchingLocations))
+	for _, folder := range folderWatchingLocations {
+		watcher := catapult_sentinel.NewWatcher(scanner, folder)
+		watcher.ReconcileInterval = *reconcile
+		watcher.Start()
+		defer watcher.Close()
+		watchers = append(watchers, watcher)
+	}
+	if scanner.Checksums != nil {
+		go hashPending(ctx, scanner, folderWatchingLocations, *interval)
+	}
+	replayer := catapult_sentinel.NewOutboxReplayer(db, catapultBackend)
+
+	scrubber := catapult_sentinel.NewScrubber(db, *scrubRate)
+	scrubber.Outbox = true
+	scrubber.Windows, err = catapult_sentinel.ParseScrubWindows(*scrubWindows)
 	if err != nil {
 		log.Fatal(err)
 	}
+	var scrubTicks <-chan time.Time
+	if *scrubInterval > 0 {
+		scrubTicker := time.NewTicker(*scrubInterval)
+		defer scrubTicker.Stop()
+		scrubTicks = scrubTicker.C
+	}
 
 	ticker := time.NewTicker(*interval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			log.Println("shutting down")
+			return
+		case <-scrubTicks:
+			report, err := scrubber.Run(ctx)
+			if err != nil {
+				log.Println(err)
+			}
+			// queued by the scrubber to flag the backend files
+			for _, alert := range report.Alerts {
+				log.Printf("integrity check of %s failed: %s, expected %s, got %s %s", alert.FilePath, alert.Status, alert.ExpectedChecksum, alert.ActualChecksum, alert.Error)
+			}
 		case <-ticker.C:
-			for _, folder := range folderWatchingLocations {
-				tasks, err := catapult_sentinel.ScanFolder(folder, db)
+			for _, watcher := range watchers {
+				folder := watcher.Location
+				tasks, err := watcher.Poll(ctx)
 				if err != nil {
 					log.Println(err)
 					continue
 				}
-				// get list of paths from tasks.NewFile
-				var newPaths []string
-				for _, file := range tasks.NewFile {
-					newPaths = append(newPaths, file.FilePath)
+				if tasks.Health.Degraded {
+					log.Printf("location %s degraded, deletions suspended: %s", folder.FolderPath, tasks.Health.Reason)
 				}
-				if len(newPaths) > 0 {
-					results, err := catapultBackend.GetFiles(newPaths)
-					if err != nil {
-						log.Println(err)
-						continue
-					}
-
+				// the scanner queued every mutation along with the scan
+				for _, move := range tasks.MovedFile {
+					log.Printf("file %s moved to %s", move.From, move.File.FilePath)
 				}
-
+				for _, mismatch := range tasks.Mismatched {
+					log.Printf("cannot read metadata from file name: %s", mismatch.Error)
+				}
+				for _, file := range tasks.DeletedFile {
+					log.Printf("file %s is missing, marking it on the backend", file.FilePath)
+				}
+				// dependencies first, so that a new revision is not observed twice
+				changes, err := scanner.CheckRunConfigDependencies(folder)
+				if err != nil {
+					log.Println(err)
+				}
+				for _, change := range changes {
+					enqueueRunConfigChange(db, change)
+				}
+				reviseRunConfigs(scanner, folder, tasks.NewFile)
+				reviseRunConfigs(scanner, folder, tasks.ChangedFile)
+			}
+			if catapultBackend.CircuitOpen() {
+				log.Println("backend unavailable, pausing uploads")
+				continue
+			}
+			if _, err := replayer.Replay(ctx); err != nil {
+				log.Println(err)
 			}
 		}
 	}